@@ -79,6 +79,7 @@ type ExperimentResult struct {
 	JsonPatchSize        int     // Size of JSON patches (bytes)
 	TsonPatchSize        int     // Size of TSON patches (bytes)
 	SetTsonPatchSize     int     // Size of TSON patches generated by Set operation (bytes)
+	BinaryPatchSize      int     // Size of TSON patches in the binary encoding (bytes)
 	JsonProcessingTimeNs int64   // JSON processing time (nanoseconds)
 	TsonProcessingTimeNs int64   // TSON processing time (nanoseconds)
 	JsonBandwidthUsage   float64 // JSON bandwidth usage (KB/s)
@@ -141,6 +142,7 @@ func RealworldScenario() {
 			"JsonPatchSize",
 			"TsonPatchSize",
 			"SetTsonPatchSize",
+			"BinaryPatchSize",
 			"JsonProcessingTimeNs",
 			"TsonProcessingTimeNs",
 			"JsonBandwidthUsage",
@@ -212,6 +214,8 @@ func RealworldScenario() {
 		fmt.Printf("TSON patches: %d\n", results.TsonPatchCount)
 		fmt.Printf("Patch reduction: %.1f%%\n",
 			(1-float64(results.TsonPatchCount)/float64(results.JsonPatchCount))*100)
+		fmt.Printf("Patch size: JSON %d bytes, TSON %d bytes, binary TSON %d bytes\n",
+			results.JsonPatchSize, results.TsonPatchSize, results.BinaryPatchSize)
 		fmt.Printf("JSON processing time: %.2f ms\n", float64(results.JsonProcessingTimeNs)/1e6)
 		fmt.Printf("TSON processing time: %.2f ms\n", float64(results.TsonProcessingTimeNs)/1e6)
 		fmt.Printf("Processing time difference: %.1f%%\n",
@@ -249,6 +253,7 @@ func RealworldScenario() {
 			fmt.Sprintf("%d", results.JsonPatchSize),
 			fmt.Sprintf("%d", results.TsonPatchSize),
 			fmt.Sprintf("%d", results.SetTsonPatchSize),
+			fmt.Sprintf("%d", results.BinaryPatchSize),
 			fmt.Sprintf("%d", results.JsonProcessingTimeNs),
 			fmt.Sprintf("%d", results.TsonProcessingTimeNs),
 			fmt.Sprintf("%.2f", results.JsonBandwidthUsage),
@@ -580,6 +585,13 @@ func runRealisticExperiment(scenario string, timingWriter *csv.Writer) (Experime
 	setTsonPatchBytes, _ := json.Marshal(setTsonPatches)
 	setTsonPatchSize := len(setTsonPatchBytes)
 
+	// Calculate binary TSON patch size
+	binaryPatchBytes, err := marshalBinaryPatches(tsonPatches)
+	if err != nil {
+		fmt.Printf("Error encoding binary TSON patches: %v\n", err)
+	}
+	binaryPatchSize := len(binaryPatchBytes)
+
 	// Calculate bandwidth usage (KB/s)
 	simulationTimeSeconds := float64(simulationDurationSec)
 	jsonBandwidth := float64(jsonPatchSize) / simulationTimeSeconds / 1024
@@ -595,6 +607,7 @@ func runRealisticExperiment(scenario string, timingWriter *csv.Writer) (Experime
 	result.JsonPatchSize = jsonPatchSize
 	result.TsonPatchSize = tsonPatchSize
 	result.SetTsonPatchSize = setTsonPatchSize
+	result.BinaryPatchSize = binaryPatchSize
 	result.JsonProcessingTimeNs = jsonTotalProcessingTime
 	result.TsonProcessingTimeNs = tsonTotalProcessingTime
 	result.JsonBandwidthUsage = jsonBandwidth
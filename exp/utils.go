@@ -6,12 +6,23 @@ import (
 	"math/rand"
 	"reflect"
 	"strings"
+
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
 )
 
 //=============================================================================
 // Utility functions
 //=============================================================================
 
+// marshalBinaryPatches encodes the patches in the binary TSON patch encoding
+func marshalBinaryPatches(patches []TsonPatch) ([]byte, error) {
+	patch := make(tsonpatch.Patch, 0, len(patches))
+	for _, p := range patches {
+		patch = append(patch, tsonpatch.NewOperation(tsonpatch.OpType(p.Op), p.Path, p.Value, p.Timestamp))
+	}
+	return patch.MarshalBinary()
+}
+
 // calculateExpectedUpdateCount는 예상 업데이트 횟수를 계산합니다
 func calculateExpectedUpdateCount(vehicle *VehicleData, durationSec int) int {
 	totalExpectedUpdates := 0
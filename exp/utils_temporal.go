@@ -114,7 +114,10 @@ func (e *TemporalQueryExperiment) RunTemporalSnapshotExperiment(timestamps []int
 		// Logument temporal snapshot
 		// Run Logument temporal snapshot
 		lgmStart := time.Now()
-		lgmSnapshot := e.LogumentDoc.TemporalSnapshot(ts)
+		lgmSnapshot, err := e.LogumentDoc.TemporalSnapshot(ts)
+		if err != nil {
+			return nil, fmt.Errorf("Logument temporal snapshot failed: %v", err)
+		}
 		result.LogumentTimeNs = time.Since(lgmStart).Nanoseconds()
 
 		// Convert to bytes to measure size
@@ -172,7 +175,10 @@ func (e *TemporalQueryExperiment) RunTemporalTrackExperiment(paths []string, sta
 
 		// Run Logument temporal track
 		lgmStart := time.Now()
-		lgmTrack := e.LogumentDoc.TemporalTrack(startTime, endTime)
+		lgmTrack, err := e.LogumentDoc.TemporalTrack(startTime, endTime)
+		if err != nil {
+			return nil, fmt.Errorf("Logument temporal track failed: %v", err)
+		}
 		result.LogumentTimeNs = time.Since(lgmStart).Nanoseconds()
 
 		// Filter to the desired path and convert to bytes to measure size
@@ -385,7 +391,10 @@ func createLogumentFromPatches(path string, patches []TsonPatch) *logument.Logum
 	}
 
 	// Create the Logument
-	lgm := logument.NewLogument(initialTson, tsonPatches)
+	lgm, err := logument.NewLogument(initialTson, tsonPatches)
+	if err != nil {
+		panic(err)
+	}
 	return lgm
 }
 
@@ -460,7 +469,10 @@ func filterTrackByPath(track map[uint64]tsonpatch.Patch, path string) map[uint64
 // Helper function to find events in Logument that exceed a threshold
 func findEventsInLogument(lgm *logument.Logument, path string, startTime, endTime int64, threshold float64) []int64 {
 	// Get tracked changes in the time range
-	changes := lgm.TemporalTrack(startTime, endTime)
+	changes, err := lgm.TemporalTrack(startTime, endTime)
+	if err != nil {
+		return nil
+	}
 
 	// Find all timestamps where the value exceeds the threshold
 	eventTimes := make([]int64, 0)
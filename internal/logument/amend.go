@@ -0,0 +1,185 @@
+//
+// amend.go
+//
+// Late corrections of appended versions.
+//
+// `Set` (and `Unset`) normally write to the next version, i.e. the
+// PatchPool. A correction that arrives late may instead target an
+// appended version: its patch is added to the end of that version,
+// and the snapshots that no longer hold are dropped, so that every
+// query sees the corrected history. The versions are replayed from the
+// amended one up to the first snapshot that still holds, as the
+// following versions are then left as they were.
+//
+
+package logument
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+)
+
+// settable checks that a patch can be set at the version vk:
+// the next version, or an appended version after the first one,
+// whose patches are already folded into the base snapshot.
+func (lgm *Logument) settable(vk uint64) error {
+	first, latest := lgm.Version[0], lgm.Version[len(lgm.Version)-1]
+	switch {
+	case vk == latest+1, vk > first && vk <= latest:
+		return nil
+	case first == latest:
+		return fmt.Errorf("%w: cannot set at version %d, only at the next version %d", ErrVersionOutOfRange, vk, latest+1)
+	default:
+		return fmt.Errorf("%w: cannot set at version %d, only at the next version %d or amend a version between %d and %d",
+			ErrVersionOutOfRange, vk, latest+1, first+1, latest)
+	}
+}
+
+// stateAt returns the state a patch set at the version vk is compared against:
+// the CurrentState for the next version, or the snapshot of an appended version.
+func (lgm *Logument) stateAt(vk uint64) (tsonSnapshot, error) {
+	if err := lgm.settable(vk); err != nil {
+		return nil, err
+	}
+	if latest := lgm.Version[len(lgm.Version)-1]; vk > latest {
+		return lgm.CurrentState, nil
+	}
+	return lgm.snapshot(vk)
+}
+
+// amend adds the patches of amended to the end of their appended versions.
+// The following versions and the PatchPool must still apply on top of them,
+// otherwise the Logument is left unchanged.
+func (lgm *Logument) amend(amended map[uint64]tsonPatches) error {
+	versions := make(map[uint64]tsonPatches, len(amended))
+	for v, ops := range amended {
+		versions[v] = append(slices.Clone(lgm.Patches[v]), ops...)
+	}
+	state, stale, err := lgm.rewrittenState(versions)
+	if err != nil {
+		return err
+	}
+	if err := lgm.logVersions(walSet, amended); err != nil {
+		return err
+	}
+	return lgm.rewriteVersions(versions, state, stale)
+}
+
+// merge inserts the patches of late into their appended versions in timestamp order,
+// i.e. before the first patch of the version with a greater timestamp.
+// Like `amend`, the Logument is left unchanged if the versions no longer apply.
+func (lgm *Logument) merge(late map[uint64]tsonPatches) error {
+	versions := lgm.mergedVersions(late)
+	state, stale, err := lgm.rewrittenState(versions)
+	if err != nil {
+		return err
+	}
+	if err := lgm.logVersions(walMerge, late); err != nil {
+		return err
+	}
+	return lgm.rewriteVersions(versions, state, stale)
+}
+
+// mergedVersions returns the patches of the versions of late, with the late patches merged in
+func (lgm *Logument) mergedVersions(late map[uint64]tsonPatches) map[uint64]tsonPatches {
+	versions := make(map[uint64]tsonPatches, len(late))
+	for v, ops := range late {
+		patches := slices.Clone(lgm.Patches[v])
+		for _, op := range ops {
+			i := slices.IndexFunc(patches, func(p tsonpatch.Operation) bool { return p.Timestamp > op.Timestamp })
+			if i < 0 {
+				i = len(patches)
+			}
+			patches = slices.Insert(patches, i, op)
+		}
+		versions[v] = patches
+	}
+	return versions
+}
+
+// rewrittenState returns the CurrentState once the appended versions
+// are replaced by those of versions, without changing the Logument,
+// along with the versions whose snapshots no longer hold.
+func (lgm *Logument) rewrittenState(versions map[uint64]tsonPatches) (tsonSnapshot, []uint64, error) {
+	keys := slices.Collect(maps.Keys(versions))
+	from, to := slices.Min(keys), slices.Max(keys)
+	previous, err := lgm.snapshot(from - 1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Replay from the previous version, which ApplyPatch leaves as it is
+	var stale []uint64
+	state := previous
+	latest := lgm.Version[len(lgm.Version)-1]
+	for v := from; v <= latest; v++ {
+		patches, rewritten := versions[v]
+		if !rewritten {
+			patches = lgm.Patches[v]
+		}
+		if state, err = tsonpatch.ApplyPatch(state, patches); err != nil {
+			return nil, nil, fmt.Errorf("%w: the patches of version %d no longer apply after rewriting version %d: %w", ErrInvalidPatch, v, from, err)
+		}
+
+		snapshot, exists := lgm.Snapshots[v]
+		if !exists {
+			continue
+		}
+		if eq, err := tson.Equal(snapshot, state); err != nil || !eq {
+			stale = append(stale, v)
+		} else if v >= to { // The following versions, and the CurrentState, are left as they were
+			return lgm.CurrentState, stale, nil
+		}
+	}
+	if state, err = tsonpatch.ApplyPatch(state, lgm.PatchPool); err != nil {
+		return nil, nil, fmt.Errorf("%w: the PatchPool no longer applies after rewriting version %d: %w", ErrInvalidPatch, from, err)
+	}
+	return state, stale, nil
+}
+
+// logVersions writes a record of the given kind for each version of patches to the WAL
+func (lgm *Logument) logVersions(kind string, patches map[uint64]tsonPatches) error {
+	for _, v := range slices.Sorted(maps.Keys(patches)) {
+		if err := lgm.logRecord(walRecord{Kind: kind, Version: v, Patch: patches[v]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteVersions replaces the patches of the appended versions by those of versions,
+// with state as the new CurrentState, and drops the snapshots of the stale versions
+func (lgm *Logument) rewriteVersions(versions map[uint64]tsonPatches, state tsonSnapshot, stale []uint64) error {
+	if err := lgm.dropSnapshots(stale); err != nil {
+		return err
+	}
+	for v, patches := range versions {
+		lgm.index.remove(v, lgm.Patches[v])
+		lgm.index.add(v, patches)
+		lgm.Patches[v] = patches
+	}
+	lgm.CurrentState = state
+	lgm.generation++
+	lgm.recountCheckpoint()
+
+	return nil
+}
+
+// dropSnapshots drops the snapshots of the given versions,
+// which no longer hold after an amendment.
+func (lgm *Logument) dropSnapshots(versions []uint64) error {
+	stale := func(version uint64) bool { return slices.Contains(versions, version) }
+	if s := lgm.storage; s != nil {
+		if err := s.removeSnapshots(lgm.Snapshots, stale); err != nil {
+			return err
+		}
+	}
+	for _, version := range versions {
+		delete(lgm.Snapshots, version)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package logument_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/CAU-CPSS/logument/internal/logument"
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAmend(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	for _, p := range patches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+	_, err := lgm.Snapshot(1)
+	assert.Nil(t, err)
+	_, err = lgm.Snapshot(3)
+	assert.Nil(t, err)
+
+	// A late correction of version 2
+	correction := tsonpatch.NewOperation(tsonpatch.OpReplace, "/speed", 80.0, 2050000000)
+	assert.Nil(t, lgm.Set(2, correction))
+	assert.Equal(t, correction, lgm.Patches[2][len(lgm.Patches[2])-1])
+	assert.Empty(t, lgm.PatchPool)
+	assert.Contains(t, lgm.Snapshots, uint64(1))
+	assert.NotContains(t, lgm.Snapshots, uint64(3))
+
+	value, err := lgm.ValueAtVersion("/speed", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, logument.PointValue{Value: tson.Leaf[float64]{Value: 80.0, Timestamp: 2050000000}, Timestamp: 2050000000, Version: 2}, value)
+	snapshot, err := lgm.Snapshot(4)
+	assert.Nil(t, err)
+	eq, err := tson.Equal(snapshot, lgm.CurrentState)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	// TestSet compares against the amended version, or the CurrentState for the next one
+	n := len(lgm.Patches[2])
+	assert.Nil(t, lgm.TestSet(2, tsonpatch.NewOperation(tsonpatch.OpReplace, "/speed", 80.0, 2060000000)))
+	assert.Nil(t, lgm.TestSet(5, tsonpatch.NewOperation(tsonpatch.OpReplace, "/speed", 94.9, 2500000000)))
+	assert.Len(t, lgm.Patches[2], n)
+	assert.Empty(t, lgm.PatchPool)
+	assert.Nil(t, lgm.TestSet(2, tsonpatch.NewOperation(tsonpatch.OpReplace, "/speed", 81.0, 2060000000)))
+	assert.Len(t, lgm.Patches[2], n+1)
+
+	// The base snapshot and unknown versions cannot be set
+	assert.ErrorIs(t, lgm.Set(0, correction), logument.ErrVersionOutOfRange)
+	assert.ErrorIs(t, lgm.Set(6, correction), logument.ErrVersionOutOfRange)
+	assert.ErrorIs(t, lgm.TestSet(6, correction), logument.ErrVersionOutOfRange)
+}
+
+func TestAmendConcurrentSnapshot(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	for _, p := range patches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+
+	// Snapshots built while version 2 is amended are not kept once they no longer hold
+	const rounds = 50
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range rounds {
+			assert.Nil(t, lgm.Set(2, tsonpatch.NewOperation(tsonpatch.OpReplace, "/speed", float64(i), int64(2050000000+i))))
+		}
+	}()
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				for v := uint64(1); v <= 4; v++ {
+					_, err := lgm.Snapshot(v)
+					assert.Nil(t, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	replayed := newLogument(t, initSnapshot, nil)
+	for v := uint64(1); v <= 4; v++ {
+		assert.Nil(t, replayed.Store(lgm.Patches[v]))
+		assert.Nil(t, replayed.Append())
+
+		expected, err := replayed.Snapshot(v)
+		assert.Nil(t, err)
+		actual, err := lgm.Snapshot(v)
+		assert.Nil(t, err)
+		eq, err := tson.Equal(expected, actual)
+		assert.Nil(t, err)
+		assert.True(t, eq, "version %d", v)
+	}
+}
+
+func TestAmendConflict(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	assert.Nil(t, lgm.Unset(1, "/tirePressure/3", 1800000000))
+	assert.Nil(t, lgm.Append())
+	assert.Nil(t, lgm.Unset(2, "/tirePressure/2", 1900000000))
+	assert.Nil(t, lgm.Append())
+
+	// Version 2 no longer applies once version 1 removes another element
+	err := lgm.Unset(1, "/tirePressure/0", 1800000000)
+	assert.ErrorIs(t, err, logument.ErrInvalidPatch)
+	assert.Len(t, lgm.Patches[1], 1)
+
+	snapshot, err := lgm.Snapshot(2)
+	assert.Nil(t, err)
+	eq, err := tson.Equal(snapshot, lgm.CurrentState)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+}
+
+func TestRecoverAmended(t *testing.T) {
+	dir := t.TempDir()
+
+	lgm, err := logument.Open(dir, initSnapshot, logument.StorageOptions{})
+	assert.Nil(t, err)
+	for _, p := range patches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+	assert.Nil(t, lgm.Checkpoint())
+	assert.Nil(t, lgm.Set(2, tsonpatch.NewOperation(tsonpatch.OpReplace, "/vehicleId", "XYZ9876", 2050000000)))
+	assert.Nil(t, lgm.Close())
+
+	// The snapshot file of version 4 no longer holds
+	_, err = os.Stat(filepath.Join(dir, "snapshots", "4.tson"))
+	assert.True(t, os.IsNotExist(err))
+
+	recovered, err := logument.Open(dir, nil, logument.StorageOptions{})
+	assert.Nil(t, err)
+	defer recovered.Close()
+
+	assert.Equal(t, lgm.Patches, recovered.Patches)
+	assert.Equal(t, len(lgm.Snapshots), len(recovered.Snapshots))
+	assert.NotContains(t, recovered.Snapshots, uint64(4))
+	eq, err := tson.Equal(lgm.CurrentState, recovered.CurrentState)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+}
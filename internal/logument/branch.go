@@ -0,0 +1,211 @@
+//
+// branch.go
+//
+// Named branches of a Logument.
+//
+// A branch forks from a version of the mainline, and is a Logument of
+// its own: it starts at that version (see `FirstVersion`), and stores
+// and appends its own patches, e.g. to replay a what-if scenario.
+// It can be compared with the mainline by `Diff`, and merged back by
+// `Merge`, where the last writer wins on each path.
+// Branches are kept in memory only, and are not persisted by `Open`.
+//
+
+package logument
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+)
+
+// branch is a named branch forked from the mainline
+type branch struct {
+	lgm  *Logument // The Logument of the branch
+	base uint64    // The version of the mainline the branch forked from
+}
+
+// MergeConflict is a path changed by both the mainline and a branch since the fork
+type MergeConflict struct {
+	Path       string              // The canonical path
+	Main       tsonpatch.Operation // The last change of the mainline
+	Branch     tsonpatch.Operation // The last change of the branch
+	BranchWins bool                // The branch wrote last (by timestamp), so its change was merged
+}
+
+// MergeReport describes the changes `Merge` took from a branch
+type MergeReport struct {
+	Branch    string          // The name of the merged branch
+	Merged    tsonPatches     // The changes stored in the PatchPool, in timestamp order
+	Conflicts []MergeConflict // The paths changed on both sides, sorted by path
+}
+
+// Fork creates the branch name from the version vk, and returns it.
+func (lgm *Logument) Fork(name string, vk uint64) (*Logument, error) {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	if _, exists := lgm.branches[name]; exists {
+		return nil, fmt.Errorf("%w: %s", ErrBranchExists, name)
+	}
+	first, latest := lgm.Version[0], lgm.Version[len(lgm.Version)-1]
+	if vk < first || vk > latest {
+		return nil, fmt.Errorf("%w: version %d is not between %d and %d", ErrVersionOutOfRange, vk, first, latest)
+	}
+
+	snapshot, err := lgm.snapshot(vk)
+	if err != nil {
+		return nil, err
+	}
+	// The branch shares the snapshot with the mainline, as patches do not modify it in place
+	base, state := snapshot, snapshot
+
+	forked := &Logument{
+		Version:      []uint64{vk},
+		CurrentState: state,
+		Snapshots:    map[uint64]tsonSnapshot{vk: base},
+		Patches:      make(map[uint64]tsonPatches),
+		PatchPool:    nil,
+		index:        newPathIndex(),
+	}
+	if lgm.branches == nil {
+		lgm.branches = make(map[string]branch)
+	}
+	lgm.branches[name] = branch{lgm: forked, base: vk}
+
+	return forked, nil
+}
+
+// Branch returns the branch name.
+func (lgm *Logument) Branch(name string) (*Logument, error) {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	b, exists := lgm.branches[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	return b.lgm, nil
+}
+
+// Branches returns the names of the branches, sorted.
+func (lgm *Logument) Branches() []string {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	names := make([]string, 0, len(lgm.branches))
+	for name := range lgm.branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeleteBranch deletes the branch name.
+func (lgm *Logument) DeleteBranch(name string) error {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	if _, exists := lgm.branches[name]; !exists {
+		return fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	delete(lgm.branches, name)
+	return nil
+}
+
+// Diff returns the patch that turns the CurrentState of the mainline
+// into the CurrentState of the branch name.
+func (lgm *Logument) Diff(name string) (tsonPatches, error) {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	b, exists := lgm.branches[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+
+	b.lgm.mu.RLock()
+	defer b.lgm.mu.RUnlock()
+
+	patch, err := tsonpatch.GeneratePatch(lgm.CurrentState, b.lgm.CurrentState)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+	}
+	return patch, nil
+}
+
+// Merge stores the changes of the branch name since the fork in the PatchPool,
+// to be appended by `Append`. If both the mainline and the branch changed
+// a path since the fork, the change with the later timestamp wins
+// (the mainline on a tie).
+func (lgm *Logument) Merge(name string) (MergeReport, error) {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	b, exists := lgm.branches[name]
+	if !exists {
+		return MergeReport{}, fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	if first := lgm.Version[0]; b.base < first {
+		return MergeReport{}, fmt.Errorf("%w: branch %s forked from version %d, before the first version %d",
+			ErrVersionOutOfRange, name, b.base, first)
+	}
+
+	b.lgm.mu.RLock()
+	theirs := b.lgm.lastChanges(b.base)
+	b.lgm.mu.RUnlock()
+	ours := lgm.lastChanges(b.base)
+
+	report := MergeReport{Branch: name}
+	var merged []OrderedPatch
+	for path, their := range theirs {
+		our, changed := ours[path]
+		if !changed {
+			merged = append(merged, their)
+			continue
+		}
+		if our.Op.Op == their.Op.Op && sameValue(our.Op.Value, their.Op.Value) {
+			continue // Both sides made the same change
+		}
+		conflict := MergeConflict{Path: path, Main: our.Op, Branch: their.Op, BranchWins: their.Op.Timestamp > our.Op.Timestamp}
+		report.Conflicts = append(report.Conflicts, conflict)
+		if conflict.BranchWins {
+			merged = append(merged, their)
+		}
+	}
+	sort.Slice(report.Conflicts, func(i, j int) bool { return report.Conflicts[i].Path < report.Conflicts[j].Path })
+
+	SortPatches(merged)
+	for _, e := range merged {
+		report.Merged = append(report.Merged, e.Op)
+	}
+	if len(report.Merged) == 0 {
+		return report, nil
+	}
+	if err := lgm.store(report.Merged); err != nil {
+		return MergeReport{}, err
+	}
+	return report, nil
+}
+
+// lastChanges returns the last patch (by timestamp) of every path
+// changed after the version vk, including the PatchPool.
+func (lgm *Logument) lastChanges(vk uint64) map[string]OrderedPatch {
+	last := make(map[string]OrderedPatch)
+	visit := func(v uint64, patches tsonPatches) {
+		for seq, p := range patches {
+			e := OrderedPatch{Version: v, Seq: seq, Op: p}
+			path := canonicalPath(p.Path)
+			if l, exists := last[path]; !exists || l.Compare(e) < 0 {
+				last[path] = e
+			}
+		}
+	}
+	latest := lgm.Version[len(lgm.Version)-1]
+	for v := vk + 1; v <= latest; v++ {
+		visit(v, lgm.Patches[v])
+	}
+	visit(latest+1, lgm.PatchPool)
+	return last
+}
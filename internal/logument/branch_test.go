@@ -0,0 +1,108 @@
+package logument_test
+
+import (
+	"testing"
+
+	"github.com/CAU-CPSS/logument/internal/logument"
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBranch(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	for _, p := range patches[:2] {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+
+	// What if the driver had braked earlier?
+	brake, err := lgm.Fork("brake", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), brake.FirstVersion())
+	assert.Nil(t, brake.Store(`[
+		{ "op": "replace", "path": "/engineOn", "value": true, "timestamp": 1950000000 },
+		{ "op": "add", "path": "/brake", "value": 1.0, "timestamp": 2050000000 },
+		{ "op": "replace", "path": "/speed", "value": 30.0, "timestamp": 2500000000 }
+	]`))
+	assert.Nil(t, brake.Append())
+	assert.Equal(t, []uint64{1, 2}, brake.Version)
+
+	// The mainline goes on
+	for _, p := range patches[2:] {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+
+	branch, err := lgm.Branch("brake")
+	assert.Nil(t, err)
+	assert.Same(t, brake, branch)
+	assert.Equal(t, []string{"brake"}, lgm.Branches())
+
+	// The diff turns the mainline into the branch
+	diff, err := lgm.Diff("brake")
+	assert.Nil(t, err)
+	state, err := tson.Clone(lgm.CurrentState)
+	assert.Nil(t, err)
+	state, err = tsonpatch.ApplyPatch(state, diff)
+	assert.Nil(t, err)
+	eq, err := tson.EqualWithoutTimestamp(brake.CurrentState, state)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	report, err := lgm.Merge("brake")
+	assert.Nil(t, err)
+	assert.Equal(t, tsonpatch.Patch{
+		tsonpatch.NewOperation(tsonpatch.OpAdd, "/brake", 1.0, 2050000000),
+		tsonpatch.NewOperation(tsonpatch.OpReplace, "/speed", 30.0, 2500000000),
+	}, report.Merged)
+	assert.Len(t, report.Conflicts, 2)
+	// The mainline turned the engine off later
+	assert.Equal(t, "/engineOn", report.Conflicts[0].Path)
+	assert.False(t, report.Conflicts[0].BranchWins)
+	assert.Equal(t, "/speed", report.Conflicts[1].Path)
+	assert.True(t, report.Conflicts[1].BranchWins)
+
+	assert.Equal(t, report.Merged, lgm.PatchPool)
+	assert.Nil(t, lgm.Append())
+	speed, err := lgm.ValueAt("/speed", 2500000000)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[float64]{Value: 30.0, Timestamp: 2500000000}, speed.Value)
+
+	// Merging again changes nothing
+	report, err = lgm.Merge("brake")
+	assert.Nil(t, err)
+	assert.Empty(t, report.Merged)
+	assert.Empty(t, lgm.PatchPool)
+
+	assert.Nil(t, lgm.DeleteBranch("brake"))
+	assert.Empty(t, lgm.Branches())
+}
+
+func TestBranchErrors(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	lgm.Store(patches[0])
+	assert.Nil(t, lgm.Append())
+
+	_, err := lgm.Fork("what-if", 2)
+	assert.ErrorIs(t, err, logument.ErrVersionOutOfRange)
+	_, err = lgm.Fork("what-if", 0)
+	assert.Nil(t, err)
+	_, err = lgm.Fork("what-if", 1)
+	assert.ErrorIs(t, err, logument.ErrBranchExists)
+
+	_, err = lgm.Branch("unknown")
+	assert.ErrorIs(t, err, logument.ErrBranchNotFound)
+	_, err = lgm.Diff("unknown")
+	assert.ErrorIs(t, err, logument.ErrBranchNotFound)
+	_, err = lgm.Merge("unknown")
+	assert.ErrorIs(t, err, logument.ErrBranchNotFound)
+	assert.ErrorIs(t, lgm.DeleteBranch("unknown"), logument.ErrBranchNotFound)
+
+	// The fork point was truncated away
+	lgm.Store(patches[1])
+	assert.Nil(t, lgm.Append())
+	assert.Nil(t, lgm.Truncate(1))
+	_, err = lgm.Merge("what-if")
+	assert.ErrorIs(t, err, logument.ErrVersionOutOfRange)
+}
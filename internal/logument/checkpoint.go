@@ -0,0 +1,95 @@
+//
+// checkpoint.go
+//
+// Automatic checkpointing of a Logument.
+//
+// `Snapshot` replays the patches from the nearest stored snapshot,
+// so a Logument without recent snapshots gets slower as it grows.
+// A CheckpointPolicy makes `Append` take a snapshot of the latest
+// version on its own, which bounds the number of patches to replay.
+//
+
+package logument
+
+import "encoding/json"
+
+// CheckpointPolicy decides when `Append` takes a snapshot of the latest version.
+// Each rule is disabled by its zero value, and a checkpoint is taken
+// as soon as any enabled rule is met.
+type CheckpointPolicy struct {
+	EveryVersions uint64 // Take a snapshot at every version that is a multiple of N
+	EveryPatches  int    // Take a snapshot once M patches were appended since the latest snapshot
+	EverySpan     int64  // Take a snapshot once the patches since the latest snapshot span T of timestamps (ns)
+	EveryBytes    int    // Take a snapshot once the patches since the latest snapshot reach this size (in JSON bytes)
+}
+
+// checkpointStats counts the patches appended since the latest snapshot
+type checkpointStats struct {
+	patches      int
+	bytes        int
+	minTimestamp int64
+	maxTimestamp int64
+}
+
+// add counts the patches of a newly appended version
+func (cs *checkpointStats) add(patches tsonPatches) {
+	for _, p := range patches {
+		if cs.patches == 0 || p.Timestamp < cs.minTimestamp {
+			cs.minTimestamp = p.Timestamp
+		}
+		if cs.patches == 0 || p.Timestamp > cs.maxTimestamp {
+			cs.maxTimestamp = p.Timestamp
+		}
+		cs.patches++
+	}
+	cs.bytes += patchesSize(patches)
+}
+
+// patchesSize returns the size of patches in JSON bytes
+func patchesSize(patches tsonPatches) int {
+	// Patches were already valid JSON when stored, so marshaling them cannot fail
+	b, _ := json.Marshal(patches)
+	return len(b)
+}
+
+// due tells whether the policy asks for a snapshot of version v
+func (p CheckpointPolicy) due(v uint64, cs checkpointStats) bool {
+	switch {
+	case p.EveryVersions > 0 && v%p.EveryVersions == 0:
+		return true
+	case p.EveryPatches > 0 && cs.patches >= p.EveryPatches:
+		return true
+	case p.EverySpan > 0 && cs.patches > 0 && cs.maxTimestamp-cs.minTimestamp >= p.EverySpan:
+		return true
+	case p.EveryBytes > 0 && cs.bytes >= p.EveryBytes:
+		return true
+	}
+	return false
+}
+
+// SetCheckpointPolicy sets the policy `Append` follows to take snapshots automatically.
+func (lgm *Logument) SetCheckpointPolicy(policy CheckpointPolicy) {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	lgm.policy = policy
+}
+
+// CheckpointPolicy returns the current checkpoint policy.
+func (lgm *Logument) CheckpointPolicy() CheckpointPolicy {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	return lgm.policy
+}
+
+// recountCheckpoint recounts the patches appended after the latest snapshot
+func (lgm *Logument) recountCheckpoint() {
+	lgm.sinceCheckpoint = checkpointStats{}
+
+	latestVersion := lgm.Version[len(lgm.Version)-1]
+	versions := lgm.getSortedVersions("snapshot")
+	for v := versions[len(versions)-1] + 1; v <= latestVersion; v++ {
+		lgm.sinceCheckpoint.add(lgm.Patches[v])
+	}
+}
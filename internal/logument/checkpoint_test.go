@@ -0,0 +1,71 @@
+package logument_test
+
+import (
+	"testing"
+
+	"github.com/CAU-CPSS/logument/internal/logument"
+	"github.com/stretchr/testify/assert"
+)
+
+// snapshotVersions appends all the test patches, one version each,
+// and returns the versions that have a snapshot.
+func snapshotVersions(t *testing.T, policy logument.CheckpointPolicy) []uint64 {
+	t.Helper()
+	lgm := newLogument(t, initSnapshot, nil)
+	lgm.SetCheckpointPolicy(policy)
+	for _, p := range patches {
+		assert.Nil(t, lgm.Store(p))
+		assert.Nil(t, lgm.Append())
+	}
+
+	var versions []uint64
+	for _, v := range lgm.Version {
+		if _, exists := lgm.Snapshots[v]; exists {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+func TestCheckpointPolicy(t *testing.T) {
+	// Patch counts of the versions 1 to 4 are 3, 1, 2, 2
+	// and their timestamps span from 1800000000 to 2400000000
+	tests := []struct {
+		name     string
+		policy   logument.CheckpointPolicy
+		expected []uint64
+	}{
+		{"disabled", logument.CheckpointPolicy{}, []uint64{0}},
+		{"versions", logument.CheckpointPolicy{EveryVersions: 2}, []uint64{0, 2, 4}},
+		{"patches", logument.CheckpointPolicy{EveryPatches: 3}, []uint64{0, 1, 3}},
+		{"span", logument.CheckpointPolicy{EverySpan: 200000000}, []uint64{0, 2, 4}},
+		{"bytes", logument.CheckpointPolicy{EveryBytes: 1}, []uint64{0, 1, 2, 3, 4}},
+		{"any rule", logument.CheckpointPolicy{EveryVersions: 4, EveryPatches: 3}, []uint64{0, 1, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, snapshotVersions(t, tt.policy))
+		})
+	}
+}
+
+func TestCheckpointPolicyAfterRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	lgm, err := logument.Open(dir, initSnapshot, logument.StorageOptions{})
+	assert.Nil(t, err)
+	lgm.Store(patches[0])
+	assert.Nil(t, lgm.Append()) // 3 patches since the snapshot of version 0
+	assert.Nil(t, lgm.Close())
+
+	recovered, err := logument.Open(dir, nil, logument.StorageOptions{})
+	assert.Nil(t, err)
+	defer recovered.Close()
+
+	// The patches appended before the recovery still count
+	recovered.SetCheckpointPolicy(logument.CheckpointPolicy{EveryPatches: 4})
+	recovered.Store(patches[1])
+	assert.Nil(t, recovered.Append())
+	assert.Contains(t, recovered.Snapshots, uint64(2))
+}
@@ -0,0 +1,168 @@
+//
+// combine.go
+//
+// Merging the Loguments of several sources into one timeline.
+//
+// Each ECU of a vehicle (e.g. infotainment, ADAS, BMS) uploads a
+// Logument of its own, all describing the same vehicle tree.
+// `Combine` interleaves their patches by timestamp into a new Logument,
+// whose base snapshot combines the base snapshots of the sources.
+//
+
+package logument
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+)
+
+// CombineConflict is a path written differently by several sources at the same timestamp
+type CombineConflict struct {
+	Path      string      // The canonical path
+	Timestamp int64       // The timestamp of the writes
+	Sources   []int       // The sources that wrote the path (by their index), in order
+	Ops       tsonPatches // The writes of the sources, in the same order; the last one wins
+}
+
+// sourcePatch is an appended patch of a source
+type sourcePatch struct {
+	OrderedPatch
+	source int
+}
+
+// Combine merges the appended patches of the sources into a new Logument,
+// with a version for every distinct timestamp. Its base snapshot (version 0)
+// combines the base snapshots of the sources, the leaf with the latest
+// timestamp winning on each path.
+//
+// Patches with the same timestamp are ordered by source, so the last
+// source wins when several sources write a path at the same timestamp;
+// such writes are reported as conflicts when they differ.
+// The PatchPools of the sources are not merged.
+func Combine(sources ...*Logument) (*Logument, []CombineConflict, error) {
+	if len(sources) == 0 {
+		return nil, nil, ErrNoSources
+	}
+
+	var (
+		base    tsonSnapshot
+		patches []sourcePatch
+	)
+	for i, src := range sources {
+		src.mu.RLock()
+		if !src.isContinuous() {
+			src.mu.RUnlock()
+			return nil, nil, fmt.Errorf("%w: source %d", ErrNotContinuous, i)
+		}
+		var err error
+		if base, err = combineBase(base, src.Snapshots[src.Version[0]]); err != nil {
+			src.mu.RUnlock()
+			return nil, nil, fmt.Errorf("%w: source %d: %w", ErrInvalidSnapshot, i, err)
+		}
+		for _, v := range src.Version[1:] {
+			for seq, p := range src.Patches[v] {
+				patches = append(patches, sourcePatch{OrderedPatch{Version: v, Seq: seq, Op: p}, i})
+			}
+		}
+		src.mu.RUnlock()
+	}
+
+	slices.SortFunc(patches, func(a, b sourcePatch) int {
+		return cmp.Or(
+			cmp.Compare(a.Op.Timestamp, b.Op.Timestamp),
+			cmp.Compare(a.source, b.source),
+			a.Compare(b.OrderedPatch),
+		)
+	})
+
+	merged, err := NewLogument(base, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var conflicts []CombineConflict
+	for i := 0; i < len(patches); {
+		j := i + 1
+		for j < len(patches) && patches[j].Op.Timestamp == patches[i].Op.Timestamp {
+			j++
+		}
+		frame := patches[i:j]
+		conflicts = append(conflicts, frameConflicts(frame)...)
+
+		v := uint64(len(merged.Version))
+		ops := make(tsonPatches, len(frame))
+		for k, p := range frame {
+			ops[k] = p.Op
+		}
+		if merged.CurrentState, err = tsonpatch.ApplyPatch(merged.CurrentState, ops); err != nil {
+			return nil, nil, fmt.Errorf("%w: failed to apply the patches at %d: %w", ErrInvalidPatch, frame[0].Op.Timestamp, err)
+		}
+		merged.Patches[v] = ops
+		merged.Version = append(merged.Version, v)
+		i = j
+	}
+	merged.rebuildIndex()
+	merged.recountCheckpoint()
+
+	return merged, conflicts, nil
+}
+
+// frameConflicts returns the conflicts among patches of the same timestamp
+func frameConflicts(frame []sourcePatch) []CombineConflict {
+	byPath := make(map[string][]sourcePatch)
+	var paths []string
+	for _, p := range frame {
+		path := canonicalPath(p.Op.Path)
+		if _, exists := byPath[path]; !exists {
+			paths = append(paths, path)
+		}
+		byPath[path] = append(byPath[path], p)
+	}
+
+	var conflicts []CombineConflict
+	for _, path := range paths {
+		writes := byPath[path]
+		differ := false
+		for _, w := range writes[1:] {
+			if w.source != writes[0].source && (w.Op.Op != writes[0].Op.Op || !sameValue(w.Op.Value, writes[0].Op.Value)) {
+				differ = true
+				break
+			}
+		}
+		if !differ {
+			continue
+		}
+		c := CombineConflict{Path: path, Timestamp: writes[0].Op.Timestamp}
+		for _, w := range writes {
+			c.Sources = append(c.Sources, w.source)
+			c.Ops = append(c.Ops, w.Op)
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts
+}
+
+// combineBase merges a copy of the snapshot src into dst (if any), and returns the result.
+// Objects are merged member by member; otherwise the value with
+// the latest timestamp wins, or src on a tie.
+func combineBase(dst, src tson.Value) (tson.Value, error) {
+	dstObj, dstIsObj := dst.(tson.Object)
+	srcObj, srcIsObj := src.(tson.Object)
+	if dstIsObj && srcIsObj {
+		for key, value := range srcObj {
+			merged, err := combineBase(dstObj[key], value)
+			if err != nil {
+				return nil, err
+			}
+			dstObj[key] = merged
+		}
+		return dstObj, nil
+	}
+	if dst != nil && tson.GetLatestTimestamp(dst) > tson.GetLatestTimestamp(src) {
+		return dst, nil
+	}
+	return tson.Clone(src)
+}
@@ -0,0 +1,78 @@
+package logument_test
+
+import (
+	"testing"
+
+	"github.com/CAU-CPSS/logument/internal/logument"
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCombineSources(t *testing.T) {
+	adas := newLogument(t, `{
+		"speed" <1700000000>: 72.5,
+		"adas": { "lane" <1700000000>: true }
+	}`, nil)
+	adas.Store(`[
+		{ "op": "replace", "path": "/speed", "value": 80.0, "timestamp": 1800000000 },
+		{ "op": "replace", "path": "/adas/lane", "value": false, "timestamp": 1900000000 }
+	]`)
+	assert.Nil(t, adas.Append())
+
+	bms := newLogument(t, `{
+		"speed" <1600000000>: 70.0,
+		"battery": { "soc" <1700000000>: 80.0 }
+	}`, nil)
+	bms.Store(`[
+		{ "op": "replace", "path": "/battery/soc", "value": 79.0, "timestamp": 1800000000 },
+		{ "op": "replace", "path": "/speed", "value": 81.0, "timestamp": 1800000000 }
+	]`)
+	assert.Nil(t, bms.Append())
+	bms.Store(`[{ "op": "replace", "path": "/battery/soc", "value": 78.0, "timestamp": 2000000000 }]`)
+	assert.Nil(t, bms.Append())
+
+	merged, conflicts, err := logument.Combine(adas, bms)
+	assert.Nil(t, err)
+
+	// The base snapshots are combined, the latest leaf winning
+	var expected tson.Tson
+	assert.Nil(t, tson.Unmarshal([]byte(`{
+		"speed" <1700000000>: 72.5,
+		"adas": { "lane" <1700000000>: true },
+		"battery": { "soc" <1700000000>: 80.0 }
+	}`), &expected))
+	eq, err := tson.Equal(expected, merged.Snapshots[0])
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	// A version for every timestamp
+	assert.Equal(t, []uint64{0, 1, 2, 3}, merged.Version)
+	assert.Len(t, merged.Patches[1], 3)
+
+	assert.Equal(t, []logument.CombineConflict{{
+		Path:      "/speed",
+		Timestamp: 1800000000,
+		Sources:   []int{0, 1},
+		Ops: tsonpatch.Patch{
+			tsonpatch.NewOperation(tsonpatch.OpReplace, "/speed", 80.0, 1800000000),
+			tsonpatch.NewOperation(tsonpatch.OpReplace, "/speed", 81.0, 1800000000),
+		},
+	}}, conflicts)
+
+	speed, err := merged.ValueAt("/speed", 1800000000)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[float64]{Value: 81.0, Timestamp: 1800000000}, speed.Value)
+	soc, err := merged.ValueAtVersion("/battery/soc", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[float64]{Value: 79.0, Timestamp: 1800000000}, soc.Value)
+
+	snapshot, err := merged.Snapshot(3)
+	assert.Nil(t, err)
+	eq, err = tson.Equal(snapshot, merged.CurrentState)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	_, _, err = logument.Combine()
+	assert.ErrorIs(t, err, logument.ErrNoSources)
+}
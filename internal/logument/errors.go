@@ -0,0 +1,40 @@
+//
+// errors.go
+//
+// Sentinel errors returned by Logument operations.
+// Errors are wrapped with the details of the failure,
+// so use `errors.Is` to check for them.
+//
+
+package logument
+
+import "errors"
+
+var (
+	// ErrInvalidSnapshot is returned when a snapshot cannot be parsed or has an unsupported type.
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+	// ErrInvalidPatch is returned when patches cannot be parsed, have an unsupported type, or cannot be applied.
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrVersionOutOfRange is returned when a version is not managed by the Logument.
+	ErrVersionOutOfRange = errors.New("version out of range")
+	// ErrInvalidRange is returned when the start of a range is greater than its end.
+	ErrInvalidRange = errors.New("invalid range")
+	// ErrNotContinuous is returned when the versions of the Logument are not continuous.
+	ErrNotContinuous = errors.New("versions are not continuous")
+	// ErrNotGrown is returned when the latest version does not follow the previous one.
+	ErrNotGrown = errors.New("versions are not grown")
+	// ErrVersionExists is returned when patches for the next version already exist.
+	ErrVersionExists = errors.New("the patch for the next version already exists")
+	// ErrNoSnapshot is returned when there is no snapshot to start from.
+	ErrNoSnapshot = errors.New("no snapshot found")
+	// ErrNoSources is returned when combining no Logument at all.
+	ErrNoSources = errors.New("no sources to combine")
+	// ErrPathNotFound is returned when a path does not exist at the requested point.
+	ErrPathNotFound = errors.New("path not found")
+	// ErrBranchExists is returned when forking a branch with the name of an existing one.
+	ErrBranchExists = errors.New("branch already exists")
+	// ErrBranchNotFound is returned when there is no branch with the given name.
+	ErrBranchNotFound = errors.New("branch not found")
+	// ErrStorage is returned when the on-disk storage cannot be read or written.
+	ErrStorage = errors.New("storage failure")
+)
@@ -0,0 +1,375 @@
+//
+// index.go
+//
+// A per-path index of the appended patches.
+//
+// For every path, the index keeps the patches that changed it,
+// sorted by timestamp, so that the value of a path at a timestamp
+// and the changes of a path within a time range are found
+// by binary search instead of scanning every version.
+//
+// The index is updated by `Append`, and by amendments and late
+// patches for the versions they change. It is rebuilt when the stored
+// patches are rewritten otherwise (e.g. by `Compact` or `Truncate`).
+//
+
+package logument
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+)
+
+// pathIndex keeps the patches of every canonical path in order
+type pathIndex struct {
+	byTime              map[string][]OrderedPatch // Sorted by (timestamp, version, seq)
+	byVersion           map[string][]OrderedPatch // Sorted by (version, seq)
+	structural          map[string][]OrderedPatch // Patches that also change other paths, by scope (see scopes), sorted by (timestamp, version, seq)
+	structuralByVersion map[string][]OrderedPatch // The same, sorted by (version, seq)
+	below               map[string]int            // Number of the patches strictly below each path
+	versions            map[uint64]timeRange      // Timestamps of the patches of each version
+	sizes               map[uint64]int            // Size of the patches of each version (see patchesSize)
+	first               uint64                    // The first indexed version, i.e. the base snapshot
+	reached             []int64                   // Latest timestamp of the patches from the version after first up to each version (see versionReaching)
+	watermark           int64                     // Latest timestamp of the indexed patches
+	indexed             bool                      // Some patches were indexed, i.e. the watermark is set
+}
+
+// timeRange describes the timestamps of the patches of a version
+type timeRange struct {
+	min, max int64
+	empty    bool // The version has no patches
+	ordered  bool // The patches are sorted by timestamp
+}
+
+// newTimeRange returns the time range of patches
+func newTimeRange(patches tsonPatches) timeRange {
+	tr := timeRange{empty: len(patches) == 0, ordered: true}
+	for i, p := range patches {
+		if i == 0 || p.Timestamp < tr.min {
+			tr.min = p.Timestamp
+		}
+		if i == 0 || p.Timestamp > tr.max {
+			tr.max = p.Timestamp
+		}
+		if i > 0 && p.Timestamp < patches[i-1].Timestamp {
+			tr.ordered = false
+		}
+	}
+	return tr
+}
+
+func newPathIndex() *pathIndex {
+	return &pathIndex{
+		byTime:              make(map[string][]OrderedPatch),
+		byVersion:           make(map[string][]OrderedPatch),
+		structural:          make(map[string][]OrderedPatch),
+		structuralByVersion: make(map[string][]OrderedPatch),
+		below:               make(map[string]int),
+		versions:            make(map[uint64]timeRange),
+		sizes:               make(map[uint64]int),
+	}
+}
+
+// canonicalPath converts a path to the form used by the index,
+// e.g. "Vehicle.Speed" and "/Vehicle/Speed" are both "/Vehicle/Speed".
+func canonicalPath(path string) string {
+	path = rfc6901Decoder.Replace(path)
+	return "/" + strings.TrimPrefix(strings.ReplaceAll(path, ".", "/"), "/")
+}
+
+// isStructural tells whether op changes other paths than its own,
+// e.g. removing an object also removes all the paths below it,
+// inserting into an array shifts the following elements,
+// and writing an object or an array sets all the paths below it.
+func isStructural(op tsonpatch.Operation) bool {
+	switch op.Op {
+	case tsonpatch.OpRemove, tsonpatch.OpMove, tsonpatch.OpCopy:
+		return true
+	case tsonpatch.OpAdd:
+		return isArrayElement(canonicalPath(op.Path)) || isComposite(op.Value)
+	case tsonpatch.OpReplace:
+		return isComposite(op.Value)
+	default:
+		return false
+	}
+}
+
+// isComposite tells whether the value of a patch is an object or an array
+func isComposite(value any) bool {
+	switch value.(type) {
+	case tson.Object, tson.Array, map[string]any, []any:
+		return true
+	default:
+		return false
+	}
+}
+
+// isArrayElement tells whether the canonical path ends with an array index (or "-").
+// Object members named by a number are taken as array elements as well,
+// which only makes lookups fall back to replaying the patches.
+func isArrayElement(path string) bool {
+	last := path[strings.LastIndex(path, "/")+1:]
+	if last == "-" {
+		return true
+	}
+	_, err := strconv.Atoi(last)
+	return err == nil
+}
+
+// targets returns the canonical paths the structural patch e changes, along with the paths below them
+func (e OrderedPatch) targets() []string {
+	if e.Op.Op == tsonpatch.OpMove {
+		return []string{canonicalPath(e.Op.From), canonicalPath(e.Op.Path)}
+	}
+	return []string{canonicalPath(e.Op.Path)}
+}
+
+// shifts tells whether the structural patch e moves the elements of an array
+func (e OrderedPatch) shifts() bool {
+	if e.Op.Op == tsonpatch.OpReplace {
+		return false
+	}
+	return slices.ContainsFunc(e.targets(), isArrayElement)
+}
+
+// scopes returns the keys of the structural patch e in the index: its targets,
+// or for a target whose array elements e moves, the array followed by "/",
+// as every path strictly below the array may change.
+func (e OrderedPatch) scopes() []string {
+	targets := e.targets()
+	for i, target := range targets {
+		if e.Op.Op != tsonpatch.OpReplace && isArrayElement(target) {
+			targets[i] = target[:strings.LastIndex(target, "/")+1]
+		}
+	}
+	return targets
+}
+
+// scopesOf returns the scopes of the structural patches that may change the canonical path:
+// the path and its parents, and its parents followed by "/".
+func scopesOf(path string) []string {
+	scopes := []string{path}
+	for i := strings.LastIndex(path, "/"); i >= 0; i = strings.LastIndex(path[:i], "/") {
+		scopes = append(scopes, path[:i+1])
+		if i > 0 {
+			scopes = append(scopes, path[:i])
+		}
+	}
+	return scopes
+}
+
+// parents returns the strict parents of the canonical path, up to the root "/"
+func parents(path string) []string {
+	var parents []string
+	for i := strings.LastIndex(path, "/"); i > 0; i = strings.LastIndex(path[:i], "/") {
+		parents = append(parents, path[:i])
+	}
+	if path != "/" {
+		parents = append(parents, "/")
+	}
+	return parents
+}
+
+// byTimestamp tells whether a comes before b by (timestamp, version, seq)
+func byTimestamp(a, b OrderedPatch) bool { return a.Compare(b) < 0 }
+
+// insertSorted inserts entry into entries, sorted by less
+func insertSorted(entries []OrderedPatch, entry OrderedPatch, less func(a, b OrderedPatch) bool) []OrderedPatch {
+	// Patches mostly arrive in order, so check the end first
+	if n := len(entries); n == 0 || less(entries[n-1], entry) {
+		return append(entries, entry)
+	}
+	i := sort.Search(len(entries), func(i int) bool { return less(entry, entries[i]) })
+	return slices.Insert(entries, i, entry)
+}
+
+// add indexes the patches of version v, which must be newer than the indexed ones,
+// or an indexed version removed first (see remove), with patches added to it.
+func (idx *pathIndex) add(v uint64, patches tsonPatches) {
+	tr := newTimeRange(patches)
+	if len(idx.versions) == 0 {
+		idx.first = v
+	}
+	idx.versions[v] = tr
+	idx.reach(v, tr)
+	idx.sizes[v] = patchesSize(patches)
+	if !tr.empty && (!idx.indexed || tr.max > idx.watermark) {
+		idx.watermark, idx.indexed = tr.max, true
+	}
+	for seq, p := range patches {
+		path := canonicalPath(p.Path)
+		entry := OrderedPatch{Version: v, Seq: seq, Op: p}
+
+		idx.byVersion[path] = insertSorted(idx.byVersion[path], entry, OrderedPatch.before)
+		idx.byTime[path] = insertSorted(idx.byTime[path], entry, byTimestamp)
+		for _, parent := range parents(path) {
+			idx.below[parent]++
+		}
+		if isStructural(p) {
+			for _, scope := range entry.scopes() {
+				idx.structuralByVersion[scope] = insertSorted(idx.structuralByVersion[scope], entry, OrderedPatch.before)
+				idx.structural[scope] = insertSorted(idx.structural[scope], entry, byTimestamp)
+			}
+		}
+	}
+}
+
+// remove drops the patches of the indexed version v from the index, so that it can be added again
+func (idx *pathIndex) remove(v uint64, patches tsonPatches) {
+	drop := func(entries []OrderedPatch) []OrderedPatch {
+		return slices.DeleteFunc(entries, func(e OrderedPatch) bool { return e.Version == v })
+	}
+	for _, p := range patches {
+		path := canonicalPath(p.Path)
+		idx.byVersion[path] = drop(idx.byVersion[path])
+		idx.byTime[path] = drop(idx.byTime[path])
+		for _, parent := range parents(path) {
+			idx.below[parent]--
+		}
+		if isStructural(p) {
+			for _, scope := range (OrderedPatch{Op: p}).scopes() {
+				idx.structuralByVersion[scope] = drop(idx.structuralByVersion[scope])
+				idx.structural[scope] = drop(idx.structural[scope])
+			}
+		}
+	}
+}
+
+// reach records the time range tr of version v in reached.
+// As patches are only ever added to a version, the latest timestamps only grow.
+func (idx *pathIndex) reach(v uint64, tr timeRange) {
+	if v <= idx.first || v-idx.first-1 > uint64(len(idx.reached)) {
+		return
+	}
+	latest := int64(math.MinInt64)
+	if !tr.empty {
+		latest = tr.max
+	}
+	i := int(v - idx.first - 1)
+	if i == len(idx.reached) {
+		if i > 0 {
+			latest = max(latest, idx.reached[i-1])
+		}
+		idx.reached = append(idx.reached, latest)
+		return
+	}
+	for ; i < len(idx.reached) && idx.reached[i] < latest; i++ {
+		idx.reached[i] = latest
+	}
+}
+
+// versionReaching returns the first version after the base snapshot
+// with a patch of at least ts, by binary search.
+func (idx *pathIndex) versionReaching(ts int64) (uint64, bool) {
+	i := sort.Search(len(idx.reached), func(i int) bool { return idx.reached[i] >= ts })
+	if i == len(idx.reached) {
+		return 0, false
+	}
+	return idx.first + uint64(i) + 1, true
+}
+
+// at returns the latest patch of path with a timestamp of at most ts
+func (idx *pathIndex) at(path string, ts int64) (OrderedPatch, bool) {
+	entries := idx.byTime[canonicalPath(path)]
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Op.Timestamp > ts })
+	if i == 0 {
+		return OrderedPatch{}, false
+	}
+	return entries[i-1], true
+}
+
+// atVersion returns the last patch of path in the versions up to v
+func (idx *pathIndex) atVersion(path string, v uint64) (OrderedPatch, bool) {
+	entries := idx.byVersion[canonicalPath(path)]
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Version > v })
+	if i == 0 {
+		return OrderedPatch{}, false
+	}
+	return entries[i-1], true
+}
+
+// structuralAt returns the latest structural patch that changes path with a timestamp of at most ts
+func (idx *pathIndex) structuralAt(path string, ts int64) (last OrderedPatch, found bool) {
+	for _, scope := range scopesOf(canonicalPath(path)) {
+		entries := idx.structural[scope]
+		i := sort.Search(len(entries), func(i int) bool { return entries[i].Op.Timestamp > ts })
+		if i > 0 && (!found || last.Compare(entries[i-1]) < 0) {
+			last, found = entries[i-1], true
+		}
+	}
+	return last, found
+}
+
+// structuralAtVersion returns the last structural patch that changes path in the versions up to v
+func (idx *pathIndex) structuralAtVersion(path string, v uint64) (last OrderedPatch, found bool) {
+	for _, scope := range scopesOf(canonicalPath(path)) {
+		entries := idx.structuralByVersion[scope]
+		i := sort.Search(len(entries), func(i int) bool { return entries[i].Version > v })
+		if i > 0 && (!found || last.before(entries[i-1])) {
+			last, found = entries[i-1], true
+		}
+	}
+	return last, found
+}
+
+// before tells whether e comes before o in the order of the versions, i.e. by (version, seq)
+func (e OrderedPatch) before(o OrderedPatch) bool {
+	return e.Version < o.Version || (e.Version == o.Version && e.Seq < o.Seq)
+}
+
+// between returns the patches of path with a timestamp between tsi and tsj (inclusive)
+func (idx *pathIndex) between(path string, tsi, tsj int64) []OrderedPatch {
+	entries := idx.byTime[canonicalPath(path)]
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Op.Timestamp >= tsi })
+	j := sort.Search(len(entries), func(i int) bool { return entries[i].Op.Timestamp > tsj })
+	if i >= j {
+		return nil
+	}
+	return entries[i:j]
+}
+
+// paths returns the indexed paths starting with prefix, sorted
+func (idx *pathIndex) paths(prefix string) []string {
+	var paths []string
+	for path := range idx.byTime {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// rebuildIndex indexes all the appended patches again
+func (lgm *Logument) rebuildIndex() {
+	lgm.index = newPathIndex()
+	for _, v := range lgm.Version {
+		lgm.index.add(v, lgm.Patches[v])
+	}
+}
+
+// Timeline returns the patches that changed the target path
+// with a timestamp between tsi and tsj (inclusive), ordered by timestamp.
+func (lgm *Logument) Timeline(targetPath string, tsi, tsj int64) (tsonPatches, error) {
+	if tsi > tsj {
+		return nil, fmt.Errorf("%w: start timestamp tsi %d is greater than end timestamp tsj %d", ErrInvalidRange, tsi, tsj)
+	}
+
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	entries := lgm.index.between(targetPath, tsi, tsj)
+	timeline := make(tsonPatches, len(entries))
+	for i, e := range entries {
+		timeline[i] = e.Op
+	}
+	return timeline, nil
+}
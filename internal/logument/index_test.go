@@ -0,0 +1,91 @@
+package logument_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/CAU-CPSS/logument/internal/logument"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeline(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	for _, p := range patches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+	// A late patch of an earlier timestamp, and a path in the dotted form
+	lgm.Store(`[
+		{ "op": "replace", "path": "/location/latitude", "value": 40.0, "timestamp": 1850000000 },
+		{ "op": "replace", "path": "location.latitude", "value": 41.0, "timestamp": 2500000000 }
+	]`)
+	assert.Nil(t, lgm.Append())
+
+	timeline, err := lgm.Timeline("/location/latitude", 0, 3000000000)
+	assert.Nil(t, err)
+	var timestamps []int64
+	for _, p := range timeline {
+		timestamps = append(timestamps, p.Timestamp)
+	}
+	assert.Equal(t, []int64{1800000000, 1850000000, 2100000000, 2500000000}, timestamps)
+
+	timeline, err = lgm.Timeline("Location.Latitude", 0, 3000000000)
+	assert.Nil(t, err)
+	assert.Empty(t, timeline) // Paths are case-sensitive
+
+	timeline, err = lgm.Timeline("location.latitude", 1850000000, 2100000000)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(timeline))
+	assert.Equal(t, 40.0, timeline[0].Value)
+
+	_, err = lgm.Timeline("/speed", 2, 1)
+	assert.ErrorIs(t, err, logument.ErrInvalidRange)
+}
+
+func TestIndexedQueries(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	for _, p := range patches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+
+	changes, err := lgm.TemporalTrack(1900000000, 2100000000)
+	assert.Nil(t, err)
+	assert.Equal(t, map[uint64]tsonpatch.Patch{
+		1: {{Op: tsonpatch.OpReplace, Path: "/tirePressure/0", Value: 35.1, Timestamp: 1900000000}},
+		2: {{Op: tsonpatch.OpReplace, Path: "/engineOn", Value: false, Timestamp: 2000000000}},
+		3: {
+			{Op: tsonpatch.OpReplace, Path: "/location/latitude", Value: 43.9409, Timestamp: 2100000000},
+			{Op: tsonpatch.OpReplace, Path: "/location/longitude", Value: -150.4194, Timestamp: 2100000000},
+		},
+	}, changes)
+
+	history, err := lgm.History("/location")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/location/latitude", "/location/longitude"}, keys(history))
+
+	// The index follows the rewritten patches
+	_, err = lgm.Compact("/location")
+	assert.Nil(t, err)
+	timeline, err := lgm.Timeline("/location/latitude", 0, 3000000000)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(timeline))
+
+	assert.Nil(t, lgm.Truncate(3))
+	timeline, err = lgm.Timeline("/speed", 0, 3000000000)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(timeline))
+	timeline, err = lgm.Timeline("/engineOn", 0, 3000000000)
+	assert.Nil(t, err)
+	assert.Empty(t, timeline)
+}
+
+func keys[V any](m map[string]V) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
@@ -0,0 +1,108 @@
+//
+// lateness.go
+//
+// Ingestion of late patches.
+//
+// Telemetry often arrives out of order, e.g. after a connectivity gap.
+// `Store` queues patches for the next version, so a late patch ends up
+// after newer data, and overwrites it in the snapshots of the following
+// versions. With a lateness window, `Store` merges late patches into the
+// appended version they belong to in time instead (see amend.go), which
+// drops the snapshots that no longer hold. Temporal queries order the
+// patches by timestamp anyway, so they stay correct either way.
+//
+
+package logument
+
+// LatenessPolicy decides how `Store` handles late patches, i.e. patches
+// older than the latest appended timestamp (the watermark).
+// It is disabled by its zero value.
+type LatenessPolicy struct {
+	Window int64 // Merge the patches at most this much older than the watermark into their appended version (ns)
+}
+
+// LatenessStats describes how late the stored patches were
+type LatenessStats struct {
+	Patches int   // Stored patches
+	Late    int   // Patches older than the watermark
+	Merged  int   // Late patches merged into an appended version
+	Max     int64 // Greatest lateness of a patch (ns)
+	Total   int64 // Sum of the lateness of the late patches (ns)
+}
+
+// Mean returns the mean lateness of the late patches (ns)
+func (s LatenessStats) Mean() float64 {
+	if s.Late == 0 {
+		return 0
+	}
+	return float64(s.Total) / float64(s.Late)
+}
+
+// count counts the stored patches, of which merged were merged into appended versions
+func (s *LatenessStats) count(patches tsonPatches, watermark int64, indexed bool, merged int) {
+	s.Patches += len(patches)
+	s.Merged += merged
+	if !indexed {
+		return
+	}
+	for _, p := range patches {
+		if p.Timestamp < watermark {
+			lateness := watermark - p.Timestamp
+			s.Late++
+			s.Total += lateness
+			s.Max = max(s.Max, lateness)
+		}
+	}
+}
+
+// SetLatenessPolicy sets the policy `Store` follows to merge late patches.
+func (lgm *Logument) SetLatenessPolicy(policy LatenessPolicy) {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	lgm.lateness = policy
+}
+
+// LatenessPolicy returns the current lateness policy.
+func (lgm *Logument) LatenessPolicy() LatenessPolicy {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	return lgm.lateness
+}
+
+// Lateness returns how late the patches stored since the Logument was created (or opened) were.
+func (lgm *Logument) Lateness() LatenessStats {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	return lgm.latenessStats
+}
+
+// splitLate splits patches into the late ones to merge into each appended version,
+// and the others, which go to the PatchPool as usual.
+// A late patch belongs to the first version with a patch of at least its timestamp.
+func (lgm *Logument) splitLate(patches tsonPatches) (late map[uint64]tsonPatches, onTime tsonPatches) {
+	window, watermark := lgm.lateness.Window, lgm.index.watermark
+	if window <= 0 || !lgm.index.indexed {
+		return nil, patches
+	}
+
+	for _, p := range patches {
+		// Structural patches depend on the order they were stored in
+		if p.Timestamp >= watermark || watermark-p.Timestamp > window || isStructural(p) {
+			onTime = append(onTime, p)
+			continue
+		}
+		target, found := lgm.index.versionReaching(p.Timestamp)
+		if !found { // Only the base snapshot is that old
+			onTime = append(onTime, p)
+			continue
+		}
+		if late == nil {
+			late = make(map[uint64]tsonPatches)
+		}
+		late[target] = append(late[target], p)
+	}
+	return late, onTime
+}
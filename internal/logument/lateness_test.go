@@ -0,0 +1,118 @@
+package logument_test
+
+import (
+	"testing"
+
+	"github.com/CAU-CPSS/logument/internal/logument"
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLateness(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	lgm.SetLatenessPolicy(logument.LatenessPolicy{Window: 500000000})
+	for _, p := range patches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+	_, err := lgm.Snapshot(3)
+	assert.Nil(t, err)
+
+	// Belongs before the patches of version 3 (at 2100000000)
+	late := tsonpatch.NewOperation(tsonpatch.OpReplace, "/location/latitude", 40.0, 2050000000)
+	assert.Nil(t, lgm.Store(tsonpatch.Patch{late}))
+	assert.Equal(t, late, lgm.Patches[3][0])
+	assert.Empty(t, lgm.PatchPool)
+	assert.Contains(t, lgm.Snapshots, uint64(3)) // Still holds, as version 3 overwrites the late value
+
+	// The newer value is kept
+	latitude, err := tson.GetValue(lgm.CurrentState, "/location/latitude")
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[float64]{Value: 43.9409, Timestamp: 2100000000}, latitude)
+	value, err := lgm.ValueAt("/location/latitude", 2050000000)
+	assert.Nil(t, err)
+	assert.Equal(t, logument.PointValue{Value: tson.Leaf[float64]{Value: 40.0, Timestamp: 2050000000}, Timestamp: 2050000000, Version: 3}, value)
+	snapshot, err := lgm.Snapshot(4)
+	assert.Nil(t, err)
+	eq, err := tson.Equal(snapshot, lgm.CurrentState)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	// Too late to merge, and on time
+	assert.Nil(t, lgm.Store(`[
+		{ "op": "replace", "path": "/speed", "value": 50.0, "timestamp": 1500000000 },
+		{ "op": "replace", "path": "/speed", "value": 95.0, "timestamp": 2500000000 }
+	]`))
+	assert.Len(t, lgm.PatchPool, 2)
+
+	stats := lgm.Lateness()
+	assert.Equal(t, logument.LatenessStats{Patches: 11, Late: 2, Merged: 1, Max: 900000000, Total: 1250000000}, stats)
+	assert.Equal(t, 625000000.0, stats.Mean())
+}
+
+func TestRecoverLate(t *testing.T) {
+	dir := t.TempDir()
+
+	lgm, err := logument.Open(dir, initSnapshot, logument.StorageOptions{})
+	assert.Nil(t, err)
+	lgm.SetLatenessPolicy(logument.LatenessPolicy{Window: 500000000})
+	for _, p := range patches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+	assert.Nil(t, lgm.Store(`[
+		{ "op": "replace", "path": "/engineOn", "value": true, "timestamp": 2200000000 },
+		{ "op": "replace", "path": "/speed", "value": 80.0, "timestamp": 1950000000 },
+		{ "op": "replace", "path": "/speed", "value": 95.0, "timestamp": 2500000000 }
+	]`))
+	assert.Nil(t, lgm.Close())
+
+	recovered, err := logument.Open(dir, nil, logument.StorageOptions{})
+	assert.Nil(t, err)
+	defer recovered.Close()
+
+	assert.Equal(t, lgm.Patches, recovered.Patches)
+	assert.Equal(t, lgm.PatchPool, recovered.PatchPool)
+	eq, err := tson.Equal(lgm.CurrentState, recovered.CurrentState)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+}
+
+func TestLatenessTrickle(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	lgm.SetCheckpointPolicy(logument.CheckpointPolicy{EveryVersions: 1})
+	lgm.SetLatenessPolicy(logument.LatenessPolicy{Window: 2000})
+	for i := range int64(20) {
+		lgm.Store(tsonpatch.Patch{tsonpatch.NewOperation(tsonpatch.OpReplace, "/speed", float64(i), 100*i)})
+		assert.Nil(t, lgm.Append())
+	}
+
+	// Slightly late values, each overwritten by the version it is merged into
+	for i := range int64(19) {
+		late := tsonpatch.NewOperation(tsonpatch.OpReplace, "/speed", float64(-i), 100*i+50)
+		assert.Nil(t, lgm.Store(tsonpatch.Patch{late}))
+		assert.Equal(t, late, lgm.Patches[uint64(i+2)][0])
+	}
+	assert.Equal(t, 19, lgm.Lateness().Merged)
+	assert.Len(t, lgm.Snapshots, 21) // The checkpoints still hold
+
+	// The index is the same as if the patches were appended in the first place
+	replayed := newLogument(t, initSnapshot, nil)
+	for v := uint64(1); v <= 20; v++ {
+		assert.Nil(t, replayed.Store(lgm.Patches[v]))
+		assert.Nil(t, replayed.Append())
+	}
+	for ts := int64(0); ts < 2000; ts += 25 {
+		expected, err := replayed.ValueAt("/speed", ts)
+		assert.Nil(t, err)
+		actual, err := lgm.ValueAt("/speed", ts)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual, "at %d", ts)
+	}
+	expected, err := replayed.Timeline("/speed", 0, 2000)
+	assert.Nil(t, err)
+	actual, err := lgm.Timeline("/speed", 0, 2000)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
@@ -2,9 +2,11 @@ package logument
 
 import (
 	"fmt"
+	"math"
+	"reflect"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/CAU-CPSS/logument/internal/tson"
 	"github.com/CAU-CPSS/logument/internal/tsonpatch"
@@ -21,63 +23,81 @@ type tsonSnapshot = tson.Tson
 type tsonPatches = tsonpatch.Patch // []jsonpatch.Operation
 
 // Logument
+//
+// A Logument is safe for concurrent use: any number of queries may run
+// at the same time as `Store`, `Append` and `Set`. The exported fields
+// are not guarded, so read them only while no other goroutine uses the Logument.
 type Logument struct {
 	Version      []uint64 // Version list
 	CurrentState tsonSnapshot
 	Snapshots    map[uint64]tsonSnapshot // A map which contains an initial Snapshot (by `Create`) and Snapshots from `Snapshot` Function {version: Snapshot}
 	Patches      map[uint64]tsonPatches  // A map which contains Patches from `Append` Function {version: Patches}
 	PatchPool    tsonPatches             // A pool of Patches from `Store` Function
+
+	storage         *storage          // On-disk storage, if opened with `Open`
+	policy          CheckpointPolicy  // When `Append` takes a snapshot by itself
+	retention       RetentionPolicy   // Which versions `Append` keeps
+	index           *pathIndex        // Appended patches by path
+	sinceCheckpoint checkpointStats   // Patches appended since the latest snapshot
+	lateness        LatenessPolicy    // Which late patches `Store` merges into appended versions
+	latenessStats   LatenessStats     // How late the stored patches were
+	branches        map[string]branch // Named branches forked from this Logument
+	generation      uint64            // Incremented whenever appended patches are rewritten (see Snapshot)
+	mu              sync.RWMutex      // Guards all the fields above
 }
 
-func NewLogument(initialSnapshot any, initialPatches any) *Logument {
+// NewLogument creates a new Logument with the given initial snapshot,
+// and stores the initial patches (if any) in the PatchPool.
+func NewLogument(initialSnapshot any, initialPatches any) (*Logument, error) {
 	// Create a new Logument document with the given initial data
 	var snapshot tsonSnapshot
 
 	switch initialSnapshot := initialSnapshot.(type) {
 	case string:
-		err := tson.Unmarshal([]byte(initialSnapshot), &snapshot)
-		if err != nil {
-			panic(err)
+		if err := tson.Unmarshal([]byte(initialSnapshot), &snapshot); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
 		}
 	case []byte:
-		err := tson.Unmarshal(initialSnapshot, &snapshot)
-		if err != nil {
-			panic(err)
+		if err := tson.Unmarshal(initialSnapshot, &snapshot); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
 		}
 	case tsonSnapshot:
 		snapshot = initialSnapshot
 	default:
-		panic("Invalid type for initialSnapshot. Must be string or tson.Tson.")
+		return nil, fmt.Errorf("%w: invalid type %T for initialSnapshot, must be string, []byte or tson.Tson",
+			ErrInvalidSnapshot, initialSnapshot)
 	}
 
+	// CurrentState may share the initial snapshot, as patches do not modify it in place
+	currentState := snapshot
+
 	lgm := &Logument{
 		Version:      []uint64{0},
-		CurrentState: snapshot,
+		CurrentState: currentState,
 		Snapshots:    map[uint64]tsonSnapshot{0: snapshot},
 		Patches:      make(map[uint64]tsonPatches),
 		PatchPool:    nil,
+		index:        newPathIndex(),
 	}
 
 	if initialPatches != nil {
-		lgm.Store(initialPatches)
+		if err := lgm.Store(initialPatches); err != nil {
+			return nil, err
+		}
 	}
 
-	return lgm
-
+	return lgm, nil
 }
 
 func (lgm *Logument) isContinuous() bool {
 	// Check if the versions are continuous
 	// If the versions are not continuous, return false
 	// Otherwise, return true
+	// Versions are only ever appended in ascending order, so no sorting is needed
 	if len(lgm.Version) <= 1 {
 		return true
 	}
 
-	sort.Slice(lgm.Version, func(i, j int) bool {
-		return lgm.Version[i] < lgm.Version[j]
-	})
-
 	for idx, v := range lgm.Version {
 		if idx == 0 {
 			continue
@@ -101,7 +121,18 @@ func (lgm *Logument) isGrown() bool {
 	return lgm.Version[lastIdx] == lgm.Version[lastIdx-1]+1
 }
 
+// LatestVersion returns the latest version of the Logument
+func (lgm *Logument) LatestVersion() uint64 {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	return lgm.Version[len(lgm.Version)-1]
+}
+
 func (lgm *Logument) Print() {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
 	fmt.Println(spew.Sdump(lgm))
 }
 
@@ -127,21 +158,20 @@ func (lgm *Logument) getSortedVersions(source string) []uint64 {
 	return versions
 }
 
-func (lgm *Logument) Store(inputPatches any) {
+// Store stores new patches in the PatchPool, to be appended later by `Append`.
+func (lgm *Logument) Store(inputPatches any) error {
 	var patches tsonPatches
 
 	switch inputPatches := inputPatches.(type) {
 	case string:
 		var err error
-		patches, err = tsonpatch.Unmarshal([]byte(inputPatches))
-		if err != nil {
-			panic(err)
+		if patches, err = tsonpatch.Unmarshal([]byte(inputPatches)); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidPatch, err)
 		}
 	case []byte:
 		var err error
-		patches, err = tsonpatch.Unmarshal(inputPatches)
-		if err != nil {
-			panic(err)
+		if patches, err = tsonpatch.Unmarshal(inputPatches); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidPatch, err)
 		}
 	case tsonPatches:
 		patches = inputPatches
@@ -156,25 +186,75 @@ func (lgm *Logument) Store(inputPatches any) {
 	case []tsonpatch.Operation:
 		patches = tsonpatch.Patch(inputPatches)
 	default:
-		panic("Invalid type for initialPatches. Must be Patch or []Patch.")
+		return fmt.Errorf("%w: invalid type %T for patches, must be string, []byte, Patch, []Patch, Operation or []Operation",
+			ErrInvalidPatch, inputPatches)
 	}
 
-	if lgm.PatchPool == nil {
-		lgm.PatchPool = patches
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	return lgm.store(patches)
+}
+
+// store stores patches in the PatchPool, updating the CurrentState.
+// With a lateness policy, late patches are merged into their appended versions instead.
+func (lgm *Logument) store(patches tsonPatches) error {
+	watermark, indexed := lgm.index.watermark, lgm.index.indexed
+	late, onTime := lgm.splitLate(patches)
+
+	var (
+		state    tsonSnapshot
+		versions map[uint64]tsonPatches
+		stale    []uint64
+		err      error
+	)
+	if len(late) == 0 {
+		if state, err = lgm.nextState(onTime); err != nil {
+			return err
+		}
 	} else {
-		lgm.PatchPool = append(lgm.PatchPool, patches...)
+		versions = lgm.mergedVersions(late)
+		if state, stale, err = lgm.rewrittenState(versions); err != nil {
+			return err
+		}
+		if state, err = tsonpatch.ApplyPatch(state, onTime); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+		}
+		if err := lgm.logVersions(walMerge, late); err != nil {
+			return err
+		}
 	}
+
+	if len(onTime) > 0 || len(late) == 0 {
+		if err := lgm.logRecord(walRecord{Kind: walStore, Patch: onTime}); err != nil {
+			return err
+		}
+	}
+
+	if len(late) == 0 {
+		lgm.CurrentState = state
+	} else if err := lgm.rewriteVersions(versions, state, stale); err != nil {
+		return err
+	}
+	lgm.PatchPool = append(lgm.PatchPool, onTime...)
+	lgm.latenessStats.count(patches, watermark, indexed, len(patches)-len(onTime))
+
+	return nil
 }
 
-func (lgm *Logument) findLatest(targetVersion uint64) (latestVersion uint64, latestSnapshot tsonSnapshot, rr error) {
+func (lgm *Logument) findLatest(targetVersion uint64) (latestVersion uint64, latestSnapshot tsonSnapshot, err error) {
 	if !lgm.isContinuous() {
-		panic("Versions are not continuous.")
+		return 0, nil, ErrNotContinuous
 	}
 
 	if targetVersion > lgm.Version[len(lgm.Version)-1] {
-		panic("Target version should be smaller than the latest version." +
-			"\nTarget version: " + strconv.FormatUint(targetVersion, 10) +
-			"\nLatest version: " + strconv.FormatUint(lgm.Version[len(lgm.Version)-1], 10))
+		return 0, nil, fmt.Errorf("%w: target version %d is greater than the latest version %d",
+			ErrVersionOutOfRange, targetVersion, lgm.Version[len(lgm.Version)-1])
+	}
+
+	if targetVersion < lgm.Version[0] {
+		return 0, nil, fmt.Errorf("%w: target version %d was truncated, the first version is %d",
+			ErrVersionOutOfRange, targetVersion, lgm.Version[0])
 	}
 
 	versions := lgm.getSortedVersions("snapshot")
@@ -182,7 +262,7 @@ func (lgm *Logument) findLatest(targetVersion uint64) (latestVersion uint64, lat
 		return versions[i] > targetVersion
 	})
 	if idx == 0 {
-		return 0, nil, fmt.Errorf("no version found <= targetVersion")
+		return 0, nil, fmt.Errorf("%w: no snapshot at or before version %d", ErrNoSnapshot, targetVersion)
 	}
 
 	return versions[idx-1], lgm.Snapshots[versions[idx-1]], nil
@@ -190,8 +270,15 @@ func (lgm *Logument) findLatest(targetVersion uint64) (latestVersion uint64, lat
 
 // Append Append the patch from PatchPool to the Patches
 func (lgm *Logument) Append() error {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	return lgm.append()
+}
+
+func (lgm *Logument) append() error {
 	if !lgm.isGrown() {
-		return fmt.Errorf("versions are not grown")
+		return ErrNotGrown
 	}
 
 	if lgm.PatchPool == nil {
@@ -201,124 +288,215 @@ func (lgm *Logument) Append() error {
 	latestVersion := lgm.Version[len(lgm.Version)-1]
 
 	if _, exist := lgm.Patches[latestVersion+1]; exist {
-		return fmt.Errorf("the patch for the next version already exists")
+		return fmt.Errorf("%w: version %d", ErrVersionExists, latestVersion+1)
+	}
+
+	if err := lgm.logRecord(walRecord{Kind: walAppend, Version: latestVersion + 1}); err != nil {
+		return err
 	}
 
 	lgm.Patches[latestVersion+1] = lgm.PatchPool
 	lgm.Version = append(lgm.Version, latestVersion+1)
+	lgm.sinceCheckpoint.add(lgm.PatchPool)
+	lgm.index.add(latestVersion+1, lgm.PatchPool)
 	lgm.PatchPool = nil
 
+	// Take a snapshot if the checkpoint policy asks for it
+	if lgm.policy.due(latestVersion+1, lgm.sinceCheckpoint) {
+		if err := lgm.checkpoint(); err != nil {
+			return err
+		}
+	}
+
+	// Drop the versions the retention policy does not keep
+	if base := lgm.retentionBase(); base > lgm.Version[0] {
+		return lgm.truncate(base)
+	}
+
 	return nil
 }
 
 // Snapshot Create a snapshot at the target version
-func (lgm *Logument) Snapshot(vk uint64) tsonSnapshot {
+//
+// The snapshot is kept for later calls, so it must not be modified.
+func (lgm *Logument) Snapshot(vk uint64) (tsonSnapshot, error) {
+	lgm.mu.RLock()
+	snapshot, err := lgm.snapshot(vk)
+	_, cached := lgm.Snapshots[vk]
+	generation := lgm.generation
+	lgm.mu.RUnlock()
+	if err != nil || cached {
+		return snapshot, err
+	}
+
+	// Keep the new snapshot, unless another goroutine did it in the meantime
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	if s, exists := lgm.Snapshots[vk]; exists {
+		return s, nil
+	}
+	// The history was rewritten in the meantime (e.g. amended), so the snapshot may not hold anymore
+	if lgm.generation != generation {
+		if snapshot, err = lgm.snapshot(vk); err != nil {
+			return nil, err
+		}
+	}
+	if err := lgm.addSnapshot(vk, snapshot); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// snapshot builds the snapshot at the target version without storing it.
+// The caller must hold lgm.mu.
+func (lgm *Logument) snapshot(vk uint64) (tsonSnapshot, error) {
 	// Find the latest version before the target version
 	latestVersion, latestSnapshot, err := lgm.findLatest(vk)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	var timedSnapshot tsonSnapshot
 
 	if latestVersion != vk {
 		// Apply patches from the latest version to the target version
+		// (ApplyPatch leaves the stored snapshot as it is)
+		timedSnapshot = latestSnapshot
 		for i := latestVersion + 1; i <= vk; i++ {
-			var err error
-			timedSnapshot, err = tsonpatch.ApplyPatch(latestSnapshot, lgm.Patches[i])
-			if err != nil {
-				panic("Failed to make a snapshot with the given version. Error: " + err.Error())
+			if timedSnapshot, err = tsonpatch.ApplyPatch(timedSnapshot, lgm.Patches[i]); err != nil {
+				return nil, fmt.Errorf("%w: failed to apply the patches of version %d: %w", ErrInvalidPatch, i, err)
 			}
 		}
 	} else {
 		timedSnapshot = latestSnapshot
 	}
 
-	if _, exists := lgm.Snapshots[vk]; !exists {
-		lgm.Snapshots[vk] = timedSnapshot
-	}
-
-	return timedSnapshot
+	return timedSnapshot, nil
 }
 
-func (lgm *Logument) TemporalSnapshot(tsk int64) tsonSnapshot {
-	versions := lgm.getSortedVersions("snapshot")
+// TemporalSnapshot Create a snapshot at the target timestamp
+//
+// The snapshot is the base snapshot with every patch of at most tsk applied,
+// in the order of their timestamps, whatever version they belong to.
+// Patches still in the PatchPool are taken into account,
+// but they are not appended.
+func (lgm *Logument) TemporalSnapshot(tsk int64) (tsonSnapshot, error) {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	return lgm.temporalSnapshot(tsk, true)
+}
 
-	// Find the latest timestamp before the target timestamp
-	latestVersion := lgm.Version[0]
-	for _, version := range versions {
-		s := lgm.Snapshots[version]
-		lts := tson.GetLatestTimestamp(s)
-		if lts <= tsk {
-			latestVersion = version
-		} else {
-			break
-		}
+// temporalSnapshot builds the snapshot at tsk, from the appended versions
+// and, if withPool is set, the PatchPool.
+func (lgm *Logument) temporalSnapshot(tsk int64, withPool bool) (tsonSnapshot, error) {
+	if !lgm.isContinuous() {
+		return nil, ErrNotContinuous
 	}
 
-	// Get the latest snapshot
-	latestSnapshot := lgm.Snapshots[latestVersion]
+	// Start from the latest snapshot which only contains patches of at most tsk
+	baseVersion := lgm.temporalBase(tsk)
 
-	if latestVersion >= lgm.Version[len(lgm.Version)-1] {
-		if lgm.PatchPool != nil {
-			lgm.Append()
-		} else {
-			return latestSnapshot
+	// Collect the patches of at most tsk after the base snapshot
+	var entries []OrderedPatch
+	collect := func(v uint64, patches tsonPatches) {
+		for seq, p := range patches {
+			if p.Timestamp <= tsk {
+				entries = append(entries, OrderedPatch{Version: v, Seq: seq, Op: p})
+			}
 		}
 	}
+	latestVersion := lgm.Version[len(lgm.Version)-1]
+	for v := baseVersion + 1; v <= latestVersion; v++ {
+		collect(v, lgm.Patches[v])
+	}
+	if withPool {
+		collect(latestVersion+1, lgm.PatchPool)
+	}
 
-	// Create a map to store the most recent patch for each path
-	latestPatchMap := make(map[string]tsonpatch.Operation)
+	if len(entries) == 0 {
+		return lgm.Snapshots[baseVersion], nil
+	}
 
-	// Iterate through the patches and keep only the most recent one for each path
-	sort.Slice(lgm.Patches[latestVersion+1], func(i, j int) bool {
-		return lgm.Patches[latestVersion+1][i].Timestamp < lgm.Patches[latestVersion+1][j].Timestamp
-	})
+	SortPatches(entries)
 
-	for _, p := range lgm.Patches[latestVersion+1] {
-		if p.Timestamp <= tsk {
-			// Check if we've seen this path before and if this patch is more recent
-			if existing, exists := latestPatchMap[p.Path]; !exists || p.Timestamp > existing.Timestamp {
-				latestPatchMap[p.Path] = p
-			}
+	var (
+		timedSnapshot = lgm.Snapshots[baseVersion]
+		err           error
+	)
+	for _, e := range entries {
+		if timedSnapshot, err = tsonpatch.ApplyOperation(timedSnapshot, e.Op); err != nil {
+			return nil, fmt.Errorf("%w: failed to apply the patches of version %d: %w", ErrInvalidPatch, e.Version, err)
 		}
 	}
 
-	// Convert the map to a slice
-	var latestPatches tsonpatch.Patch
-	for _, patch := range latestPatchMap {
-		latestPatches = append(latestPatches, patch)
-	}
+	return timedSnapshot, nil
+}
 
-	// Apply patches
-	timedSnapshot, err := tsonpatch.ApplyPatch(latestSnapshot, latestPatches)
-	if err != nil {
-		panic("Failed to make a snapshot with the given version. Error: " + err.Error())
+// temporalBase returns the latest version with a snapshot that can start
+// a temporal snapshot at tsk: the patches up to that version must all be
+// of at most tsk, and come before any patch of the following versions.
+func (lgm *Logument) temporalBase(tsk int64) uint64 {
+	n := len(lgm.Version)
+	ranges := make([]timeRange, n+1)
+	for i, v := range lgm.Version[1:] {
+		ranges[i+1] = lgm.index.versions[v]
+	}
+	ranges[n] = newTimeRange(lgm.PatchPool) // The PatchPool follows the latest version
+
+	// The earliest timestamp after each version
+	suffixMin := make([]int64, n+1)
+	suffixMin[n] = math.MaxInt64
+	for i := n - 1; i >= 0; i-- {
+		suffixMin[i] = suffixMin[i+1]
+		if r := ranges[i+1]; !r.empty {
+			suffixMin[i] = min(suffixMin[i], r.min)
+		}
 	}
 
-	// lgm.Snapshots[latestVersion+1] = timedSnapshot
-
-	return timedSnapshot
+	base := lgm.Version[0]
+	prefixMax := int64(math.MinInt64)
+	for i := 1; i < n; i++ {
+		r := ranges[i]
+		if !r.empty {
+			if !r.ordered || r.min < prefixMax {
+				break // The snapshots from here apply patches out of timestamp order
+			}
+			prefixMax = r.max
+		}
+		if prefixMax > tsk {
+			break
+		}
+		if _, exists := lgm.Snapshots[lgm.Version[i]]; exists && prefixMax <= suffixMin[i] {
+			base = lgm.Version[i]
+		}
+	}
+	return base
 }
 
-func (lgm *Logument) Slice(vi, vj uint64) *Logument {
+// Slice Make a subset of the Logument between the version vi and vj (inclusive)
+func (lgm *Logument) Slice(vi, vj uint64) (*Logument, error) {
 	// Slice the Logument to make a subset of the Logument
 	// The subset should contain the snapshots and patches from vi to vj
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
 	if !lgm.isContinuous() {
-		panic("Versions are not continuous.")
+		return nil, ErrNotContinuous
 	}
 
 	if vi > vj {
-		panic("Start version should be smaller than the end version." +
-			"\nStart version: " + strconv.FormatUint(vi, 10) +
-			"\nEnd version: " + strconv.FormatUint(vj, 10))
+		return nil, fmt.Errorf("%w: start version %d is greater than the end version %d", ErrInvalidRange, vi, vj)
+	}
+
+	if latest := lgm.Version[len(lgm.Version)-1]; vj > latest {
+		return nil, fmt.Errorf("%w: end version %d is greater than the latest version %d", ErrVersionOutOfRange, vj, latest)
 	}
 
-	if vi > lgm.Version[len(lgm.Version)-1] || vj > lgm.Version[len(lgm.Version)-1] {
-		panic("Start version and end version should be smaller than the latest version." +
-			"\nStart version: " + strconv.FormatUint(vi, 10) +
-			"\nEnd version: " + strconv.FormatUint(vj, 10) +
-			"\nLatest version: " + strconv.FormatUint(lgm.Version[len(lgm.Version)-1], 10))
+	if first := lgm.Version[0]; vi < first {
+		return nil, fmt.Errorf("%w: start version %d is less than the first version %d", ErrVersionOutOfRange, vi, first)
 	}
 
 	var SlicedVersions []uint64
@@ -346,7 +524,11 @@ func (lgm *Logument) Slice(vi, vj uint64) *Logument {
 
 	// Add the snapshot at the start version if it does not exist
 	if len(SlicedSnapshots) == 0 {
-		SlicedSnapshots[vi] = lgm.Snapshot(vi)
+		snapshot, err := lgm.snapshot(vi)
+		if err != nil {
+			return nil, err
+		}
+		SlicedSnapshots[vi] = snapshot
 	}
 
 	slicedLgm := &Logument{
@@ -355,20 +537,23 @@ func (lgm *Logument) Slice(vi, vj uint64) *Logument {
 		Patches:   SlicedPatches,
 		PatchPool: nil,
 	}
+	slicedLgm.rebuildIndex()
 
-	return slicedLgm
+	return slicedLgm, nil
 }
 
-func (lgm *Logument) TemporalSlice(tsi, tsj int64) *Logument {
+// TemporalSlice Make a subset of the Logument between the timestamp tsi and tsj (inclusive)
+func (lgm *Logument) TemporalSlice(tsi, tsj int64) (*Logument, error) {
 	// TimeSlice the Logument to make a subset of the Logument based on the timestamp
 	// The subset should contain the snapshots and patches from the start time to the end time
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
 	if !lgm.isContinuous() {
-		panic("Versions are not continuous.")
+		return nil, ErrNotContinuous
 	}
 	if tsi > tsj {
-		panic("Start time should be smaller than the end time." +
-			"\nStart time: " + strconv.FormatInt(tsi, 10) +
-			"\nEnd time: " + strconv.FormatInt(tsj, 10))
+		return nil, fmt.Errorf("%w: start time %d is greater than the end time %d", ErrInvalidRange, tsi, tsj)
 	}
 
 	var SlicedVersions []uint64
@@ -393,7 +578,9 @@ func (lgm *Logument) TemporalSlice(tsi, tsj int64) *Logument {
 				// because lgm.Version and lgm.PatchMap are continuous.
 				// However, lgm.Snapshots may not be continuous,
 				// so the following line works correctly only in this loop.
-				SlicedVersions = append(SlicedVersions, version)
+				if _, exists := SlicedPatches[version]; !exists {
+					SlicedVersions = append(SlicedVersions, version)
+				}
 				SlicedPatches[version] = append(SlicedPatches[version], patch)
 			}
 		}
@@ -401,8 +588,14 @@ func (lgm *Logument) TemporalSlice(tsi, tsj int64) *Logument {
 
 	// Add the snapshot at the start version if it does not exist
 	if len(SlicedSnapshots) == 0 {
-		startVersion := SlicedVersions[0]
-		SlicedSnapshots[startVersion] = lgm.TemporalSnapshot(tsi)
+		if len(SlicedVersions) == 0 {
+			return nil, fmt.Errorf("%w: no patches between %d and %d", ErrInvalidRange, tsi, tsj)
+		}
+		snapshot, err := lgm.temporalSnapshot(tsi, true)
+		if err != nil {
+			return nil, err
+		}
+		SlicedSnapshots[SlicedVersions[0]] = snapshot
 	}
 
 	slicedLgm := &Logument{
@@ -411,54 +604,50 @@ func (lgm *Logument) TemporalSlice(tsi, tsj int64) *Logument {
 		Patches:   SlicedPatches,
 		PatchPool: nil,
 	}
+	slicedLgm.rebuildIndex()
 
-	return slicedLgm
+	return slicedLgm, nil
 }
 
-func (lgm *Logument) Track(vi, vj uint64) map[uint64]tsonPatches {
+// Track Extract the patches that have changed values between the version vi and vj
+//
+// The Logument is left unchanged; use `Compact` to remove the unchanged values from it.
+func (lgm *Logument) Track(vi, vj uint64) (map[uint64]tsonPatches, error) {
 	// Track the Logument document to make a patch that contains all the changes
 	// from the version vi to the version vj
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
 	if !lgm.isContinuous() {
-		panic("Versions are not continuous.")
+		return nil, ErrNotContinuous
 	}
 
-	if lgm.Version[len(lgm.Version)-1] < vi || lgm.Version[len(lgm.Version)-1] < vj {
-		panic("Target versions should be smaller than the latest version." +
-			"\nTarget version vi: " + strconv.FormatUint(vi, 10) +
-			"\nTarget version vj: " + strconv.FormatUint(vj, 10) +
-			"\nLatest version: " + strconv.FormatUint(lgm.Version[len(lgm.Version)-1], 10))
+	if vi > vj {
+		return nil, fmt.Errorf("%w: target version vi %d is greater than target version vj %d", ErrInvalidRange, vi, vj)
 	}
 
-	if vi > vj {
-		panic("Target version vi should be smaller than or equal to target version vj." +
-			"\nTarget version vi: " + strconv.FormatUint(vi, 10) +
-			"\nTarget version vj: " + strconv.FormatUint(vj, 10))
+	if latest := lgm.Version[len(lgm.Version)-1]; vj > latest {
+		return nil, fmt.Errorf("%w: target version vj %d is greater than the latest version %d", ErrVersionOutOfRange, vj, latest)
 	}
 
-	versions := lgm.getSortedVersions("patch")
-	packedPatches := make(map[uint64]tsonPatches)
-	for _, version := range versions {
-		if vi <= version && version <= vj {
-			packedPatches[version] = lgm.Patches[version]
-		}
+	if first := lgm.Version[0]; vi < first {
+		return nil, fmt.Errorf("%w: target version vi %d is less than the first version %d", ErrVersionOutOfRange, vi, first)
 	}
 
+	packedPatches := make(map[uint64]tsonPatches)
 	latestValues := make(map[string]any)
 
-	paths := make([]uint64, 0, len(packedPatches))
-	for path := range packedPatches {
-		paths = append(paths, path)
-	}
-	sort.Slice(paths, func(i, j int) bool { return paths[i] < paths[j] })
-
-	for _, path := range paths {
-		patches := packedPatches[path]
+	for _, version := range lgm.getSortedVersions("patch") {
+		if version < vi || vj < version {
+			continue
+		}
+		patches := lgm.Patches[version]
 		compactPatches := make(tsonPatches, 0, len(patches))
 		for _, patch := range patches {
 			// Compare to previous value if it exists at the same path
 			if prev, exists := latestValues[patch.Path]; exists {
-				// If value has changed, keep the patch and update the status bool { return paths[i] < paths[j] })
-				if prev != patch.Value {
+				// If value has changed, keep the patch and update the status
+				if !sameValue(prev, patch.Value) {
 					compactPatches = append(compactPatches, patch)
 					latestValues[patch.Path] = patch.Value
 				}
@@ -469,137 +658,236 @@ func (lgm *Logument) Track(vi, vj uint64) map[uint64]tsonPatches {
 				latestValues[patch.Path] = patch.Value
 			}
 		}
-		lgm.Patches[path] = compactPatches
+		packedPatches[version] = compactPatches
 	}
 
-	return packedPatches
+	return packedPatches, nil
 }
 
-func (lgm *Logument) TemporalTrack(tsi, tsj int64) map[uint64]tsonPatches {
-	// if !lgm.isContinuous() {
-	// 	panic("Versions are not continuous.")
-	// }
-
+// TemporalTrack Extract the patches that have changed values between the timestamp tsi and tsj
+func (lgm *Logument) TemporalTrack(tsi, tsj int64) (map[uint64]tsonPatches, error) {
 	if tsi > tsj {
-		panic("Start timestamp tsi should be smaller than or equal to end timestamp tsj." +
-			"\nStart timestamp: " + strconv.FormatInt(tsi, 10) +
-			"\nEnd timestamp: " + strconv.FormatInt(tsj, 10))
+		return nil, fmt.Errorf("%w: start timestamp tsi %d is greater than end timestamp tsj %d", ErrInvalidRange, tsi, tsj)
 	}
 
-	trackedPatches := make(map[uint64]tsonPatches)
-	latestValues := make(map[string]any)
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
 
-	versions := lgm.getSortedVersions("patch")
-	for _, version := range versions {
-		ps := lgm.Patches[version]
-		compactPatches := make(tsonPatches, 0, len(ps))
-		for _, p := range ps {
-			if p.Timestamp >= tsi && p.Timestamp <= tsj {
-				// Compare to previous value if it exists at the same path
-				if prev, exists := latestValues[p.Path]; exists {
-					// If value has changed, keep the patch and update the status
-					if prev != p.Value {
-						compactPatches = append(compactPatches, p)
-						latestValues[p.Path] = p.Value
-					}
-					// Skip if value is the same
-				} else {
-					// Always keep the patch when it appears for the first time
-					compactPatches = append(compactPatches, p)
-					latestValues[p.Path] = p.Value
-				}
+	// Scan the time range of each path in the index
+	var tracked []OrderedPatch
+	for _, path := range lgm.index.paths("/") {
+		entries := lgm.index.between(path, tsi, tsj)
+		for i, e := range entries {
+			// Always keep the first patch, then only the patches that changed the value
+			if i == 0 || !sameValue(e.Op.Value, tracked[len(tracked)-1].Op.Value) {
+				tracked = append(tracked, e)
 			}
 		}
-		if len(compactPatches) > 0 {
-			trackedPatches[version] = compactPatches
+	}
+
+	// Group the patches by version, in their original order
+	sort.Slice(tracked, func(i, j int) bool {
+		if tracked[i].Version != tracked[j].Version {
+			return tracked[i].Version < tracked[j].Version
 		}
+		return tracked[i].Seq < tracked[j].Seq
+	})
+
+	trackedPatches := make(map[uint64]tsonPatches)
+	for _, e := range tracked {
+		trackedPatches[e.Version] = append(trackedPatches[e.Version], e.Op)
 	}
 
-	return trackedPatches
+	return trackedPatches, nil
 }
 
-func (lgm *Logument) Set(vk uint64, patch tsonpatch.Operation) {
-	// Set the value at the target path in the snapshot at the target version
-	// if !lgm.isContinuous() {
-	// 	panic("Versions are not continuous.")
-	// }
+// nextState returns the CurrentState with the patches applied, leaving the CurrentState unchanged,
+// so that it only changes once the patches are stored in the PatchPool.
+func (lgm *Logument) nextState(patches tsonPatches) (tsonSnapshot, error) {
+	state, err := tsonpatch.ApplyPatch(lgm.CurrentState, patches)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+	}
+	return state, nil
+}
 
-	if patch.Op != tsonpatch.OpReplace && patch.Op != tsonpatch.OpAdd {
-		return
+// Set Update the CurrentState with the patch, and store the patch in the PatchPool.
+// The patch may add, replace or remove a value; adding to or removing from
+// an array index inserts or deletes the element, shifting the following ones.
+//
+// vk is the version the patch belongs to: the next version (the latest one + 1),
+// or an appended version to amend with a late correction (see amend.go).
+// Any other version is rejected with ErrVersionOutOfRange.
+func (lgm *Logument) Set(vk uint64, patch tsonpatch.Operation) error {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	return lgm.set(vk, patch)
+}
+
+func (lgm *Logument) set(vk uint64, patch tsonpatch.Operation) error {
+	// Set the value at the target path in the snapshot at the target version
+	switch patch.Op {
+	case tsonpatch.OpAdd, tsonpatch.OpReplace, tsonpatch.OpRemove:
+	default:
+		return fmt.Errorf("%w: operation %q is not supported by Set", ErrInvalidPatch, patch.Op)
+	}
+	if err := lgm.settable(vk); err != nil {
+		return err
+	}
+	if patch.Op == tsonpatch.OpRemove {
+		state, err := lgm.stateAt(vk)
+		if err != nil {
+			return err
+		}
+		if _, err := tson.GetValue(state, canonicalPath(patch.Path)); err != nil {
+			return fmt.Errorf("%w: cannot remove %s at version %d: %w", ErrPathNotFound, patch.Path, vk, err)
+		}
+	}
+	if latest := lgm.Version[len(lgm.Version)-1]; vk <= latest {
+		return lgm.amend(map[uint64]tsonPatches{vk: {patch}})
 	}
 
-	var err error
-	lgm.CurrentState, err = tsonpatch.ApplyOperation(lgm.CurrentState, patch)
+	newState, err := lgm.nextState(tsonpatch.Patch{patch})
 	if err != nil {
-		panic(fmt.Sprintf("failed to apply patch: %v", err))
+		return err
+	}
+
+	if err := lgm.logRecord(walRecord{Kind: walSet, Version: vk, Patch: tsonpatch.Patch{patch}}); err != nil {
+		return err
 	}
 
+	lgm.CurrentState = newState
 	if lgm.PatchPool == nil {
 		lgm.PatchPool = tsonpatch.Patch{patch}
 	} else {
 		lgm.PatchPool = append(lgm.PatchPool, patch)
 	}
+
+	return nil
+}
+
+// Unset Remove the target path from the CurrentState, and store the `remove` patch in the PatchPool
+func (lgm *Logument) Unset(vk uint64, targetPath string, timestamp int64) error {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	return lgm.set(vk, tsonpatch.NewOperation(tsonpatch.OpRemove, targetPath, nil, timestamp))
 }
 
-func (lgm *Logument) TestSet(vk uint64, patch tsonpatch.Operation) {
+// TestSet `Set` only if the value differs from the CurrentState (or the version vk, if amended)
+func (lgm *Logument) TestSet(vk uint64, patch tsonpatch.Operation) error {
+	if patch.Op == tsonpatch.OpRemove {
+		return lgm.TestUnset(vk, patch.Path, patch.Timestamp)
+	}
+
 	// Set the value at the target path in the snapshot at the target timestamp
 	if patch.Op != tsonpatch.OpReplace && patch.Op != tsonpatch.OpAdd {
-		return
+		return fmt.Errorf("%w: operation %q is not supported by TestSet", ErrInvalidPatch, patch.Op)
 	}
 
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
 	if patch.Op == tsonpatch.OpAdd {
-		lgm.Set(vk, patch)
-		return
+		return lgm.set(vk, patch)
 	}
 
-	latesetSnapshot := lgm.Snapshots[lgm.Version[len(lgm.Version)-1]]
-	// if _, exists := lgm.Snapshots[vk]; !exists {
-	// 	s := lgm.Snapshot(vk)
-	// 	lgm.Snapshots[vk] = s
-	// }
+	// Compare against the state the patch applies to
+	state, err := lgm.stateAt(vk)
+	if err != nil {
+		return err
+	}
 
-	exist_value, err := tson.GetValue(latesetSnapshot, patch.Path)
+	exist_value, err := tson.GetValue(state, canonicalPath(patch.Path))
 	if err != nil {
-		lgm.Set(vk,
+		return lgm.set(vk,
 			tsonpatch.Operation{
 				Op:        "add",
 				Path:      patch.Path,
 				Value:     patch.Value,
 				Timestamp: patch.Timestamp,
 			})
-		return
 	}
 
 	if !leafCompareValue(exist_value, patch.Value) {
-		lgm.Set(vk, patch)
+		return lgm.set(vk, patch)
+	}
+	return nil
+}
+
+// TestUnset `Unset` only if the target path exists in the CurrentState (or the version vk, if amended)
+func (lgm *Logument) TestUnset(vk uint64, targetPath string, timestamp int64) error {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	state, err := lgm.stateAt(vk)
+	if err != nil {
+		return err
 	}
+	if _, err := tson.GetValue(state, canonicalPath(targetPath)); err != nil {
+		return nil // Already removed
+	}
+	return lgm.set(vk, tsonpatch.NewOperation(tsonpatch.OpRemove, targetPath, nil, timestamp))
+}
+
+// sameValue reports whether the values of two patches are equal.
+// They may be objects or arrays (see tsonpatch.ToValue), which == cannot compare.
+func sameValue(a, b any) bool {
+	return reflect.DeepEqual(a, b)
 }
 
 func leafCompareValue(leafValue tson.Value, value any) bool {
-	switch leaf := leafValue.(type) {
-	case tson.Leaf[string]:
-		if strVal, ok := value.(string); ok {
-			return leaf.Value == strVal
-		}
-	case tson.Leaf[float64]:
-		if floatVal, ok := value.(float64); ok {
-			return leaf.Value == floatVal
-		}
-	case tson.Leaf[bool]:
-		if boolVal, ok := value.(bool); ok {
-			return leaf.Value == boolVal
-		}
+	current, _, ok := tson.LeafOf(leafValue)
+	if !ok {
+		return false
+	}
+	leaf, err := tsonpatch.ToLeaf(value, 0)
+	if err != nil {
+		return false
+	}
+	v, _, _ := tson.LeafOf(leaf)
+	return current == v
+}
+
+// CompactReport describes the patches removed by `Compact`
+type CompactReport struct {
+	Path    string                 // The target path of the compaction
+	Removed map[uint64]tsonPatches // The removed patches {version: Patches}
+}
+
+// Count returns the number of removed patches
+func (r CompactReport) Count() int {
+	count := 0
+	for _, ps := range r.Removed {
+		count += len(ps)
 	}
-	return false
+	return count
 }
 
-func (lgm *Logument) Compact(targetPath string) {
+// Compact Remove the patches at the target path where only the timestamp has changed
+//
+// Unlike the queries, Compact rewrites the stored Patches permanently,
+// and reports what it removed. Snapshots taken before keep the removed timestamps.
+func (lgm *Logument) Compact(targetPath string) (CompactReport, error) {
 	// Compact the Logument document
 	// Remove the patches that have changed only the value without changing the TIMESTAMP ts
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
 	if !lgm.isContinuous() {
-		panic("Versions are not continuous.")
+		return CompactReport{}, ErrNotContinuous
 	}
 
+	if err := lgm.logRecord(walRecord{Kind: walCompact, Path: targetPath}); err != nil {
+		return CompactReport{}, err
+	}
+
+	return lgm.compact(targetPath), nil
+}
+
+func (lgm *Logument) compact(targetPath string) CompactReport {
+	report := CompactReport{Path: targetPath, Removed: make(map[uint64]tsonPatches)}
 	latestValues := make(map[string]any)
 
 	versions := lgm.getSortedVersions("patch")
@@ -612,11 +900,13 @@ func (lgm *Logument) Compact(targetPath string) {
 				// Compare to previous value if it exists at the same path
 				if prev, exists := latestValues[p.Path]; exists {
 					// If value has changed, keep the patch and update the status
-					if prev != p.Value {
+					if !sameValue(prev, p.Value) {
 						compactPatches = append(compactPatches, p)
 						latestValues[p.Path] = p.Value
+					} else {
+						// Remove if value is the same
+						report.Removed[version] = append(report.Removed[version], p)
 					}
-					// Skip if value is the same
 				} else {
 					// Always keep the patch when it appears for the first time
 					compactPatches = append(compactPatches, p)
@@ -629,33 +919,45 @@ func (lgm *Logument) Compact(targetPath string) {
 		}
 		lgm.Patches[version] = compactPatches
 	}
+	lgm.generation++
+	lgm.rebuildIndex()
+
+	return report
 }
 
-func (lgm *Logument) History(targetPath string) map[string]tsonPatches {
+// History Retrieve the history of changes at the target path
+//
+// Patches that only changed the timestamp are left out of the history,
+// but stay in the Logument; use `Compact` to remove them.
+func (lgm *Logument) History(targetPath string) (map[string]tsonPatches, error) {
 	// Get the history of the changes at the target path
 	// The history should contain all the patches that have changed the value at the target path
 	// The patches should be sorted by the timestamp in ascending order
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
 	if !lgm.isContinuous() {
-		panic("Versions are not continuous.")
+		return nil, ErrNotContinuous
 	}
 
-	lgm.Compact(targetPath)
-
+	// Paths are keyed in their canonical form, e.g. "/Vehicle/Speed" for "Vehicle.Speed"
 	historyPatches := make(map[string]tsonPatches)
-	for _, patches := range lgm.Patches {
-		for _, patch := range patches {
-			path := rfc6901Decoder.Replace(patch.Path)
-			if strings.HasPrefix(path, targetPath) {
-				historyPatches[patch.Path] = append(historyPatches[patch.Path], patch)
+	for _, path := range lgm.index.paths(canonicalPath(targetPath)) {
+		for _, e := range lgm.index.between(path, math.MinInt64, math.MaxInt64) {
+			// Skip the patch if the value is the same as the previous one
+			history := historyPatches[path]
+			if len(history) > 0 && sameValue(history[len(history)-1].Value, e.Op.Value) {
+				continue
 			}
+			historyPatches[path] = append(history, e.Op)
 		}
 	}
 
 	// Add the initial value to the history
 	for key := range historyPatches {
-		val, err := tson.GetValue(lgm.Snapshots[0], key)
+		val, err := tson.GetValue(lgm.Snapshots[lgm.Version[0]], key)
 		if err != nil {
-			panic("Failed to get the value from the snapshot. Error: " + err.Error())
+			continue // The path did not exist in the base snapshot
 		}
 		if val != nil {
 			historyPatches[key] = append([]tsonpatch.Operation{{
@@ -668,5 +970,5 @@ func (lgm *Logument) History(targetPath string) map[string]tsonPatches {
 		}
 	}
 
-	return historyPatches
+	return historyPatches, nil
 }
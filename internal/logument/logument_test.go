@@ -1,12 +1,15 @@
 package logument_test
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/CAU-CPSS/logument/internal/logument"
 	"github.com/CAU-CPSS/logument/internal/tson"
 	"github.com/CAU-CPSS/logument/internal/tsonpatch"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/stretchr/testify/assert"
 )
 
 const initSnapshot = `{
@@ -44,12 +47,22 @@ var patches = []string{
 	]`,
 }
 
+// newLogument creates a Logument, failing the test on error
+func newLogument(t *testing.T, initialSnapshot any, initialPatches any) *logument.Logument {
+	t.Helper()
+	lgm, err := logument.NewLogument(initialSnapshot, initialPatches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lgm
+}
+
 func TestCreate(t *testing.T) {
 	t.Log("Make a new Logument document\n")
 
 	// use only string format
 	t.Log("Make a Logument with string format\n")
-	lgm := logument.NewLogument(initSnapshot, patches[0])
+	lgm := newLogument(t, initSnapshot, patches[0])
 	t.Log(spew.Sdump(lgm))
 
 	// use Snapshot and Patches format
@@ -62,7 +75,7 @@ func TestCreate(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	lgmWithFormatdata := logument.NewLogument(ss, pp)
+	lgmWithFormatdata := newLogument(t, ss, pp)
 	t.Log(spew.Sdump(lgmWithFormatdata))
 
 	// use []Patches format
@@ -71,13 +84,13 @@ func TestCreate(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	lgmWithPatches := logument.NewLogument(ss, []tsonpatch.Patch{pp, pp2})
+	lgmWithPatches := newLogument(t, ss, []tsonpatch.Patch{pp, pp2})
 	t.Log(spew.Sdump(lgmWithPatches))
 }
 
 func TestStore(t *testing.T) {
 	t.Log("Store patches to the pool\n")
-	lgm := logument.NewLogument(initSnapshot, nil)
+	lgm := newLogument(t, initSnapshot, nil)
 
 	lgm.Store(patches[0])
 	t.Log(spew.Sdump(lgm))
@@ -88,7 +101,7 @@ func TestStore(t *testing.T) {
 
 func TestApply(t *testing.T) {
 	t.Log("Apply patches\n")
-	lgm := logument.NewLogument(initSnapshot, nil)
+	lgm := newLogument(t, initSnapshot, nil)
 	lgm.Store(patches[0])
 	lgm.Store(patches[1])
 	t.Log(spew.Sdump(lgm))
@@ -101,7 +114,7 @@ func TestApply(t *testing.T) {
 
 func TestSnapshot(t *testing.T) {
 	t.Log("Take a snapshot\n")
-	lgm := logument.NewLogument(initSnapshot, nil)
+	lgm := newLogument(t, initSnapshot, nil)
 	lgm.Store(patches[0])
 	lgm.Store(patches[1])
 	lgm.Append()
@@ -109,11 +122,17 @@ func TestSnapshot(t *testing.T) {
 	lgm.Print()
 
 	// Take a snapshot already taken
-	snapshot := lgm.Snapshot(0)
+	snapshot, err := lgm.Snapshot(0)
+	if err != nil {
+		t.Error(err)
+	}
 	t.Log(spew.Sdump(snapshot))
 
 	// Take a snapshot
-	snapshot = lgm.Snapshot(1)
+	snapshot, err = lgm.Snapshot(1)
+	if err != nil {
+		t.Error(err)
+	}
 	t.Log(spew.Sdump(snapshot))
 
 	// Requests exceeding latest version
@@ -123,7 +142,7 @@ func TestSnapshot(t *testing.T) {
 
 func TestTemporalSnapshot(t *testing.T) {
 	t.Log("Take a timed snapshot\n")
-	lgm := logument.NewLogument(initSnapshot, nil)
+	lgm := newLogument(t, initSnapshot, nil)
 	lgm.Store(patches[0])
 	lgm.Store(patches[1])
 	lgm.Append()
@@ -133,9 +152,23 @@ func TestTemporalSnapshot(t *testing.T) {
 	// t.Log(spew.Sdump(snapshot))
 
 	// Take a snapshot
-	snapshot := lgm.TemporalSnapshot(1900000000)
+	snapshot, err := lgm.TemporalSnapshot(1900000000)
+	if err != nil {
+		t.Error(err)
+	}
 	t.Log(spew.Sdump(snapshot))
 
+	// The PatchPool is taken into account without being appended
+	lgm.Snapshot(1)
+	lgm.Store(patches[2])
+	snapshot, err = lgm.TemporalSnapshot(2100000000)
+	assert.Nil(t, err)
+	latitude, err := tson.GetValue(snapshot, "/location/latitude")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2100000000), latitude.(tson.Leaf[float64]).Timestamp)
+	assert.Equal(t, []uint64{0, 1}, lgm.Version)
+	assert.NotNil(t, lgm.PatchPool)
+
 	// Requests exceeding latest version
 	// snapshot = lgm.TimedSnapshot(2100000000)
 	// t.Log(spew.Sdump(snapshot))
@@ -143,7 +176,7 @@ func TestTemporalSnapshot(t *testing.T) {
 
 func TestSlice(t *testing.T) {
 	t.Log("Slice Logument\n")
-	lgm := logument.NewLogument(initSnapshot, nil)
+	lgm := newLogument(t, initSnapshot, nil)
 	lgm.Store(patches[0])
 	lgm.Store(patches[1])
 	if err := lgm.Append(); err != nil { // version 1
@@ -161,13 +194,16 @@ func TestSlice(t *testing.T) {
 	}
 
 	// Slice patches
-	lgmSubset := lgm.Slice(1, 3)
+	lgmSubset, err := lgm.Slice(1, 3)
+	if err != nil {
+		t.Error(err)
+	}
 	lgmSubset.Print()
 }
 
 func TestTrack(t *testing.T) {
 	t.Log("Track changes\n")
-	lgm := logument.NewLogument(initSnapshot, nil)
+	lgm := newLogument(t, initSnapshot, nil)
 	lgm.Store(patches[0])
 	lgm.Store(patches[1])
 	lgm.Append() // version 1
@@ -182,13 +218,22 @@ func TestTrack(t *testing.T) {
 	// { "op": "replace", "path": "/location/longitude", "value": -150.4194, "timestamp": 2100000000 },
 	// { "op": "replace", "path": "/tirePressure/2", "value": 33.7, "timestamp": 2300000000 },
 	// { "op": "replace", "path": "/speed", "value": 94.9, "timestamp": 2400000000 }
-	changes := lgm.Track(2, 3)
+	changes, err := lgm.Track(2, 3)
+	if err != nil {
+		t.Error(err)
+	}
 	t.Log(spew.Sdump(changes))
+
+	// Tracking does not change the Logument
+	changes, err = lgm.Track(1, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(changes[2]))
+	assert.Equal(t, 2, len(lgm.Patches[2]))
 }
 
 func TestTemporalTrack(t *testing.T) {
 	t.Log("Track changes\n")
-	lgm := logument.NewLogument(initSnapshot, nil)
+	lgm := newLogument(t, initSnapshot, nil)
 	lgm.Store(patches[0])
 	lgm.Store(patches[1])
 	lgm.Append() // version 1
@@ -203,13 +248,16 @@ func TestTemporalTrack(t *testing.T) {
 	// { "op": "replace", "path": "/engineOn", "value": false, "timestamp": 2000000000 },
 	// { "op": "replace", "path": "/location/latitude", "value": 43.9409, "timestamp": 2100000000 },
 	// { "op": "replace", "path": "/location/longitude", "value": -150.4194, "timestamp": 2100000000 }
-	changes := lgm.TemporalTrack(1900000000, 2100000000)
+	changes, err := lgm.TemporalTrack(1900000000, 2100000000)
+	if err != nil {
+		t.Error(err)
+	}
 	t.Log(spew.Sdump(changes))
 }
 
 func TestSet(t *testing.T) {
 	t.Log("Set a value\n")
-	lgm := logument.NewLogument(initSnapshot, nil)
+	lgm := newLogument(t, initSnapshot, nil)
 	lgm.Store(patches[0])
 	lgm.Store(patches[1])
 	lgm.Append()
@@ -227,7 +275,7 @@ func TestSet(t *testing.T) {
 
 func TestValidSet(t *testing.T) {
 	t.Log("Set a value\n")
-	lgm := logument.NewLogument(initSnapshot, nil)
+	lgm := newLogument(t, initSnapshot, nil)
 	lgm.Store(patches[0])
 	lgm.Store(patches[1])
 	lgm.Append()
@@ -243,9 +291,87 @@ func TestValidSet(t *testing.T) {
 	lgm.Print()
 }
 
+func TestUnset(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	lgm.Store(patches[0])
+	assert.Nil(t, lgm.Append())
+
+	assert.Nil(t, lgm.Unset(2, "/location/latitude", 1900000000))
+	_, err := tson.GetValue(lgm.CurrentState, "/location/latitude")
+	assert.NotNil(t, err)
+	assert.Equal(t, tsonpatch.NewOperation(tsonpatch.OpRemove, "/location/latitude", nil, 1900000000), lgm.PatchPool[0])
+
+	// Nothing to remove
+	assert.Nil(t, lgm.TestUnset(2, "location.latitude", 1900000000))
+	assert.Len(t, lgm.PatchPool, 1)
+	assert.ErrorIs(t, lgm.Unset(2, "/location/latitude", 1900000000), logument.ErrPathNotFound)
+
+	// Stored patches update the CurrentState as well
+	assert.Nil(t, lgm.Store(patches[1]))
+	assert.Nil(t, lgm.TestSet(2, tsonpatch.NewOperation(tsonpatch.OpRemove, "/speed", nil, 2000000000)))
+	assert.Nil(t, lgm.Append())
+
+	snapshot, err := lgm.Snapshot(2)
+	assert.Nil(t, err)
+	eq, err := tson.Equal(snapshot, lgm.CurrentState)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	_, err = lgm.ValueAt("/location/latitude", 1900000000)
+	assert.ErrorIs(t, err, logument.ErrPathNotFound)
+	_, err = lgm.ValueAtVersion("/speed", 2)
+	assert.ErrorIs(t, err, logument.ErrPathNotFound)
+}
+
+func TestArrayElements(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+
+	// Insert in the middle and at the end, then remove the first element
+	assert.Nil(t, lgm.Set(1, tsonpatch.NewOperation(tsonpatch.OpAdd, "/tirePressure/1", 30.0, 1800000000)))
+	assert.Nil(t, lgm.Set(1, tsonpatch.NewOperation(tsonpatch.OpAdd, "/tirePressure/-", 29.0, 1800000000)))
+	assert.Nil(t, lgm.Unset(1, "/tirePressure/0", 1900000000))
+
+	var values []float64
+	for _, v := range lgm.CurrentState.(tson.Object)["tirePressure"].(tson.Array) {
+		values = append(values, v.(tson.Leaf[float64]).Value)
+	}
+	assert.Equal(t, []float64{30.0, 31.8, 32.0, 31.9, 29.0}, values)
+
+	assert.ErrorIs(t, lgm.Set(1, tsonpatch.NewOperation(tsonpatch.OpAdd, "/tirePressure/9", 28.0, 1900000000)), logument.ErrInvalidPatch)
+	assert.ErrorIs(t, lgm.Unset(1, "/tirePressure/9", 1900000000), logument.ErrPathNotFound)
+	assert.ErrorIs(t, lgm.Set(1, tsonpatch.Operation{Op: tsonpatch.OpMove, Path: "/speed"}), logument.ErrInvalidPatch)
+	assert.Nil(t, lgm.Append())
+
+	snapshot, err := lgm.Snapshot(1)
+	assert.Nil(t, err)
+	eq, err := tson.Equal(snapshot, lgm.CurrentState)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	// Point lookups follow the shifted elements
+	for _, ts := range []int64{1700000000, 1800000000, 1900000000} {
+		snapshot, err := lgm.TemporalSnapshot(ts)
+		assert.Nil(t, err)
+		for i := 0; i < 5; i++ {
+			path := fmt.Sprintf("/tirePressure/%d", i)
+			expected, err := tson.GetValue(snapshot, path)
+			actual, lookupErr := lgm.ValueAt(path, ts)
+			if err != nil {
+				assert.ErrorIs(t, lookupErr, logument.ErrPathNotFound, "%s at %d", path, ts)
+				continue
+			}
+			assert.Nil(t, lookupErr)
+			assert.Equal(t, expected, actual.Value, "%s at %d", path, ts)
+		}
+	}
+	value, err := lgm.ValueAtVersion("/tirePressure/0", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[float64]{Value: 30.0, Timestamp: 1800000000}, value.Value)
+}
+
 func TestCompact(t *testing.T) {
 	t.Log("Compact patches\n")
-	lgm := logument.NewLogument(initSnapshot, nil)
+	lgm := newLogument(t, initSnapshot, nil)
 	lgm.Store(patches[0])
 	lgm.Store(patches[1])
 	lgm.Append()
@@ -253,13 +379,24 @@ func TestCompact(t *testing.T) {
 	lgm.Store(patches[2])
 	lgm.Append()
 
-	lgm.Compact("/location")
+	report, err := lgm.Compact("/location")
+	assert.Nil(t, err)
 	lgm.Print()
+
+	// The latitude and longitude of version 2 did not change
+	assert.Equal(t, 2, report.Count())
+	assert.Equal(t, 2, len(report.Removed[2]))
+	assert.Equal(t, 0, len(lgm.Patches[2]))
+
+	// Compacting again removes nothing
+	report, err = lgm.Compact("/location")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Count())
 }
 
 func TestHistory(t *testing.T) {
 	t.Log("Show history\n")
-	lgm := logument.NewLogument(initSnapshot, nil)
+	lgm := newLogument(t, initSnapshot, nil)
 	lgm.Store(patches[0])
 	lgm.Store(patches[1])
 	lgm.Append()
@@ -268,8 +405,16 @@ func TestHistory(t *testing.T) {
 	lgm.Append()
 
 	// Show history of the "/location"
-	his := lgm.History("/location")
+	his, err := lgm.History("/location")
+	if err != nil {
+		t.Error(err)
+	}
 	t.Log(spew.Sdump(his))
+
+	// The initial value and the change of version 1, but not the same value of version 2
+	assert.Equal(t, 2, len(his["/location/latitude"]))
+	// The history is read without compacting the Logument
+	assert.Equal(t, 2, len(lgm.Patches[2]))
 }
 
 func TestSimpleCaseWithLogument(t *testing.T) {
@@ -292,7 +437,7 @@ func TestSimpleCaseWithLogument(t *testing.T) {
 	}
 
 	// Logument로 적용
-	lgm := logument.NewLogument(initialState, nil)
+	lgm := newLogument(t, initialState, nil)
 
 	// 첫 번째 패치: 값 변경 (10.0 != 20.0)
 	for _, p := range patches[0] {
@@ -366,7 +511,7 @@ func TestLogumentSnapshotReference(t *testing.T) {
     }
     
     // Logument 초기화
-    lgm := logument.NewLogument(initialState, nil)
+    lgm := newLogument(t, initialState, nil)
     
     // 초기 스냅샷에 직접 접근
     snapshot0 := lgm.Snapshots[0]
@@ -387,4 +532,179 @@ func TestLogumentSnapshotReference(t *testing.T) {
             t.Logf("변경 후 값: %v", leaf.Value) // 10.0이 나와야 하지만 20.0이 나올 것
         }
     }
-}
\ No newline at end of file
+}
+func TestErrors(t *testing.T) {
+	_, err := logument.NewLogument(`{ "speed" <1700000000>: }`, nil)
+	assert.ErrorIs(t, err, logument.ErrInvalidSnapshot)
+	_, err = logument.NewLogument(42, nil)
+	assert.ErrorIs(t, err, logument.ErrInvalidSnapshot)
+
+	lgm := newLogument(t, initSnapshot, nil)
+	assert.ErrorIs(t, lgm.Store(`[{ "op": "replace", `), logument.ErrInvalidPatch)
+	assert.ErrorIs(t, lgm.Store(42), logument.ErrInvalidPatch)
+
+	lgm.Store(patches[0])
+	assert.Nil(t, lgm.Append()) // version 1
+
+	_, err = lgm.Snapshot(2)
+	assert.ErrorIs(t, err, logument.ErrVersionOutOfRange)
+	_, err = lgm.Slice(1, 0)
+	assert.ErrorIs(t, err, logument.ErrInvalidRange)
+	_, err = lgm.Slice(0, 5)
+	assert.ErrorIs(t, err, logument.ErrVersionOutOfRange)
+	_, err = lgm.Track(1, 0)
+	assert.ErrorIs(t, err, logument.ErrInvalidRange)
+	_, err = lgm.Track(0, 5)
+	assert.ErrorIs(t, err, logument.ErrVersionOutOfRange)
+	_, err = lgm.TemporalTrack(2000000000, 1000000000)
+	assert.ErrorIs(t, err, logument.ErrInvalidRange)
+	_, err = lgm.TemporalSlice(2000000000, 1000000000)
+	assert.ErrorIs(t, err, logument.ErrInvalidRange)
+
+	// A gap in the versions
+	lgm.Version = append(lgm.Version, 5)
+	_, err = lgm.Snapshot(1)
+	assert.ErrorIs(t, err, logument.ErrNotContinuous)
+	_, err = lgm.History("/location")
+	assert.ErrorIs(t, err, logument.ErrNotContinuous)
+	assert.ErrorIs(t, lgm.Append(), logument.ErrNotGrown)
+
+	// Operations that cannot be applied
+	lgm = newLogument(t, initSnapshot, nil)
+	err = lgm.Set(1, tsonpatch.Operation{Op: tsonpatch.OpReplace, Path: "/speed", Value: complex(1, 2)})
+	assert.ErrorIs(t, err, logument.ErrInvalidPatch)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+
+	const rounds = 50
+	var wg sync.WaitGroup
+
+	// A single writer keeps ingesting new versions
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			op := tsonpatch.Operation{Op: tsonpatch.OpReplace, Path: "/speed", Value: float64(i), Timestamp: int64(1800000000 + i)}
+			assert.Nil(t, lgm.Set(uint64(i+1), op))
+			assert.Nil(t, lgm.Store(patches[i%len(patches)]))
+			assert.Nil(t, lgm.Append())
+		}
+	}()
+
+	// Many readers query the versions available at the time
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				latest := lgm.LatestVersion()
+				snapshot, err := lgm.Snapshot(latest)
+				assert.Nil(t, err)
+				assert.NotNil(t, snapshot)
+				_, err = lgm.Slice(0, latest)
+				assert.Nil(t, err)
+				_, err = lgm.TemporalTrack(1700000000, 2500000000)
+				assert.Nil(t, err)
+				_, err = lgm.History("/location")
+				assert.Nil(t, err)
+				_, err = lgm.TemporalSnapshot(1900000000)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, uint64(rounds), lgm.LatestVersion())
+	snapshot, err := lgm.Snapshot(rounds)
+	assert.Nil(t, err)
+	speed, err := tson.GetValue(snapshot, "/speed")
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[float64]{Value: rounds - 1, Timestamp: 1800000000 + rounds - 1}, speed)
+}
+
+func TestSnapshotsUnchanged(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	assert.Nil(t, lgm.Store(patches[0]))
+	assert.Nil(t, lgm.Append())
+
+	// Snapshots share the values patches do not change, but are never modified
+	before, err := tson.Clone(lgm.Snapshots[0])
+	assert.Nil(t, err)
+	snapshot, err := lgm.Snapshot(1)
+	assert.Nil(t, err)
+	taken, err := tson.Clone(snapshot)
+	assert.Nil(t, err)
+
+	assert.Nil(t, lgm.Store(patches[1]))
+	assert.Nil(t, lgm.Set(2, tsonpatch.NewOperation(tsonpatch.OpAdd, "/tirePressure/0", 30.0, 2000000000)))
+	assert.NotNil(t, lgm.Store(`[
+		{ "op": "replace", "path": "/location/latitude", "value": 0.0, "timestamp": 2100000000 },
+		{ "op": "remove", "path": "/tirePressure/9", "timestamp": 2100000000 }
+	]`))
+	assert.Nil(t, lgm.Append())
+	_, err = lgm.Snapshot(2)
+	assert.Nil(t, err)
+	_, err = lgm.TemporalSnapshot(2000000000)
+	assert.Nil(t, err)
+
+	eq, err := tson.Equal(lgm.Snapshots[0], before)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+	snapshot, err = lgm.Snapshot(1)
+	assert.Nil(t, err)
+	eq, err = tson.Equal(snapshot, taken)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	latitude, err := tson.GetValue(lgm.CurrentState, "/location/latitude")
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[float64]{Value: 43.9409, Timestamp: 1800000000}, latitude)
+}
+
+func TestCompositeValues(t *testing.T) {
+	const base = `{ "speed" <100>: 10.5, "car": { "a" <100>: 1.5 } }`
+	lgm := newLogument(t, base, nil)
+	for _, p := range []string{
+		`[{ "op": "replace", "path": "/car", "value": { "a": 2.0 }, "timestamp": 200 }]`,
+		`[{ "op": "replace", "path": "/car", "value": { "a": 3.0 }, "timestamp": 300 }]`,
+		`[{ "op": "replace", "path": "/car", "value": { "a": 3.0 }, "timestamp": 400 }]`,
+		`[{ "op": "add", "path": "/tires", "value": [ 32.0, 31.8 ], "timestamp": 400 }]`,
+	} {
+		assert.Nil(t, lgm.Store(p))
+		assert.Nil(t, lgm.Append())
+	}
+
+	// Objects and arrays are compared by value
+	changes, err := lgm.Track(1, 4)
+	assert.Nil(t, err)
+	assert.Len(t, changes[2], 1)
+	assert.Len(t, changes[3], 0)
+	changes, err = lgm.TemporalTrack(0, 500)
+	assert.Nil(t, err)
+	assert.Len(t, changes[3], 0)
+	his, err := lgm.History("/car")
+	assert.Nil(t, err)
+	assert.Len(t, his["/car"], 3)
+
+	// A branch writing the same object does not conflict
+	branch, err := lgm.Fork("same", 3)
+	assert.Nil(t, err)
+	assert.Nil(t, branch.Store(`[{ "op": "replace", "path": "/car", "value": { "a": 3.0 }, "timestamp": 400 }]`))
+	report, err := lgm.Merge("same")
+	assert.Nil(t, err)
+	assert.Empty(t, report.Conflicts)
+
+	other := newLogument(t, base, nil)
+	assert.Nil(t, other.Store(`[{ "op": "replace", "path": "/car", "value": { "a": 2.0 }, "timestamp": 200 }]`))
+	assert.Nil(t, other.Append())
+	_, conflicts, err := logument.Combine(lgm, other)
+	assert.Nil(t, err)
+	assert.Empty(t, conflicts)
+
+	report2, err := lgm.Compact("/car")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report2.Count())
+}
@@ -0,0 +1,67 @@
+//
+// order.go
+//
+// The total order of the patches of a Logument.
+//
+// Vehicles often emit several signals with the same timestamp
+// (e.g. in a single CAN frame), so timestamps alone do not order
+// patches. Every temporal query orders patches by:
+//
+//  1. their timestamp,
+//  2. the version they belong to, and
+//  3. their position in that version, i.e. the order they were stored.
+//
+// Since no two patches share a version and a position, the order
+// is total, and temporal queries give the same results on every run.
+//
+
+package logument
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+)
+
+// OrderedPatch is a patch along with its position in the Logument
+type OrderedPatch struct {
+	Version uint64              // Version the patch belongs to
+	Seq     int                 // Position of the patch in its version
+	Op      tsonpatch.Operation // The patch itself
+}
+
+// Compare compares p and o by (timestamp, version, seq),
+// returning -1 if p comes first, +1 if o comes first, and 0 if they are at the same position.
+func (p OrderedPatch) Compare(o OrderedPatch) int {
+	return cmp.Or(
+		cmp.Compare(p.Op.Timestamp, o.Op.Timestamp),
+		cmp.Compare(p.Version, o.Version),
+		cmp.Compare(p.Seq, o.Seq),
+	)
+}
+
+// SortPatches sorts patches by (timestamp, version, seq).
+func SortPatches(patches []OrderedPatch) {
+	slices.SortFunc(patches, OrderedPatch.Compare)
+}
+
+// Ordered returns the appended patches with a timestamp between tsi and tsj (inclusive),
+// in the order temporal queries apply them.
+func (lgm *Logument) Ordered(tsi, tsj int64) ([]OrderedPatch, error) {
+	if tsi > tsj {
+		return nil, fmt.Errorf("%w: start timestamp tsi %d is greater than end timestamp tsj %d", ErrInvalidRange, tsi, tsj)
+	}
+
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	var ordered []OrderedPatch
+	for _, path := range lgm.index.paths("/") {
+		ordered = append(ordered, lgm.index.between(path, tsi, tsj)...)
+	}
+	SortPatches(ordered)
+
+	return ordered, nil
+}
@@ -0,0 +1,86 @@
+package logument_test
+
+import (
+	"testing"
+
+	"github.com/CAU-CPSS/logument/internal/logument"
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+	"github.com/stretchr/testify/assert"
+)
+
+// Signals of a single frame share their timestamp
+var framePatches = []string{
+	`[
+		{ "op": "replace", "path": "/speed", "value": 80.0, "timestamp": 1800000000 },
+		{ "op": "replace", "path": "/engineOn", "value": false, "timestamp": 1800000000 },
+		{ "op": "replace", "path": "/speed", "value": 81.0, "timestamp": 1800000000 }
+	]`,
+	`[
+		{ "op": "replace", "path": "/speed", "value": 82.0, "timestamp": 1800000000 },
+		{ "op": "replace", "path": "/speed", "value": 70.0, "timestamp": 1750000000 }
+	]`,
+}
+
+func TestEqualTimestamps(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	for _, p := range framePatches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+
+	ordered, err := lgm.Ordered(0, 2000000000)
+	assert.Nil(t, err)
+	var positions [][2]int
+	for _, p := range ordered {
+		positions = append(positions, [2]int{int(p.Version), p.Seq})
+	}
+	assert.Equal(t, [][2]int{{2, 1}, {1, 0}, {1, 1}, {1, 2}, {2, 0}}, positions)
+
+	// The last patch in the order wins, on every run
+	for i := 0; i < 20; i++ {
+		snapshot, err := lgm.TemporalSnapshot(1800000000)
+		assert.Nil(t, err)
+		speed, err := tson.GetValue(snapshot, "/speed")
+		assert.Nil(t, err)
+		assert.Equal(t, tson.Leaf[float64]{Value: 82.0, Timestamp: 1800000000}, speed)
+
+		value, err := lgm.ValueAt("/speed", 1800000000)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(2), value.Version)
+	}
+
+	snapshot, err := lgm.TemporalSnapshot(1799999999)
+	assert.Nil(t, err)
+	speed, err := tson.GetValue(snapshot, "/speed")
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[float64]{Value: 70.0, Timestamp: 1750000000}, speed)
+
+	timeline, err := lgm.Timeline("/speed", 0, 2000000000)
+	assert.Nil(t, err)
+	var values []any
+	for _, p := range timeline {
+		values = append(values, p.Value)
+	}
+	assert.Equal(t, []any{70.0, 80.0, 81.0, 82.0}, values)
+}
+
+func TestSortPatches(t *testing.T) {
+	op := func(ts int64) tsonpatch.Operation {
+		return tsonpatch.Operation{Op: tsonpatch.OpReplace, Path: "/speed", Timestamp: ts}
+	}
+	patches := []logument.OrderedPatch{
+		{Version: 2, Seq: 0, Op: op(10)},
+		{Version: 1, Seq: 1, Op: op(10)},
+		{Version: 3, Seq: 0, Op: op(5)},
+		{Version: 1, Seq: 0, Op: op(10)},
+	}
+	logument.SortPatches(patches)
+	assert.Equal(t, []logument.OrderedPatch{
+		{Version: 3, Seq: 0, Op: op(5)},
+		{Version: 1, Seq: 0, Op: op(10)},
+		{Version: 1, Seq: 1, Op: op(10)},
+		{Version: 2, Seq: 0, Op: op(10)},
+	}, patches)
+	assert.Equal(t, 0, patches[0].Compare(patches[0]))
+}
@@ -0,0 +1,169 @@
+//
+// retention.go
+//
+// Retention and truncation of old versions.
+//
+// Truncating a Logument folds the patches up to a version into
+// a new base snapshot of that version, and drops the older part
+// of Version, Patches and Snapshots. The truncated Logument starts
+// at the base version instead of 0.
+//
+
+package logument
+
+import (
+	"fmt"
+
+	"github.com/CAU-CPSS/logument/internal/tson"
+)
+
+// RetentionPolicy decides which versions `Append` keeps.
+// Each rule is disabled by its zero value; when several rules are set,
+// the strictest one wins.
+type RetentionPolicy struct {
+	KeepVersions uint64 // Keep the last N versions (including the base snapshot)
+	KeepSpan     int64  // Keep the versions with patches within T of the latest timestamp (ns)
+	KeepBytes    int    // Keep the latest versions whose patches fit in this size (in JSON bytes; the base snapshot is not counted)
+}
+
+// SetRetentionPolicy sets the policy `Append` follows to truncate old versions.
+func (lgm *Logument) SetRetentionPolicy(policy RetentionPolicy) {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	lgm.retention = policy
+}
+
+// RetentionPolicy returns the current retention policy.
+func (lgm *Logument) RetentionPolicy() RetentionPolicy {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	return lgm.retention
+}
+
+// FirstVersion returns the oldest version kept in the Logument
+func (lgm *Logument) FirstVersion() uint64 {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	return lgm.Version[0]
+}
+
+// Truncate folds the versions up to vk into a base snapshot of vk,
+// and drops the older versions, patches and snapshots.
+func (lgm *Logument) Truncate(vk uint64) error {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	return lgm.truncate(vk)
+}
+
+func (lgm *Logument) truncate(vk uint64) error {
+	if !lgm.isContinuous() {
+		return ErrNotContinuous
+	}
+
+	first, latest := lgm.Version[0], lgm.Version[len(lgm.Version)-1]
+	if vk < first || vk > latest {
+		return fmt.Errorf("%w: version %d is not between %d and %d", ErrVersionOutOfRange, vk, first, latest)
+	}
+	if vk == first {
+		return nil
+	}
+
+	base, exists := lgm.Snapshots[vk]
+	if !exists {
+		var err error
+		if base, err = lgm.snapshot(vk); err != nil {
+			return err
+		}
+	}
+
+	if s := lgm.storage; s != nil {
+		if !exists {
+			if err := s.writeSnapshot(vk, base); err != nil {
+				return err
+			}
+		}
+		if err := s.rewriteWAL(lgm.truncatedRecords(vk)); err != nil {
+			return err
+		}
+		if err := s.removeSnapshots(lgm.Snapshots, func(v uint64) bool { return v < vk }); err != nil {
+			return err
+		}
+	}
+
+	lgm.Snapshots[vk] = base
+	for v := range lgm.Snapshots {
+		if v < vk {
+			delete(lgm.Snapshots, v)
+		}
+	}
+	for v := range lgm.Patches {
+		if v <= vk {
+			delete(lgm.Patches, v)
+		}
+	}
+	lgm.Version = append([]uint64(nil), lgm.Version[vk-first:]...)
+	lgm.generation++
+	lgm.rebuildIndex()
+
+	return nil
+}
+
+// truncatedRecords returns the WAL records of the Logument truncated at vk
+func (lgm *Logument) truncatedRecords(vk uint64) []walRecord {
+	// CurrentState was already valid TSON, so marshaling it cannot fail
+	state, _ := tson.Marshal(lgm.CurrentState)
+
+	records := []walRecord{{Kind: walReset, Version: vk, State: string(state), Patch: lgm.PatchPool}}
+	for _, v := range lgm.Version {
+		if v > vk {
+			records = append(records, walRecord{Kind: walVersion, Version: v, Patch: lgm.Patches[v]})
+		}
+	}
+	return records
+}
+
+// retentionBase returns the version the retention policy truncates the Logument at
+func (lgm *Logument) retentionBase() uint64 {
+	p := lgm.retention
+	first, latest := lgm.Version[0], lgm.Version[len(lgm.Version)-1]
+	base := first
+
+	if p.KeepVersions > 0 && latest-first+1 > p.KeepVersions {
+		base = max(base, latest-p.KeepVersions+1)
+	}
+
+	if p.KeepSpan > 0 && lgm.index.indexed {
+		// The newest version whose patches are all older than the span.
+		// A version without patches takes the time range of the previous one.
+		newer := latest + 1 // The oldest version with patches seen so far
+		for v := latest; v > base; v-- {
+			r := lgm.index.versions[v]
+			if r.empty {
+				continue
+			}
+			if r.max < lgm.index.watermark-p.KeepSpan {
+				base = newer - 1
+				break
+			}
+			newer = v
+		}
+	}
+
+	if p.KeepBytes > 0 {
+		// The newest version that does not fit in the size anymore
+		size := 0
+		for v := latest; v > base; v-- {
+			size += lgm.index.sizes[v]
+			if size > p.KeepBytes {
+				base = v
+				break
+			}
+		}
+	}
+
+	return base
+}
@@ -0,0 +1,150 @@
+package logument_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CAU-CPSS/logument/internal/logument"
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncate(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	for _, p := range patches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+	expected, err := lgm.Snapshot(4)
+	assert.Nil(t, err)
+
+	assert.Nil(t, lgm.Truncate(2))
+	assert.Equal(t, []uint64{2, 3, 4}, lgm.Version)
+	assert.Equal(t, uint64(2), lgm.FirstVersion())
+	assert.NotContains(t, lgm.Snapshots, uint64(0))
+	assert.NotContains(t, lgm.Patches, uint64(2))
+
+	// The truncated Logument keeps working from its base version
+	actual, err := lgm.Snapshot(4)
+	assert.Nil(t, err)
+	eq, err := tson.Equal(expected, actual)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	sliced, err := lgm.Slice(2, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{3}, sliced.Version)
+	assert.Contains(t, sliced.Snapshots, uint64(2))
+
+	changes, err := lgm.Track(2, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(changes[3]))
+
+	_, err = lgm.Snapshot(1)
+	assert.ErrorIs(t, err, logument.ErrVersionOutOfRange)
+	_, err = lgm.Slice(1, 3)
+	assert.ErrorIs(t, err, logument.ErrVersionOutOfRange)
+	_, err = lgm.Track(0, 3)
+	assert.ErrorIs(t, err, logument.ErrVersionOutOfRange)
+	assert.ErrorIs(t, lgm.Truncate(5), logument.ErrVersionOutOfRange)
+
+	lgm.Store(patches[0])
+	assert.Nil(t, lgm.Append())
+	assert.Equal(t, []uint64{2, 3, 4, 5}, lgm.Version)
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	// Patch counts of the versions 1 to 4 are 3, 1, 2, 2,
+	// and their latest timestamps are 1900000000, 2000000000, 2100000000 and 2400000000
+	tests := []struct {
+		name     string
+		policy   logument.RetentionPolicy
+		expected []uint64
+	}{
+		{"disabled", logument.RetentionPolicy{}, []uint64{0, 1, 2, 3, 4}},
+		{"versions", logument.RetentionPolicy{KeepVersions: 2}, []uint64{3, 4}},
+		{"span", logument.RetentionPolicy{KeepSpan: 350000000}, []uint64{2, 3, 4}},
+		{"bytes", logument.RetentionPolicy{KeepBytes: 1}, []uint64{4}},
+		{"strictest", logument.RetentionPolicy{KeepVersions: 4, KeepSpan: 350000000}, []uint64{2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lgm := newLogument(t, initSnapshot, nil)
+			lgm.SetRetentionPolicy(tt.policy)
+			for _, p := range patches {
+				lgm.Store(p)
+				assert.Nil(t, lgm.Append())
+			}
+			assert.Equal(t, tt.expected, lgm.Version)
+			assert.Contains(t, lgm.Snapshots, tt.expected[0])
+		})
+	}
+}
+
+func TestRetentionBeforeEpoch(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	lgm.SetRetentionPolicy(logument.RetentionPolicy{KeepSpan: 150})
+	for _, ts := range []int64{-300, -200, -100} {
+		assert.Nil(t, lgm.Set(lgm.Version[len(lgm.Version)-1]+1, tsonpatch.Operation{Op: tsonpatch.OpReplace, Path: "/speed", Value: float64(ts), Timestamp: ts}))
+		assert.Nil(t, lgm.Append())
+	}
+	// The patches of version 1 are older than -100 - 150, not those of version 2
+	assert.Equal(t, []uint64{1, 2, 3}, lgm.Version)
+}
+
+func TestRetentionEmptyVersion(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	speeds := []struct {
+		value float64
+		ts    int64
+	}{{1.0, 1000}, {2.0, 2000}, {2.0, 2050}, {3.0, 2100}}
+	for i, speed := range speeds {
+		if i == len(speeds)-1 {
+			// Version 3 only changed the timestamp, so it has no patches left
+			_, err := lgm.Compact("/speed")
+			assert.Nil(t, err)
+			assert.Empty(t, lgm.Patches[3])
+			lgm.SetRetentionPolicy(logument.RetentionPolicy{KeepSpan: 500})
+		}
+		assert.Nil(t, lgm.Set(uint64(i+1), tsonpatch.Operation{Op: tsonpatch.OpReplace, Path: "/speed", Value: speed.value, Timestamp: speed.ts}))
+		assert.Nil(t, lgm.Append())
+	}
+	// Version 3 was appended after version 2, within the span
+	assert.Equal(t, []uint64{1, 2, 3, 4}, lgm.Version)
+}
+
+func TestRecoverTruncated(t *testing.T) {
+	dir := t.TempDir()
+
+	lgm, err := logument.Open(dir, initSnapshot, logument.StorageOptions{})
+	assert.Nil(t, err)
+	lgm.Set(1, tsonpatch.Operation{Op: tsonpatch.OpReplace, Path: "/speed", Value: 80.0, Timestamp: 1750000000})
+	for _, p := range patches[:3] {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+	assert.Nil(t, lgm.Truncate(2))
+	lgm.Store(patches[3]) // Left in the PatchPool
+	assert.Nil(t, lgm.Close())
+
+	entries, err := os.ReadDir(filepath.Join(dir, "snapshots"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries)) // Only the base version 2
+
+	recovered, err := logument.Open(dir, nil, logument.StorageOptions{})
+	assert.Nil(t, err)
+	defer recovered.Close()
+
+	assert.Equal(t, []uint64{2, 3}, recovered.Version)
+	assert.Equal(t, lgm.Patches, recovered.Patches)
+	assert.Equal(t, lgm.PatchPool, recovered.PatchPool)
+	eq, err := tson.Equal(lgm.CurrentState, recovered.CurrentState)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	assert.Nil(t, recovered.Append())
+	assert.Equal(t, []uint64{2, 3, 4}, recovered.Version)
+}
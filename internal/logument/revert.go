@@ -0,0 +1,88 @@
+//
+// revert.go
+//
+// Reverting a Logument to an earlier version, e.g. to undo a faulty
+// configuration push.
+//
+// `Revert` does not rewrite history: it inverts the patches after the
+// version (see tsonpatch.Invert), and stores the inverse in the
+// PatchPool, so that the next version holds the values of the earlier
+// one again. The inverse is stamped as written at the time of the
+// revert, after every stored patch, so that temporal queries (e.g.
+// `TemporalSnapshot` and `ValueAt`) see the revert as well, while
+// the faulty values stay in the history, at the time they were written.
+//
+
+package logument
+
+import (
+	"fmt"
+
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+)
+
+// Revert restores the state of the version vk, and returns the patches that do so.
+//
+// It reverts everything after vk: every later version and the PatchPool,
+// not the version vk+1 alone, so a faulty version cannot be undone while
+// keeping the later ones. The patches are stored in the PatchPool: they
+// change the CurrentState at once, but are appended with the next `Append`,
+// along with the patches pooled before. They are stamped one past the latest
+// timestamp of the Logument (see `latestTimestamp`), as are the leaves of
+// the values they restore.
+func (lgm *Logument) Revert(vk uint64) (tsonPatches, error) {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	snapshot, err := lgm.snapshot(vk)
+	if err != nil {
+		return nil, err
+	}
+
+	var undone tsonPatches
+	for v := vk + 1; v <= lgm.Version[len(lgm.Version)-1]; v++ {
+		undone = append(undone, lgm.Patches[v]...)
+	}
+	undone = append(undone, lgm.PatchPool...)
+	inverse, err := tsonpatch.Invert(snapshot, undone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+	}
+	if len(inverse) == 0 {
+		return inverse, nil
+	}
+
+	ts := lgm.latestTimestamp() + 1
+	for i, op := range inverse {
+		inverse[i].Timestamp = ts
+		if v, ok := op.Value.(tson.Value); ok { // An object or an array
+			inverse[i].Value = tson.WithTimestamp(v, ts)
+		}
+	}
+
+	state, err := lgm.nextState(inverse)
+	if err != nil {
+		return nil, err
+	}
+	if err := lgm.logRecord(walRecord{Kind: walStore, Patch: inverse}); err != nil {
+		return nil, err
+	}
+	lgm.CurrentState = state
+	lgm.PatchPool = append(lgm.PatchPool, inverse...)
+
+	return inverse, nil
+}
+
+// latestTimestamp returns the latest timestamp of the Logument: of the
+// appended patches, of the PatchPool, and of the leaves of the CurrentState.
+func (lgm *Logument) latestTimestamp() int64 {
+	latest := tson.GetLatestTimestamp(lgm.CurrentState)
+	if lgm.index.indexed {
+		latest = max(latest, lgm.index.watermark)
+	}
+	for _, p := range lgm.PatchPool {
+		latest = max(latest, p.Timestamp)
+	}
+	return latest
+}
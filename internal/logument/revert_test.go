@@ -0,0 +1,90 @@
+package logument_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/CAU-CPSS/logument/internal/logument"
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevert(t *testing.T) {
+	dir := t.TempDir()
+
+	lgm, err := logument.Open(dir, initSnapshot, logument.StorageOptions{})
+	assert.Nil(t, err)
+	lgm.SetLatenessPolicy(logument.LatenessPolicy{Window: 1000000000})
+	assert.Nil(t, lgm.Store(patches[0]))
+	assert.Nil(t, lgm.Append()) // version 1
+
+	// A faulty push, appended, then left in the PatchPool
+	assert.Nil(t, lgm.Store(`[
+		{ "op": "replace", "path": "/speed", "value": "fast", "timestamp": 2000000000 },
+		{ "op": "remove", "path": "/tirePressure/1", "timestamp": 2000000000 },
+		{ "op": "add", "path": "/config/mode", "value": "sport", "timestamp": 2000000000 }
+	]`))
+	assert.Nil(t, lgm.Append()) // version 2
+	assert.Nil(t, lgm.Store(patches[1]))
+	assert.Nil(t, lgm.Set(3, tsonpatch.NewOperation(tsonpatch.OpAdd, "/tirePressure/-", 30.0, 2100000000)))
+
+	inverse, err := lgm.Revert(1)
+	assert.Nil(t, err)
+	// The inverse is stamped after the latest patch (of the Set)
+	const revertedAt = 2100000001
+	assert.Contains(t, inverse, tsonpatch.NewOperation(tsonpatch.OpReplace, "/speed", 72.5, revertedAt))
+	assert.Contains(t, inverse, tsonpatch.NewOperation(tsonpatch.OpRemove, "/config", nil, revertedAt))
+
+	// The pooled patches are reverted as well, and the inverse is pooled after them until the next Append
+	assert.Contains(t, inverse, tsonpatch.NewOperation(tsonpatch.OpReplace, "/engineOn", true, revertedAt))
+	assert.Len(t, lgm.PatchPool, 2+len(inverse))
+	assert.Equal(t, inverse, lgm.PatchPool[2:])
+	engineOn, err := tson.GetValue(lgm.CurrentState, "/engineOn")
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[bool]{Value: true, Timestamp: revertedAt}, engineOn)
+
+	// The next version holds the values of version 1 again
+	snapshot, err := lgm.Snapshot(1)
+	assert.Nil(t, err)
+	eq, err := tson.EqualWithoutTimestamp(lgm.CurrentState, snapshot)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+	assert.Nil(t, lgm.Append())
+	assert.Equal(t, []uint64{0, 1, 2, 3}, lgm.Version)
+	latest, err := lgm.Snapshot(3)
+	assert.Nil(t, err)
+	eq, err = tson.EqualWithoutTimestamp(latest, snapshot)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	// Temporal queries see the revert, and the faulty values before it
+	temporal, err := lgm.TemporalSnapshot(math.MaxInt64)
+	assert.Nil(t, err)
+	eq, err = tson.Equal(temporal, latest)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+	speed, err := lgm.ValueAt("/speed", math.MaxInt64)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[float64]{Value: 72.5, Timestamp: revertedAt}, speed.Value)
+	speed, err = lgm.ValueAt("/speed", revertedAt-1)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[string]{Value: "fast", Timestamp: 2000000000}, speed.Value)
+
+	// Reverting to the latest version, with an empty PatchPool, changes nothing
+	inverse, err = lgm.Revert(3)
+	assert.Nil(t, err)
+	assert.Empty(t, inverse)
+	_, err = lgm.Revert(4)
+	assert.ErrorIs(t, err, logument.ErrVersionOutOfRange)
+	assert.Nil(t, lgm.Close())
+
+	// The revert is recovered from the storage
+	recovered, err := logument.Open(dir, nil, logument.StorageOptions{})
+	assert.Nil(t, err)
+	defer recovered.Close()
+	assert.Equal(t, lgm.Patches, recovered.Patches)
+	eq, err = tson.Equal(recovered.CurrentState, latest)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+}
@@ -0,0 +1,511 @@
+//
+// storage.go
+//
+// Durable on-disk storage for a Logument.
+//
+// A Logument opened with `Open` keeps two kinds of files
+// in its directory:
+//
+//   - wal.log: a write-ahead log of every Store, Set, Append and Compact,
+//     written (and synced) before the in-memory state changes.
+//   - snapshots/<version>.tson: one TSON file per stored Snapshot.
+//
+// On startup, the snapshot files are loaded and the WAL is replayed
+// on top of them, which rebuilds Version, Snapshots, Patches,
+// PatchPool and CurrentState exactly as they were.
+//
+// When the Logument is truncated (see retention.go), the WAL is rewritten
+// so that it starts with a reset record for the new base version,
+// and the snapshot files of older versions are removed.
+//
+
+package logument
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+)
+
+const (
+	walFileName      = "wal.log"
+	snapshotDirName  = "snapshots"
+	snapshotFileExt  = ".tson"
+	walHeaderSize    = 8 // 4 bytes of payload length + 4 bytes of CRC32
+	walMaxRecordSize = 1 << 30
+)
+
+// Kinds of WAL records
+const (
+	walStore   = "store"   // Patches stored in the PatchPool
+	walSet     = "set"     // Operations applied by Set, amending Version if it is already appended
+	walAppend  = "append"  // PatchPool appended as a new version
+	walCompact = "compact" // Patches compacted at a path
+	walReset   = "reset"   // Base version of a truncated log, with its CurrentState and PatchPool
+	walVersion = "version" // Patches of a version kept by a truncation
+	walMerge   = "merge"   // Late patches merged into Version in timestamp order
+)
+
+// walRecord is a single entry of the write-ahead log.
+type walRecord struct {
+	Kind    string          `json:"kind"`
+	Version uint64          `json:"version,omitempty"`
+	Patch   tsonpatch.Patch `json:"patch,omitempty"`
+	Path    string          `json:"path,omitempty"`
+	State   string          `json:"state,omitempty"` // CurrentState in TSON, for reset records
+}
+
+// StorageOptions configures the on-disk storage of a Logument.
+type StorageOptions struct {
+	SnapshotInterval uint64 // Write a snapshot file every N appended versions (sets CheckpointPolicy.EveryVersions)
+	NoSync           bool   // Skip fsync after each write (faster, but not crash-safe)
+}
+
+// storage is the on-disk backend of a Logument.
+type storage struct {
+	dir  string
+	wal  *os.File
+	opts StorageOptions
+}
+
+// Open opens the Logument stored in dir, recovering it from the
+// snapshot files and the write-ahead log.
+// If dir does not contain a Logument yet, a new one is created
+// with the given initial snapshot (see NewLogument).
+func Open(dir string, initialSnapshot any, opts StorageOptions) (*Logument, error) {
+	if err := os.MkdirAll(filepath.Join(dir, snapshotDirName), 0o755); err != nil {
+		return nil, fmt.Errorf("%w: failed to create storage directory: %w", ErrStorage, err)
+	}
+
+	s := &storage{dir: dir, opts: opts}
+
+	snapshots, err := s.readSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	var lgm *Logument
+	if len(snapshots) == 0 { // Fresh directory
+		if lgm, err = NewLogument(initialSnapshot, nil); err != nil {
+			return nil, err
+		}
+		if err := s.writeSnapshot(0, lgm.Snapshots[0]); err != nil {
+			return nil, err
+		}
+	} else {
+		if lgm, err = s.recover(snapshots); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.wal, err = os.OpenFile(s.walPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return nil, fmt.Errorf("%w: failed to open WAL: %w", ErrStorage, err)
+	}
+	lgm.storage = s
+	lgm.policy.EveryVersions = opts.SnapshotInterval
+
+	return lgm, nil
+}
+
+// Close closes the underlying files of a Logument opened with Open.
+// The in-memory Logument stays usable, but is no longer persisted.
+func (lgm *Logument) Close() error {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	if lgm.storage == nil {
+		return nil
+	}
+	err := lgm.storage.wal.Close()
+	lgm.storage = nil
+	if err != nil {
+		return fmt.Errorf("%w: failed to close WAL: %w", ErrStorage, err)
+	}
+	return nil
+}
+
+// Checkpoint takes a snapshot of the latest version and, if the Logument
+// was opened with Open, writes it to a snapshot file.
+func (lgm *Logument) Checkpoint() error {
+	lgm.mu.Lock()
+	defer lgm.mu.Unlock()
+
+	return lgm.checkpoint()
+}
+
+func (lgm *Logument) checkpoint() error {
+	latestVersion := lgm.Version[len(lgm.Version)-1]
+	if _, exists := lgm.Snapshots[latestVersion]; exists {
+		return nil
+	}
+	snapshot, err := lgm.snapshot(latestVersion)
+	if err != nil {
+		return err
+	}
+	return lgm.addSnapshot(latestVersion, snapshot)
+}
+
+// addSnapshot keeps s as the snapshot of version v, persisting it if needed.
+func (lgm *Logument) addSnapshot(v uint64, s tsonSnapshot) error {
+	if lgm.storage != nil {
+		if err := lgm.storage.writeSnapshot(v, s); err != nil {
+			return err
+		}
+	}
+	lgm.Snapshots[v] = s
+	if v == lgm.Version[len(lgm.Version)-1] {
+		lgm.sinceCheckpoint = checkpointStats{}
+	}
+	return nil
+}
+
+// logRecord writes rec to the WAL, if the Logument is persisted.
+func (lgm *Logument) logRecord(rec walRecord) error {
+	if lgm.storage == nil {
+		return nil
+	}
+	return lgm.storage.append(rec)
+}
+
+func (s *storage) walPath() string {
+	return filepath.Join(s.dir, walFileName)
+}
+
+func (s *storage) snapshotPath(v uint64) string {
+	return filepath.Join(s.dir, snapshotDirName, fmt.Sprintf("%020d%s", v, snapshotFileExt))
+}
+
+// encodeRecord frames a WAL record: [length][crc32][json payload].
+func encodeRecord(rec walRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to encode WAL record: %w", ErrStorage, err)
+	}
+
+	buf := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[walHeaderSize:], payload)
+	return buf, nil
+}
+
+// append writes a framed record to the WAL.
+func (s *storage) append(rec walRecord) error {
+	buf, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.wal.Write(buf); err != nil {
+		return fmt.Errorf("%w: failed to write WAL: %w", ErrStorage, err)
+	}
+	if !s.opts.NoSync {
+		if err := s.wal.Sync(); err != nil {
+			return fmt.Errorf("%w: failed to sync WAL: %w", ErrStorage, err)
+		}
+	}
+	return nil
+}
+
+// writeSnapshot atomically writes the snapshot of version v.
+func (s *storage) writeSnapshot(v uint64, snapshot tsonSnapshot) error {
+	b, err := tson.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("%w: failed to marshal snapshot %d: %w", ErrStorage, v, err)
+	}
+
+	path := s.snapshotPath(v)
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create snapshot file: %w", ErrStorage, err)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("%w: failed to write snapshot file: %w", ErrStorage, err)
+	}
+	if !s.opts.NoSync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("%w: failed to sync snapshot file: %w", ErrStorage, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%w: failed to close snapshot file: %w", ErrStorage, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("%w: failed to rename snapshot file: %w", ErrStorage, err)
+	}
+	return s.syncDir(filepath.Dir(path))
+}
+
+// rewriteWAL atomically replaces the WAL with the given records.
+func (s *storage) rewriteWAL(records []walRecord) error {
+	tmp := s.walPath() + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create WAL: %w", ErrStorage, err)
+	}
+	w := bufio.NewWriter(f)
+	for _, rec := range records {
+		buf, err := encodeRecord(rec)
+		if err == nil {
+			_, err = w.Write(buf)
+		}
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%w: failed to write WAL: %w", ErrStorage, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("%w: failed to write WAL: %w", ErrStorage, err)
+	}
+	if !s.opts.NoSync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("%w: failed to sync WAL: %w", ErrStorage, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%w: failed to close WAL: %w", ErrStorage, err)
+	}
+
+	if err := s.wal.Close(); err != nil {
+		return fmt.Errorf("%w: failed to close WAL: %w", ErrStorage, err)
+	}
+	if err := os.Rename(tmp, s.walPath()); err != nil {
+		return fmt.Errorf("%w: failed to rename WAL: %w", ErrStorage, err)
+	}
+	if s.wal, err = os.OpenFile(s.walPath(), os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return fmt.Errorf("%w: failed to open WAL: %w", ErrStorage, err)
+	}
+	return s.syncDir(s.dir)
+}
+
+// removeSnapshots removes the snapshot files of the versions in snapshots for which drop is true.
+func (s *storage) removeSnapshots(snapshots map[uint64]tsonSnapshot, drop func(v uint64) bool) error {
+	for version := range snapshots {
+		if !drop(version) {
+			continue
+		}
+		if err := os.Remove(s.snapshotPath(version)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: failed to remove snapshot file: %w", ErrStorage, err)
+		}
+	}
+	return s.syncDir(filepath.Join(s.dir, snapshotDirName))
+}
+
+// syncDir makes a rename or removal inside dir durable.
+func (s *storage) syncDir(dir string) error {
+	if s.opts.NoSync {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("%w: failed to open directory: %w", ErrStorage, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("%w: failed to sync directory: %w", ErrStorage, err)
+	}
+	return nil
+}
+
+// readSnapshots loads every snapshot file in the storage directory.
+func (s *storage) readSnapshots() (map[uint64]tsonSnapshot, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, snapshotDirName))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read snapshot directory: %w", ErrStorage, err)
+	}
+
+	snapshots := make(map[uint64]tsonSnapshot)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, snapshotFileExt) {
+			continue // Leftover temporary files are ignored
+		}
+		v, err := strconv.ParseUint(strings.TrimSuffix(name, snapshotFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(s.dir, snapshotDirName, name))
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read snapshot file %s: %w", ErrStorage, name, err)
+		}
+		var snapshot tsonSnapshot
+		if err := tson.Unmarshal(b, &snapshot); err != nil {
+			return nil, fmt.Errorf("%w: failed to parse snapshot file %s: %w", ErrStorage, name, err)
+		}
+		snapshots[v] = snapshot
+	}
+	return snapshots, nil
+}
+
+// readWAL reads every complete record of the WAL.
+// A torn or corrupted tail (e.g. after a crash in the middle of a write)
+// is cut off, so that new records are appended after the last valid one.
+func (s *storage) readWAL() ([]walRecord, error) {
+	f, err := os.OpenFile(s.walPath(), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open WAL: %w", ErrStorage, err)
+	}
+	defer f.Close()
+
+	var (
+		records []walRecord
+		offset  int64
+		r       = bufio.NewReader(f)
+		header  = make([]byte, walHeaderSize)
+	)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			break // Torn header
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size > walMaxRecordSize {
+			break
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			break // Torn payload
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			break // Corrupted payload
+		}
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			break
+		}
+		records = append(records, rec)
+		offset += walHeaderSize + int64(size)
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		return nil, fmt.Errorf("%w: failed to truncate the torn tail of WAL: %w", ErrStorage, err)
+	}
+	return records, nil
+}
+
+// recover rebuilds a Logument from the snapshot files and the WAL.
+func (s *storage) recover(snapshots map[uint64]tsonSnapshot) (*Logument, error) {
+	records, err := s.readWAL()
+	if err != nil {
+		return nil, err
+	}
+
+	files := maps.Clone(snapshots) // Snapshots dropped while recovering are removed at the end
+
+	var base uint64
+	if len(records) > 0 && records[0].Kind == walReset {
+		// A truncated log; older snapshot files may be left over from a crash
+		base = records[0].Version
+		for v := range snapshots {
+			if v < base {
+				delete(snapshots, v)
+			}
+		}
+		if _, exists := snapshots[base]; !exists {
+			return nil, fmt.Errorf("%w: missing snapshot file of the base version %d", ErrStorage, base)
+		}
+	} else {
+		versions := make([]uint64, 0, len(snapshots))
+		for v := range snapshots {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+		base = versions[0]
+	}
+
+	baseState := snapshots[base]
+
+	lgm := &Logument{
+		Version:      []uint64{base},
+		CurrentState: baseState,
+		Snapshots:    snapshots,
+		Patches:      make(map[uint64]tsonPatches),
+		PatchPool:    nil,
+		index:        newPathIndex(),
+	}
+	lgm.index.add(base, nil)
+
+	for _, rec := range records {
+		switch rec.Kind {
+		case walReset:
+			if err := tson.Unmarshal([]byte(rec.State), &lgm.CurrentState); err != nil {
+				return nil, fmt.Errorf("%w: failed to parse the state of version %d: %w", ErrStorage, rec.Version, err)
+			}
+			lgm.PatchPool = rec.Patch
+		case walVersion:
+			lgm.Patches[rec.Version] = rec.Patch
+			lgm.Version = append(lgm.Version, rec.Version)
+			lgm.index.add(rec.Version, rec.Patch)
+		case walStore, walSet:
+			if rec.Kind == walSet && rec.Version <= lgm.Version[len(lgm.Version)-1] {
+				if err := lgm.amend(map[uint64]tsonPatches{rec.Version: rec.Patch}); err != nil {
+					return nil, fmt.Errorf("%w: failed to replay the amendment of version %d: %w", ErrStorage, rec.Version, err)
+				}
+				continue
+			}
+			// Both update the CurrentState along with the PatchPool
+			if lgm.CurrentState, err = tsonpatch.ApplyPatch(lgm.CurrentState, rec.Patch); err != nil {
+				return nil, fmt.Errorf("%w: failed to replay %s of version %d: %w", ErrStorage, rec.Kind, rec.Version, err)
+			}
+			lgm.PatchPool = append(lgm.PatchPool, rec.Patch...)
+		case walAppend:
+			if rec.Version <= base { // Already included in the base snapshot
+				lgm.PatchPool = nil
+				continue
+			}
+			lgm.Patches[rec.Version] = lgm.PatchPool
+			lgm.Version = append(lgm.Version, rec.Version)
+			lgm.index.add(rec.Version, lgm.PatchPool)
+			lgm.PatchPool = nil
+		case walMerge:
+			if err := lgm.merge(map[uint64]tsonPatches{rec.Version: rec.Patch}); err != nil {
+				return nil, fmt.Errorf("%w: failed to replay the late patches of version %d: %w", ErrStorage, rec.Version, err)
+			}
+		case walCompact:
+			lgm.compact(rec.Path)
+		default:
+			return nil, fmt.Errorf("%w: unknown WAL record kind %q", ErrStorage, rec.Kind)
+		}
+	}
+
+	// Snapshots newer than the recovered versions come from a lost WAL tail
+	for v := range lgm.Snapshots {
+		if v > lgm.Version[len(lgm.Version)-1] {
+			delete(lgm.Snapshots, v)
+		}
+	}
+	// Remove the files of the dropped snapshots (older than the base, newer than
+	// the latest version, or made stale by an amendment)
+	if err := s.removeSnapshots(files, func(v uint64) bool {
+		_, kept := lgm.Snapshots[v]
+		return !kept
+	}); err != nil {
+		return nil, err
+	}
+	lgm.recountCheckpoint()
+
+	return lgm, nil
+}
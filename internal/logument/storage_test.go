@@ -0,0 +1,111 @@
+package logument_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CAU-CPSS/logument/internal/logument"
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAndRecover(t *testing.T) {
+	dir := t.TempDir()
+
+	lgm, err := logument.Open(dir, initSnapshot, logument.StorageOptions{})
+	assert.Nil(t, err)
+
+	lgm.Store(patches[0])
+	lgm.Store(patches[1])
+	assert.Nil(t, lgm.Append()) // version 1
+	lgm.Store(patches[2])
+	assert.Nil(t, lgm.Append()) // version 2
+	lgm.Snapshot(2)
+	_, err = lgm.Compact("/location")
+	assert.Nil(t, err)
+	lgm.Set(3, tsonpatch.Operation{Op: tsonpatch.OpReplace, Path: "/speed", Value: 80.0, Timestamp: 2500000000})
+	lgm.Store(patches[3]) // Left in the PatchPool
+	assert.Nil(t, lgm.Close())
+
+	recovered, err := logument.Open(dir, nil, logument.StorageOptions{})
+	assert.Nil(t, err)
+	defer recovered.Close()
+
+	assert.Equal(t, lgm.Version, recovered.Version)
+	assert.Equal(t, lgm.Patches, recovered.Patches)
+	assert.Equal(t, lgm.PatchPool, recovered.PatchPool)
+	assert.Equal(t, len(lgm.Snapshots), len(recovered.Snapshots))
+	for v, s := range lgm.Snapshots {
+		eq, err := tson.Equal(s, recovered.Snapshots[v])
+		assert.Nil(t, err)
+		assert.True(t, eq, "snapshot of version %d differs", v)
+	}
+	eq, _ := tson.Equal(lgm.CurrentState, recovered.CurrentState)
+	assert.True(t, eq)
+
+	// The recovered Logument keeps appending where the previous one stopped
+	assert.Nil(t, recovered.Append())
+	assert.Equal(t, []uint64{0, 1, 2, 3}, recovered.Version)
+}
+
+func TestRecoverTornWAL(t *testing.T) {
+	dir := t.TempDir()
+
+	lgm, err := logument.Open(dir, initSnapshot, logument.StorageOptions{})
+	assert.Nil(t, err)
+	lgm.Store(patches[0])
+	assert.Nil(t, lgm.Append())
+	assert.Nil(t, lgm.Close())
+
+	// Simulate a crash in the middle of writing a record
+	f, err := os.OpenFile(filepath.Join(dir, "wal.log"), os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0, 0, 1, 0, 42})
+	assert.Nil(t, err)
+	f.Close()
+
+	recovered, err := logument.Open(dir, nil, logument.StorageOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{0, 1}, recovered.Version)
+
+	// New records are written after the last valid one
+	recovered.Store(patches[1])
+	assert.Nil(t, recovered.Append())
+	assert.Nil(t, recovered.Close())
+
+	recovered, err = logument.Open(dir, nil, logument.StorageOptions{})
+	assert.Nil(t, err)
+	defer recovered.Close()
+	assert.Equal(t, []uint64{0, 1, 2}, recovered.Version)
+}
+
+func TestSnapshotInterval(t *testing.T) {
+	dir := t.TempDir()
+
+	lgm, err := logument.Open(dir, initSnapshot, logument.StorageOptions{SnapshotInterval: 2})
+	assert.Nil(t, err)
+	for _, p := range patches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+	assert.Nil(t, lgm.Close())
+
+	entries, err := os.ReadDir(filepath.Join(dir, "snapshots"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries)) // Versions 0, 2 and 4
+
+	recovered, err := logument.Open(dir, nil, logument.StorageOptions{})
+	assert.Nil(t, err)
+	defer recovered.Close()
+	assert.Contains(t, recovered.Snapshots, uint64(4))
+
+	expected, err := lgm.Snapshot(4)
+	assert.Nil(t, err)
+	actual, err := recovered.Snapshot(4)
+	assert.Nil(t, err)
+	eq, err := tson.Equal(expected, actual)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+}
@@ -0,0 +1,123 @@
+package logument_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/CAU-CPSS/logument/internal/logument"
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+	"github.com/stretchr/testify/assert"
+)
+
+// Leaves of initSnapshot that hold numbers
+var numberPaths = []string{
+	"/speed",
+	"/location/latitude",
+	"/location/longitude",
+	"/tirePressure/0",
+	"/tirePressure/1",
+	"/tirePressure/2",
+	"/tirePressure/3",
+}
+
+// randomLogument builds a Logument from random patches with many equal
+// and out-of-order timestamps, and returns it with the patches of each
+// version (the last one being the PatchPool).
+func randomLogument(t *testing.T, r *rand.Rand) (*logument.Logument, []tsonpatch.Patch) {
+	t.Helper()
+	lgm := newLogument(t, initSnapshot, nil)
+	lgm.SetCheckpointPolicy(logument.CheckpointPolicy{EveryVersions: uint64(r.Intn(4))})
+
+	versions := []tsonpatch.Patch{nil} // No patches for the base snapshot
+	numVersions := 1 + r.Intn(8)
+	for v := 1; v <= numVersions+1; v++ {
+		var patch tsonpatch.Patch
+		for i := r.Intn(5); i >= 0; i-- {
+			patch = append(patch, tsonpatch.Operation{
+				Op:        tsonpatch.OpReplace,
+				Path:      numberPaths[r.Intn(len(numberPaths))],
+				Value:     float64(r.Intn(1000)),
+				Timestamp: 1800000000 + int64(r.Intn(20)),
+			})
+		}
+		assert.Nil(t, lgm.Store(patch))
+		versions = append(versions, patch)
+		if v <= numVersions {
+			assert.Nil(t, lgm.Append())
+		}
+		if r.Intn(3) == 0 { // Take snapshots at random versions
+			_, err := lgm.Snapshot(uint64(r.Intn(v)))
+			assert.Nil(t, err)
+		}
+	}
+	return lgm, versions
+}
+
+// bruteForceValue returns the value of path at tsk by checking every patch:
+// the latest one by (timestamp, version, position), or the initial value.
+func bruteForceValue(t *testing.T, versions []tsonpatch.Patch, path string, tsk int64) tson.Value {
+	t.Helper()
+	var (
+		latest *tsonpatch.Operation
+		found  = false
+	)
+	for _, patch := range versions {
+		for i, p := range patch {
+			// Later versions and positions win ties, so only a greater timestamp is needed to lose
+			if p.Path == path && p.Timestamp <= tsk && (!found || p.Timestamp >= latest.Timestamp) {
+				latest, found = &patch[i], true
+			}
+		}
+	}
+	if !found {
+		var initial tson.Tson
+		assert.Nil(t, tson.Unmarshal([]byte(initSnapshot), &initial))
+		value, err := tson.GetValue(initial, path)
+		assert.Nil(t, err)
+		return value
+	}
+	return tson.Leaf[float64]{Value: latest.Value.(float64), Timestamp: latest.Timestamp}
+}
+
+func TestTemporalSnapshotProperty(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		lgm, versions := randomLogument(t, r)
+
+		for _, tsk := range []int64{1700000000, 1799999999, 1800000000 + int64(r.Intn(20)), 1800000000 + int64(r.Intn(20)), 1900000000} {
+			snapshot, err := lgm.TemporalSnapshot(tsk)
+			assert.Nil(t, err)
+			for _, path := range numberPaths {
+				actual, err := tson.GetValue(snapshot, path)
+				assert.Nil(t, err)
+				assert.Equal(t, bruteForceValue(t, versions, path, tsk), actual, "seed %d: %s at %d", seed, path, tsk)
+
+				// ValueAt agrees, the PatchPool included
+				value, err := lgm.ValueAt(path, tsk)
+				assert.Nil(t, err)
+				assert.Equal(t, actual, value.Value, "seed %d: ValueAt %s at %d", seed, path, tsk)
+			}
+		}
+	}
+}
+
+func TestTemporalSnapshotAcrossVersions(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	for _, p := range patches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+	// A snapshot that is too recent to start from
+	_, err := lgm.Snapshot(3)
+	assert.Nil(t, err)
+
+	// Versions 1 and 2 both have patches before 2000000000
+	snapshot, err := lgm.TemporalSnapshot(2000000000)
+	assert.Nil(t, err)
+	expected, err := lgm.Snapshot(2)
+	assert.Nil(t, err)
+	eq, err := tson.Equal(expected, snapshot)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+}
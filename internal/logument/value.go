@@ -0,0 +1,146 @@
+//
+// value.go
+//
+// Point-in-time lookup of a single path.
+//
+// `ValueAt` and `ValueAtVersion` answer "what was the value of this
+// path at that time (or version)" from the per-path index, without
+// building a whole snapshot of the document.
+//
+
+package logument
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/CAU-CPSS/logument/internal/tsonpatch"
+)
+
+// PointValue is the value of a path at a point in time
+type PointValue struct {
+	Value     tson.Value // The value at the path: a leaf, an object or an array
+	Timestamp int64      // Timestamp of the value (the latest one of its leaves)
+	Version   uint64     // Version of the patch that set (or last moved) the value, the first version if it comes from the base snapshot
+}
+
+// ValueAt returns the value of the target path at the timestamp ts,
+// i.e. the value set by the latest patch with a timestamp of at most ts,
+// the patches still in the PatchPool included (see TemporalSnapshot).
+// A value changed by the PatchPool is reported with the next version.
+func (lgm *Logument) ValueAt(targetPath string, ts int64) (PointValue, error) {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	replay := func() (tsonSnapshot, error) {
+		return lgm.temporalSnapshot(ts, true)
+	}
+	if lgm.pooled(targetPath, ts) {
+		return replayedValue(targetPath, lgm.Version[len(lgm.Version)-1]+1, replay)
+	}
+
+	e, found := lgm.index.at(targetPath, ts)
+	if s, shadowed := lgm.index.structuralAt(targetPath, ts); shadowed && (!found || e.Compare(s) < 0) {
+		e, found = s, true
+	}
+
+	return lgm.pointValue(targetPath, e, found, replay)
+}
+
+// pooled tells whether a patch of the PatchPool with a timestamp of at most ts may change the value at path
+func (lgm *Logument) pooled(path string, ts int64) bool {
+	path = canonicalPath(path)
+	for _, p := range lgm.PatchPool {
+		if p.Timestamp > ts {
+			continue
+		}
+		for _, scope := range (OrderedPatch{Op: p}).scopes() {
+			if scope != "/" {
+				scope = strings.TrimSuffix(scope, "/")
+			}
+			if overlaps(path, scope) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// overlaps tells whether one of the canonical paths a and b is the other or below it
+func overlaps(a, b string) bool {
+	return a == b || a == "/" || b == "/" || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// ValueAtVersion returns the value of the target path at the version vk.
+func (lgm *Logument) ValueAtVersion(targetPath string, vk uint64) (PointValue, error) {
+	lgm.mu.RLock()
+	defer lgm.mu.RUnlock()
+
+	first, latest := lgm.Version[0], lgm.Version[len(lgm.Version)-1]
+	if vk < first || vk > latest {
+		return PointValue{}, fmt.Errorf("%w: version %d is not between %d and %d", ErrVersionOutOfRange, vk, first, latest)
+	}
+
+	e, found := lgm.index.atVersion(targetPath, vk)
+	if s, shadowed := lgm.index.structuralAtVersion(targetPath, vk); shadowed && (!found || e.before(s)) {
+		e, found = s, true
+	}
+
+	return lgm.pointValue(targetPath, e, found, func() (tsonSnapshot, error) {
+		return lgm.snapshot(vk)
+	})
+}
+
+// pointValue resolves the value of path set by the patch e,
+// or by the base snapshot if there is no such patch.
+// If e moved the elements of an array, or moved or copied a value,
+// the value is looked up in the snapshot built by replay instead.
+// If e wrote an object or an array above path, the value is looked up in it.
+// An object or an array is looked up in the snapshot as well
+// if patches were indexed below it, as they may have changed it since.
+func (lgm *Logument) pointValue(path string, e OrderedPatch, found bool, replay func() (tsonSnapshot, error)) (PointValue, error) {
+	var (
+		value   tson.Value
+		version = lgm.Version[0]
+		err     error
+	)
+	switch {
+	case !found:
+		if value, err = tson.GetValue(lgm.Snapshots[version], canonicalPath(path)); err != nil {
+			return PointValue{}, fmt.Errorf("%w: %s: %w", ErrPathNotFound, path, err)
+		}
+	case e.shifts() || e.Op.Op == tsonpatch.OpMove || e.Op.Op == tsonpatch.OpCopy:
+		return replayedValue(path, e.Version, replay)
+	case e.Op.Op == tsonpatch.OpRemove: // Removed, either by itself or along with one of its parents
+		return PointValue{}, fmt.Errorf("%w: %s was removed at version %d", ErrPathNotFound, path, e.Version)
+	default:
+		version = e.Version
+		if value, err = tsonpatch.ToValue(e.Op.Value, e.Op.Timestamp); err != nil {
+			return PointValue{}, fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+		}
+		if target := canonicalPath(e.Op.Path); target != canonicalPath(path) {
+			if value, err = tson.GetValue(value, strings.TrimPrefix(canonicalPath(path), strings.TrimSuffix(target, "/"))); err != nil {
+				return PointValue{}, fmt.Errorf("%w: %s: %w", ErrPathNotFound, path, err)
+			}
+		}
+	}
+
+	if isComposite(value) && lgm.index.below[canonicalPath(path)] > 0 {
+		return replayedValue(path, version, replay)
+	}
+	return PointValue{Value: value, Timestamp: tson.GetLatestTimestamp(value), Version: version}, nil
+}
+
+// replayedValue looks up the value of path in the snapshot built by replay
+func replayedValue(path string, version uint64, replay func() (tsonSnapshot, error)) (PointValue, error) {
+	snapshot, err := replay()
+	if err != nil {
+		return PointValue{}, err
+	}
+	value, err := tson.GetValue(snapshot, canonicalPath(path))
+	if err != nil {
+		return PointValue{}, fmt.Errorf("%w: %s: %w", ErrPathNotFound, path, err)
+	}
+	return PointValue{Value: value, Timestamp: tson.GetLatestTimestamp(value), Version: version}, nil
+}
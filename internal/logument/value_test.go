@@ -0,0 +1,183 @@
+package logument_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/CAU-CPSS/logument/internal/logument"
+	"github.com/CAU-CPSS/logument/internal/tson"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueAt(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	for _, p := range patches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+
+	tests := []struct {
+		path     string
+		ts       int64
+		expected logument.PointValue
+	}{
+		// From the base snapshot
+		{"/speed", 2000000000, logument.PointValue{Value: tson.Leaf[float64]{Value: 72.5, Timestamp: 1700000000}, Timestamp: 1700000000, Version: 0}},
+		{"/location/latitude", 1799999999, logument.PointValue{Value: tson.Leaf[float64]{Value: 37.7749, Timestamp: 1700000000}, Timestamp: 1700000000, Version: 0}},
+		// From the patches
+		{"/location/latitude", 1800000000, logument.PointValue{Value: tson.Leaf[float64]{Value: 43.9409, Timestamp: 1800000000}, Timestamp: 1800000000, Version: 1}},
+		{"location.latitude", 2200000000, logument.PointValue{Value: tson.Leaf[float64]{Value: 43.9409, Timestamp: 2100000000}, Timestamp: 2100000000, Version: 3}},
+		{"/engineOn", 2000000000, logument.PointValue{Value: tson.Leaf[bool]{Value: false, Timestamp: 2000000000}, Timestamp: 2000000000, Version: 2}},
+		{"/tirePressure/2", 3000000000, logument.PointValue{Value: tson.Leaf[float64]{Value: 33.7, Timestamp: 2300000000}, Timestamp: 2300000000, Version: 4}},
+	}
+	for _, tt := range tests {
+		actual, err := lgm.ValueAt(tt.path, tt.ts)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.expected, actual, "%s at %d", tt.path, tt.ts)
+	}
+
+	_, err := lgm.ValueAt("/unknown", 3000000000)
+	assert.ErrorIs(t, err, logument.ErrPathNotFound)
+}
+
+func TestValueAtVersion(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	for _, p := range patches {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+
+	// Same as looking up the snapshot of each version
+	paths := []string{"/vehicleId", "/speed", "/engineOn", "/location/latitude", "/location/longitude", "/tirePressure/0", "/tirePressure/2"}
+	for v := uint64(0); v <= 4; v++ {
+		snapshot, err := lgm.Snapshot(v)
+		assert.Nil(t, err)
+		for _, path := range paths {
+			expected, err := tson.GetValue(snapshot, path)
+			assert.Nil(t, err)
+			actual, err := lgm.ValueAtVersion(path, v)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, actual.Value, "%s at version %d", path, v)
+		}
+	}
+
+	actual, err := lgm.ValueAtVersion("/engineOn", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), actual.Version)
+
+	_, err = lgm.ValueAtVersion("/speed", 5)
+	assert.ErrorIs(t, err, logument.ErrVersionOutOfRange)
+}
+
+func TestValueAtRemoved(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	lgm.Store(`[
+		{ "op": "remove", "path": "/location/latitude", "timestamp": 1800000000 },
+		{ "op": "remove", "path": "/location", "timestamp": 1900000000 },
+		{ "op": "add", "path": "/location/longitude", "value": 1.0, "timestamp": 2000000000 }
+	]`)
+	assert.Nil(t, lgm.Append())
+
+	_, err := lgm.ValueAt("/location/latitude", 1800000000)
+	assert.ErrorIs(t, err, logument.ErrPathNotFound)
+	longitude, err := lgm.ValueAt("/location/longitude", 1800000000)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), longitude.Version)
+
+	// Removed along with its parent, then added again
+	_, err = lgm.ValueAt("/location/longitude", 1900000000)
+	assert.ErrorIs(t, err, logument.ErrPathNotFound)
+	longitude, err = lgm.ValueAt("/location/longitude", 2000000000)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[float64]{Value: 1.0, Timestamp: 2000000000}, longitude.Value)
+
+	_, err = lgm.ValueAtVersion("/location/latitude", 1)
+	assert.ErrorIs(t, err, logument.ErrPathNotFound)
+}
+
+func TestValueAtComposite(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	lgm.Store(`[
+		{ "op": "replace", "path": "/location", "value": { "latitude": 1.0 }, "timestamp": 1800000000 }
+	]`)
+	assert.Nil(t, lgm.Append())
+	lgm.Store(`[
+		{ "op": "move", "from": "/speed", "path": "/location/speed", "timestamp": 1900000000 }
+	]`)
+	assert.Nil(t, lgm.Append())
+
+	// Set along with its parent
+	latitude, err := lgm.ValueAt("/location/latitude", math.MaxInt64)
+	assert.Nil(t, err)
+	assert.Equal(t, logument.PointValue{Value: tson.Leaf[float64]{Value: 1.0, Timestamp: 1800000000}, Timestamp: 1800000000, Version: 1}, latitude)
+	_, err = lgm.ValueAt("/location/longitude", math.MaxInt64)
+	assert.ErrorIs(t, err, logument.ErrPathNotFound)
+	longitude, err := lgm.ValueAt("/location/longitude", 1799999999)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), longitude.Version)
+
+	// Moved
+	_, err = lgm.ValueAt("/speed", math.MaxInt64)
+	assert.ErrorIs(t, err, logument.ErrPathNotFound)
+	speed, err := lgm.ValueAt("/location/speed", math.MaxInt64)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[float64]{Value: 72.5, Timestamp: 1900000000}, speed.Value)
+
+	// Same as looking up the snapshot of each version
+	paths := []string{"/speed", "/location/latitude", "/location/longitude", "/location/speed"}
+	for v := uint64(0); v <= 2; v++ {
+		snapshot, err := lgm.Snapshot(v)
+		assert.Nil(t, err)
+		for _, path := range paths {
+			expected, err := tson.GetValue(snapshot, path)
+			actual, actualErr := lgm.ValueAtVersion(path, v)
+			if err != nil {
+				assert.ErrorIs(t, actualErr, logument.ErrPathNotFound, "%s at version %d", path, v)
+				continue
+			}
+			assert.Nil(t, actualErr)
+			assert.Equal(t, expected, actual.Value, "%s at version %d", path, v)
+		}
+	}
+}
+
+func TestValueAtLikeTemporalSnapshot(t *testing.T) {
+	lgm := newLogument(t, initSnapshot, nil)
+	for _, p := range patches[:2] {
+		lgm.Store(p)
+		assert.Nil(t, lgm.Append())
+	}
+	// Pooled, below an object of the base snapshot, and below an object written by a patch
+	lgm.Store(`[
+		{ "op": "replace", "path": "/speed", "value": 60.0, "timestamp": 1850000000 },
+		{ "op": "add", "path": "/cabin", "value": { "temperature": 21.0 }, "timestamp": 1900000000 }
+	]`)
+	assert.Nil(t, lgm.Append())
+	lgm.Store(`[
+		{ "op": "replace", "path": "/cabin/temperature", "value": 23.5, "timestamp": 2100000000 },
+		{ "op": "replace", "path": "/location/longitude", "value": 1.0, "timestamp": 2200000000 }
+	]`)
+
+	paths := []string{"/speed", "/location", "/location/latitude", "/location/longitude", "/cabin", "/cabin/temperature", "/"}
+	for _, ts := range []int64{1700000000, 1850000000, 1900000000, 2100000000, 2200000000, math.MaxInt64} {
+		snapshot, err := lgm.TemporalSnapshot(ts)
+		assert.Nil(t, err)
+		for _, path := range paths {
+			expected, err := tson.GetValue(snapshot, path)
+			actual, actualErr := lgm.ValueAt(path, ts)
+			if err != nil {
+				assert.ErrorIs(t, actualErr, logument.ErrPathNotFound, "%s at %d", path, ts)
+				continue
+			}
+			assert.Nil(t, actualErr)
+			eq, err := tson.Equal(expected, actual.Value)
+			assert.Nil(t, err)
+			assert.True(t, eq, "%s at %d", path, ts)
+		}
+	}
+
+	// Changed by the PatchPool, which becomes the next version
+	value, err := lgm.ValueAt("/location/longitude", math.MaxInt64)
+	assert.Nil(t, err)
+	assert.Equal(t, logument.PointValue{Value: tson.Leaf[float64]{Value: 1.0, Timestamp: 2200000000}, Timestamp: 2200000000, Version: 4}, value)
+}
@@ -0,0 +1,291 @@
+//
+// binary.go
+//
+// A compact binary encoding of TSON,
+// for bandwidth-constrained uplinks.
+//
+// A document starts with the format version (a byte),
+// followed by its root value. Each value starts with a tag (a byte):
+//
+//	object:  uvarint count, then count × (uvarint length, key bytes, value)
+//	array:   uvarint count, then count × value
+//	leaf:    varint timestamp delta, then the primitive
+//	         (string: uvarint length and bytes; number: 8 bytes,
+//	          little-endian IEEE 754; int64: varint; uint64: uvarint;
+//	          boolean and null: none, held by the tag)
+//
+// Timestamps are delta-encoded against the previous leaf in
+// encoding order (starting from 0), as the leaves of a snapshot
+// usually share similar timestamps. Object keys are sorted,
+// so the encoding of a document is deterministic.
+//
+
+package tson
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// BinaryVersion is the version of the binary encoding.
+const BinaryVersion byte = 1
+
+// Tags of the binary encoding
+const (
+	binNull byte = iota
+	binObject
+	binArray
+	binString
+	binNumber
+	binFalse
+	binTrue
+	binInt64
+	binUint64
+	binNullLeaf // A null leaf (binNull is a null without a timestamp)
+)
+
+// MarshalBinary serializes a Tson data into the binary encoding.
+func MarshalBinary(t Tson) ([]byte, error) {
+	e := binaryEncoder{}
+	buf := []byte{BinaryVersion}
+	return e.appendValue(buf, t)
+}
+
+// UnmarshalBinary parses the binary encoding of a Tson data.
+func UnmarshalBinary(data []byte, t *Tson) error {
+	if len(data) == 0 {
+		return fmt.Errorf("UnmarshalBinary: empty data")
+	}
+	if data[0] != BinaryVersion {
+		return fmt.Errorf("UnmarshalBinary: unsupported version %d", data[0])
+	}
+
+	d := binaryDecoder{}
+	r := bytes.NewReader(data[1:])
+	v, err := d.readValue(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("UnmarshalBinary: %d trailing bytes", r.Len())
+	}
+	*t = v
+	return nil
+}
+
+// binaryEncoder writes TSON values in the binary encoding (without the version).
+// It keeps the last timestamp written, to delta-encode the next one,
+// so the encoder and its decoder must process the same values in the same order.
+type binaryEncoder struct {
+	last int64 // The last timestamp written
+}
+
+// appendValue appends the binary encoding of v to buf, and returns the extended buffer.
+func (e *binaryEncoder) appendValue(buf []byte, v Value) ([]byte, error) {
+	switch val := v.(type) {
+	case nil:
+		return append(buf, binNull), nil
+	case Object:
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf = append(buf, binObject)
+		buf = binary.AppendUvarint(buf, uint64(len(keys)))
+		for _, key := range keys {
+			buf = appendBinaryString(buf, key)
+			var err error
+			if buf, err = e.appendValue(buf, val[key]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case Array:
+		buf = append(buf, binArray)
+		buf = binary.AppendUvarint(buf, uint64(len(val)))
+		for _, elem := range val {
+			var err error
+			if buf, err = e.appendValue(buf, elem); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case Leaf[string]:
+		buf = e.appendTimestamp(append(buf, binString), val.Timestamp)
+		return appendBinaryString(buf, val.Value), nil
+	case Leaf[float64]:
+		buf = e.appendTimestamp(append(buf, binNumber), val.Timestamp)
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(val.Value)), nil
+	case Leaf[int64]:
+		buf = e.appendTimestamp(append(buf, binInt64), val.Timestamp)
+		return binary.AppendVarint(buf, val.Value), nil
+	case Leaf[uint64]:
+		buf = e.appendTimestamp(append(buf, binUint64), val.Timestamp)
+		return binary.AppendUvarint(buf, val.Value), nil
+	case Leaf[bool]:
+		tag := binFalse
+		if val.Value {
+			tag = binTrue
+		}
+		return e.appendTimestamp(append(buf, tag), val.Timestamp), nil
+	case Leaf[Null]:
+		return e.appendTimestamp(append(buf, binNullLeaf), val.Timestamp), nil
+	default:
+		return nil, fmt.Errorf("MarshalBinary: invalid type %T for TSON", v)
+	}
+}
+
+// appendTimestamp appends ts, delta-encoded, to buf.
+func (e *binaryEncoder) appendTimestamp(buf []byte, ts int64) []byte {
+	buf = binary.AppendVarint(buf, ts-e.last)
+	e.last = ts
+	return buf
+}
+
+// binaryDecoder reads TSON values written by a binaryEncoder.
+type binaryDecoder struct {
+	last int64 // The last timestamp read
+}
+
+// readValue reads a value from r.
+func (d *binaryDecoder) readValue(r *bytes.Reader) (Value, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalBinary: unexpected end of data")
+	}
+
+	switch tag {
+	case binNull:
+		return nil, nil
+	case binObject:
+		n, err := readBinaryLength(r)
+		if err != nil {
+			return nil, err
+		}
+		obj := make(Object, n)
+		for range n {
+			key, err := readBinaryString(r)
+			if err != nil {
+				return nil, err
+			}
+			if obj[key], err = d.readValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	case binArray:
+		n, err := readBinaryLength(r)
+		if err != nil {
+			return nil, err
+		}
+		arr := make(Array, n)
+		for i := range arr {
+			if arr[i], err = d.readValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	case binString:
+		ts, err := d.readTimestamp(r)
+		if err != nil {
+			return nil, err
+		}
+		s, err := readBinaryString(r)
+		if err != nil {
+			return nil, err
+		}
+		return Leaf[string]{Value: s, Timestamp: ts}, nil
+	case binNumber:
+		ts, err := d.readTimestamp(r)
+		if err != nil {
+			return nil, err
+		}
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, fmt.Errorf("UnmarshalBinary: unexpected end of data")
+		}
+		return Leaf[float64]{Value: math.Float64frombits(binary.LittleEndian.Uint64(b[:])), Timestamp: ts}, nil
+	case binFalse, binTrue:
+		ts, err := d.readTimestamp(r)
+		if err != nil {
+			return nil, err
+		}
+		return Leaf[bool]{Value: tag == binTrue, Timestamp: ts}, nil
+	case binInt64:
+		ts, err := d.readTimestamp(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("UnmarshalBinary: invalid integer: %w", err)
+		}
+		return Leaf[int64]{Value: v, Timestamp: ts}, nil
+	case binUint64:
+		ts, err := d.readTimestamp(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("UnmarshalBinary: invalid integer: %w", err)
+		}
+		return Leaf[uint64]{Value: v, Timestamp: ts}, nil
+	case binNullLeaf:
+		ts, err := d.readTimestamp(r)
+		if err != nil {
+			return nil, err
+		}
+		return Leaf[Null]{Timestamp: ts}, nil
+	default:
+		return nil, fmt.Errorf("UnmarshalBinary: invalid tag %d", tag)
+	}
+}
+
+// readTimestamp reads a delta-encoded timestamp from r.
+func (d *binaryDecoder) readTimestamp(r *bytes.Reader) (int64, error) {
+	delta, err := binary.ReadVarint(r)
+	if err != nil {
+		return 0, fmt.Errorf("UnmarshalBinary: invalid timestamp: %w", err)
+	}
+	d.last += delta
+	return d.last, nil
+}
+
+// appendBinaryString appends s, prefixed by its length, to buf.
+func appendBinaryString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// readBinaryString reads a string written by appendBinaryString from r.
+func readBinaryString(r *bytes.Reader) (string, error) {
+	n, err := readBinaryLength(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", fmt.Errorf("UnmarshalBinary: unexpected end of data")
+	}
+	return string(b), nil
+}
+
+// readBinaryLength reads a length (e.g. of a string or an array) from r,
+// making sure that much data can follow.
+func readBinaryLength(r *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, fmt.Errorf("UnmarshalBinary: invalid length: %w", err)
+	}
+	if n > uint64(r.Len()) { // Every element takes a byte at least
+		return 0, fmt.Errorf("UnmarshalBinary: length %d exceeds the data", n)
+	}
+	return int(n), nil
+}
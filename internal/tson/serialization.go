@@ -10,10 +10,15 @@
 package tson
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 // ToCompatibleTsonString converts TSON to a string.
@@ -35,138 +40,131 @@ const (
 
 // Marshal serializes a Tson data into <>-formatted bytes.
 func Marshal(t Tson) ([]byte, error) {
-	s, err := marshalTson(t, top)
-	if err != nil {
+	var buf bytes.Buffer
+	if err := marshalTson(&buf, t, top); err != nil {
 		return nil, err
 	}
-	return []byte(s), nil
+	return buf.Bytes(), nil
+}
+
+// writer is where the marshalled TSON is written (e.g. bytes.Buffer, bufio.Writer).
+type writer interface {
+	io.Writer
+	io.StringWriter
 }
 
-// marshalTson marshals a Tson value given its context:
+// marshalTson writes a Tson value to w given its context:
 // top: 	top-level value,
 // object: 	value inside an object (its timestamp is printed with the key),
 // array: 	element inside an array (timestamp is printed before the primitive).
-func marshalTson(v Value, ctx int) (string, error) {
+func marshalTson(w writer, v Value, ctx int) error {
 	switch val := v.(type) {
+	case nil:
+		_, err := w.WriteString("null")
+		return err
 	case Object:
-		return marshalObject(val)
+		return marshalObject(w, val)
 	case Array:
-		return marshalArray(val)
-	case Leaf[string]:
-		if ctx == array {
-			// In array, prefix timestamp before primitive.
-			if val.Timestamp >= 0 { // Timestamp is positive (exists)
-				return fmt.Sprintf("<%d> %q", val.Timestamp, val.Value), nil
-			}
-			return fmt.Sprintf("<> %q", val.Value), nil // No timestamp
-		} else if ctx == object {
-			// In object, the timestamp is printed with the key.
-			return fmt.Sprintf("%q", val.Value), nil
-		} else { // top-level
-			if val.Timestamp >= 0 {
-				return fmt.Sprintf("%q <%d>", val.Value, val.Timestamp), nil
-			}
-			return fmt.Sprintf("%q <>", val.Value), nil
-		}
-	case Leaf[float64]:
-		if ctx == array {
-			if val.Timestamp >= 0 {
-				return fmt.Sprintf("<%d> %v", val.Timestamp, val.Value), nil
-			}
-			return fmt.Sprintf("<> %v", val.Value), nil
-		} else if ctx == object {
-			return fmt.Sprintf("%v", val.Value), nil
-		} else {
-			if val.Timestamp >= 0 {
-				return fmt.Sprintf("%v <%d>", val.Value, val.Timestamp), nil
-			}
-			return fmt.Sprintf("%v <>", val.Value), nil
+		return marshalArray(w, val)
+	}
+	prim, ts, ok := formatLeaf(v)
+	if !ok {
+		return fmt.Errorf("marshalTson: unknown type")
+	}
+
+	switch ctx {
+	case array: // In array, prefix timestamp before primitive.
+		if ts != DefaultTimestamp { // Timestamp exists
+			_, err := fmt.Fprintf(w, "<%d> %s", ts, prim)
+			return err
 		}
-	case Leaf[bool]:
-		if ctx == array {
-			if val.Timestamp >= 0 {
-				return fmt.Sprintf("<%d> %v", val.Timestamp, val.Value), nil
-			}
-			return fmt.Sprintf("<> %v", val.Value), nil
-		} else if ctx == object {
-			return fmt.Sprintf("%v", val.Value), nil
-		} else {
-			if val.Timestamp >= 0 {
-				return fmt.Sprintf("%v <%d>", val.Value, val.Timestamp), nil
-			}
-			return fmt.Sprintf("%v <>", val.Value), nil
+		_, err := fmt.Fprintf(w, "<> %s", prim) // No timestamp
+		return err
+	case object: // In object, the timestamp is printed with the key.
+		_, err := w.WriteString(prim)
+		return err
+	default: // top-level
+		if ts != DefaultTimestamp {
+			_, err := fmt.Fprintf(w, "%s <%d>", prim, ts)
+			return err
 		}
-	default:
-		return "", fmt.Errorf("marshalTson: unknown type")
+		_, err := fmt.Fprintf(w, "%s <>", prim)
+		return err
 	}
 }
 
-// marshalObject marshals an Object into a TSON object string.
-func marshalObject(obj Object) (string, error) {
-	var (
-		s     strings.Builder
-		first = true
-	)
-	s.WriteString("{")
+// marshalObject writes an Object as a TSON object to w.
+func marshalObject(w writer, obj Object) error {
+	first := true
+	w.WriteString("{")
 	for key, value := range obj {
 		if !first {
-			s.WriteString(", ")
+			w.WriteString(", ")
 		} else {
 			first = false
 		}
-		s.WriteString(fmt.Sprintf("%q", key))
-		switch leaf := value.(type) {
-		case Leaf[string]:
-			if leaf.Timestamp >= 0 {
-				s.WriteString(fmt.Sprintf(" <%d>", leaf.Timestamp))
-			} else {
-				s.WriteString(" <>")
-			}
-		case Leaf[float64]:
-			if leaf.Timestamp >= 0 {
-				s.WriteString(fmt.Sprintf(" <%d>", leaf.Timestamp))
-			} else {
-				s.WriteString(" <>")
-			}
-		case Leaf[bool]:
-			if leaf.Timestamp >= 0 {
-				s.WriteString(fmt.Sprintf(" <%d>", leaf.Timestamp))
+		w.WriteString(quoteString(key))
+		if _, ts, ok := LeafOf(value); ok {
+			if ts != DefaultTimestamp {
+				fmt.Fprintf(w, " <%d>", ts)
 			} else {
-				s.WriteString(" <>")
+				w.WriteString(" <>")
 			}
 		}
-		s.WriteString(": ")
-		marshaledValue, err := marshalTson(value, object)
-		if err != nil {
-			return "", err
+		w.WriteString(": ")
+		if err := marshalTson(w, value, object); err != nil {
+			return err
 		}
-		s.WriteString(marshaledValue)
 	}
-	s.WriteString("}")
-	return s.String(), nil
+	_, err := w.WriteString("}")
+	return err
 }
 
-// marshalArray marshals an Array into a TSON array string.
-func marshalArray(arr Array) (string, error) {
-	var (
-		s     strings.Builder
-		first = true
-	)
-	s.WriteString("[")
+// marshalArray writes an Array as a TSON array to w.
+func marshalArray(w writer, arr Array) error {
+	first := true
+	w.WriteString("[")
 	for _, elem := range arr {
 		if !first {
-			s.WriteString(", ")
+			w.WriteString(", ")
 		} else {
 			first = false
 		}
-		marshaledElem, err := marshalTson(elem, array)
-		if err != nil {
-			return "", err
+		if err := marshalTson(w, elem, array); err != nil {
+			return err
 		}
-		s.WriteString(marshaledElem)
 	}
-	s.WriteString("]")
-	return s.String(), nil
+	_, err := w.WriteString("]")
+	return err
+}
+
+// formatLeaf returns the primitive of a leaf as TSON, and its timestamp.
+// It reports whether v is a leaf.
+func formatLeaf(v Value) (prim string, ts int64, ok bool) {
+	value, ts, ok := LeafOf(v)
+	if !ok {
+		return "", DefaultTimestamp, false
+	}
+	switch val := value.(type) {
+	case nil:
+		return "null", ts, true
+	case string:
+		return quoteString(val), ts, true
+	case float64:
+		return formatFloat(val), ts, true
+	default: // Integers and booleans
+		return fmt.Sprintf("%v", val), ts, true
+	}
+}
+
+// formatFloat formats f like %v, but keeps a '.' (or an exponent)
+// in integral values (e.g. 32.0), so they are parsed back as floats.
+func formatFloat(f float64) string {
+	s := fmt.Sprintf("%v", f)
+	if !strings.ContainsAny(s, ".eEIN") { // Not NaN or ±Inf either
+		s += ".0"
+	}
+	return s
 }
 
 // MarshalIndent serializes a JSON-like or Tson document into a TSON-formatted byte slice,
@@ -190,9 +188,9 @@ func marshalIndentValue(v any, currentIndent, indent string, ctx int) (string, e
 	case bool:
 		return fmt.Sprintf("%v", t), nil
 	case float64:
-		return fmt.Sprintf("%.f", t), nil
+		return formatFloat(t), nil
 	case string:
-		return strconv.Quote(t), nil
+		return quoteString(t), nil
 
 	case map[string]any:
 		if isLeaf, leafVal, ts := checkLeaf(t); isLeaf {
@@ -204,17 +202,17 @@ func marshalIndentValue(v any, currentIndent, indent string, ctx int) (string, e
 			case object:
 				return primStr, nil
 			case array:
-				if ts >= 0 {
+				if ts != DefaultTimestamp {
 					return fmt.Sprintf("<%d> %s", ts, primStr), nil
 				}
 				return fmt.Sprintf("<> %s", primStr), nil
 			case top:
-				if ts >= 0 {
+				if ts != DefaultTimestamp {
 					return fmt.Sprintf("%s <%d>", primStr, ts), nil
 				}
 				return fmt.Sprintf("%s <>", primStr), nil
 			default:
-				if ts >= 0 {
+				if ts != DefaultTimestamp {
 					return fmt.Sprintf("%s <%d>", primStr, ts), nil
 				}
 				return fmt.Sprintf("%s <>", primStr), nil
@@ -228,7 +226,7 @@ func marshalIndentValue(v any, currentIndent, indent string, ctx int) (string, e
 				s.WriteString(",\n")
 			}
 			first = false
-			keyStr := strconv.Quote(key)
+			keyStr := quoteString(key)
 			if m, ok := val.(map[string]any); ok {
 				if isLeaf, leafVal, ts := checkLeaf(m); isLeaf {
 					primStr, err := formatPrimitive(leafVal)
@@ -236,7 +234,7 @@ func marshalIndentValue(v any, currentIndent, indent string, ctx int) (string, e
 						return "", err
 					}
 					s.WriteString(currentIndent + indent + keyStr + " <")
-					if ts >= 0 {
+					if ts != DefaultTimestamp {
 						s.WriteString(strconv.FormatInt(ts, 10))
 					}
 					s.WriteString(">: " + primStr)
@@ -268,7 +266,7 @@ func marshalIndentValue(v any, currentIndent, indent string, ctx int) (string, e
 						return "", err
 					}
 					s.WriteString(currentIndent + indent)
-					if ts >= 0 {
+					if ts != DefaultTimestamp {
 						s.WriteString(fmt.Sprintf("<%d> %s", ts, primStr))
 					} else {
 						s.WriteString("<> " + primStr)
@@ -294,34 +292,21 @@ func marshalIndentValue(v any, currentIndent, indent string, ctx int) (string, e
 				s.WriteString(",\n")
 			}
 			first = false
-			keyStr := strconv.Quote(key)
+			keyStr := quoteString(key)
 			// Tson의 값이 Leaf라면, key 뒤에 timestamp를 붙여 출력
-			switch leaf := val.(type) {
-			case Leaf[string]:
-				s.WriteString(currentIndent + indent + keyStr + " <")
-				if leaf.Timestamp >= 0 {
-					s.WriteString(strconv.FormatInt(leaf.Timestamp, 10))
-				}
-				s.WriteString(">: " + strconv.Quote(leaf.Value))
-			case Leaf[float64]:
-				s.WriteString(currentIndent + indent + keyStr + " <")
-				if leaf.Timestamp >= 0 {
-					s.WriteString(strconv.FormatInt(leaf.Timestamp, 10))
-				}
-				s.WriteString(">: " + fmt.Sprintf("%v", leaf.Value))
-			case Leaf[bool]:
+			if primStr, ts, ok := formatLeaf(val); ok {
 				s.WriteString(currentIndent + indent + keyStr + " <")
-				if leaf.Timestamp >= 0 {
-					s.WriteString(strconv.FormatInt(leaf.Timestamp, 10))
+				if ts != DefaultTimestamp {
+					s.WriteString(strconv.FormatInt(ts, 10))
 				}
-				s.WriteString(">: " + fmt.Sprintf("%v", leaf.Value))
-			default:
-				valStr, err := marshalIndentValue(val, currentIndent+indent, indent, object)
-				if err != nil {
-					return "", err
-				}
-				s.WriteString(currentIndent + indent + keyStr + ": " + valStr)
+				s.WriteString(">: " + primStr)
+				continue
+			}
+			valStr, err := marshalIndentValue(val, currentIndent+indent, indent, object)
+			if err != nil {
+				return "", err
 			}
+			s.WriteString(currentIndent + indent + keyStr + ": " + valStr)
 		}
 		s.WriteString("\n" + currentIndent + "}")
 		return s.String(), nil
@@ -335,77 +320,25 @@ func marshalIndentValue(v any, currentIndent, indent string, ctx int) (string, e
 				s.WriteString(",\n")
 			}
 			first = false
-			switch leaf := elem.(type) {
-			case Leaf[string]:
-				s.WriteString(currentIndent + indent)
-				if leaf.Timestamp >= 0 {
-					s.WriteString(fmt.Sprintf("<%d> %s", leaf.Timestamp, strconv.Quote(leaf.Value)))
-				} else {
-					s.WriteString(fmt.Sprintf("<> %s", strconv.Quote(leaf.Value)))
-				}
-			case Leaf[float64]:
-				s.WriteString(currentIndent + indent)
-				if leaf.Timestamp >= 0 {
-					s.WriteString(fmt.Sprintf("<%d> %v", leaf.Timestamp, leaf.Value))
-				} else {
-					s.WriteString(fmt.Sprintf("<> %v", leaf.Value))
-				}
-			case Leaf[bool]:
-				s.WriteString(currentIndent + indent)
-				if leaf.Timestamp >= 0 {
-					s.WriteString(fmt.Sprintf("<%d> %v", leaf.Timestamp, leaf.Value))
-				} else {
-					s.WriteString(fmt.Sprintf("<> %v", leaf.Value))
-				}
-			default:
-				elemStr, err := marshalIndentValue(elem, currentIndent+indent, indent, array)
-				if err != nil {
-					return "", err
-				}
-				s.WriteString(currentIndent + indent + elemStr)
+			elemStr, err := marshalIndentValue(elem, currentIndent+indent, indent, array)
+			if err != nil {
+				return "", err
 			}
+			s.WriteString(currentIndent + indent + elemStr)
 		}
 		s.WriteString("\n" + currentIndent + "]")
 		return s.String(), nil
 
-	case Leaf[string]:
-		primStr := strconv.Quote(t.Value)
+	case Leaf[string], Leaf[float64], Leaf[int64], Leaf[uint64], Leaf[bool], Leaf[Null]:
+		primStr, ts, _ := formatLeaf(v.(Value))
 		if ctx == array {
-			if t.Timestamp >= 0 {
-				return fmt.Sprintf("<%d> %s", t.Timestamp, primStr), nil
+			if ts != DefaultTimestamp {
+				return fmt.Sprintf("<%d> %s", ts, primStr), nil
 			}
 			return fmt.Sprintf("<> %s", primStr), nil
 		} else if ctx == top {
-			if t.Timestamp >= 0 {
-				return fmt.Sprintf("%s <%d>", primStr, t.Timestamp), nil
-			}
-			return fmt.Sprintf("%s <>", primStr), nil
-		}
-		return primStr, nil
-	case Leaf[float64]:
-		primStr := fmt.Sprintf("%v", t.Value)
-		if ctx == array {
-			if t.Timestamp >= 0 {
-				return fmt.Sprintf("<%d> %s", t.Timestamp, primStr), nil
-			}
-			return fmt.Sprintf("<> %s", primStr), nil
-		} else if ctx == top {
-			if t.Timestamp >= 0 {
-				return fmt.Sprintf("%s <%d>", primStr, t.Timestamp), nil
-			}
-			return fmt.Sprintf("%s <>", primStr), nil
-		}
-		return primStr, nil
-	case Leaf[bool]:
-		primStr := fmt.Sprintf("%v", t.Value)
-		if ctx == array {
-			if t.Timestamp >= 0 {
-				return fmt.Sprintf("<%d> %s", t.Timestamp, primStr), nil
-			}
-			return fmt.Sprintf("<> %s", primStr), nil
-		} else if ctx == top {
-			if t.Timestamp >= 0 {
-				return fmt.Sprintf("%s <%d>", primStr, t.Timestamp), nil
+			if ts != DefaultTimestamp {
+				return fmt.Sprintf("%s <%d>", primStr, ts), nil
 			}
 			return fmt.Sprintf("%s <>", primStr), nil
 		}
@@ -416,12 +349,16 @@ func marshalIndentValue(v any, currentIndent, indent string, ctx int) (string, e
 	}
 }
 
-// formatPrimitive converts a primitive value (string, float64, bool) into its string representation.
+// formatPrimitive converts a primitive value (string, number, bool, null) into its string representation.
 func formatPrimitive(v any) (string, error) {
 	switch t := v.(type) {
+	case nil:
+		return "null", nil
 	case string:
-		return strconv.Quote(t), nil
-	case float64, int, int64, uint, uint64:
+		return quoteString(t), nil
+	case float64:
+		return formatFloat(t), nil
+	case int, int64, uint, uint64, json.Number:
 		return fmt.Sprintf("%v", t), nil
 	case bool:
 		return fmt.Sprintf("%v", t), nil
@@ -430,53 +367,123 @@ func formatPrimitive(v any) (string, error) {
 	}
 }
 
+// quoteString quotes s as a JSON string.
+// Unlike strconv.Quote, it never produces Go-only escapes (e.g. \x00 or \a),
+// so the result is valid TSON (and JSON). Invalid UTF-8 becomes U+FFFD.
+func quoteString(s string) string {
+	const hex = "0123456789abcdef"
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if r < 0x20 {
+				b.WriteString(`\u00`)
+				b.WriteByte(hex[r>>4])
+				b.WriteByte(hex[r&0xf])
+			} else {
+				b.WriteRune(r) // utf8.RuneError for invalid UTF-8
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
 // <<<<<<<<< Unmarshalling
 
 // Parser struct maintains the parsing state.
 type Parser struct {
 	input []byte
 	pos   int
+	r     io.Reader // If not nil, input is read from r as needed (see Decoder)
+	rerr  error     // The error r returned (e.g. io.EOF), which ends the input
+
+	// Locating syntax errors (see syntax.go)
+	offset     int64    // The offset of input[0] in the whole input (the Decoder discards parsed input)
+	line       int      // The number of lines before input[0]
+	lineOffset int64    // The offset of the start of the line of input[0]
+	path       []string // The path of the value being parsed
+	maxErrors  int      // The number of syntax errors to collect before giving up (see UnmarshalCollect)
+	errs       SyntaxErrors
+}
+
+// ensure reports whether n bytes of input are left from pos,
+// reading more from r (if any) when needed.
+func (p *Parser) ensure(n int) bool {
+	for p.pos+n > len(p.input) {
+		if p.r == nil || p.rerr != nil {
+			return false
+		}
+		if len(p.input) == cap(p.input) {
+			p.input = append(p.input, make([]byte, max(512, len(p.input)))...)[:len(p.input)]
+		}
+		read, err := p.r.Read(p.input[len(p.input):cap(p.input)])
+		p.input = p.input[:len(p.input)+read]
+		p.rerr = err
+	}
+	return true
+}
+
+// more reports whether any input is left from pos.
+func (p *Parser) more() bool {
+	return p.ensure(1)
 }
 
 // Unmarshal parses the TSON bytes with '<>' timestamp
 // and stores the result in the value pointed to by t.
-func Unmarshal(data []byte, t *Tson) (err error) {
+// Only whitespace may follow the value.
+func Unmarshal(data []byte, t *Tson) error {
 	p := &Parser{input: data, pos: 0}
-	*t, err = p.parseTson()
-	return err
+	v, err := p.parseTson()
+	if err != nil {
+		return err
+	}
+	if err := p.expectEnd(); err != nil {
+		return err
+	}
+	*t = v
+	return nil
 }
 
-// wrapIfPrimitive wraps a raw primitive with a timestamp.
+// expectEnd makes sure only whitespace is left in the input.
+func (p *Parser) expectEnd() error {
+	p.skipWhitespace()
+	if p.more() {
+		return p.syntaxError("end of input")
+	}
+	return nil
+}
+
+// wrapIfPrimitive wraps a raw primitive with a timestamp,
+// or sets the timestamp of a leaf (unless it is DefaultTimestamp).
 func wrapIfPrimitive(v any, timestamp int64) Value {
-	switch val := v.(type) {
-	case string:
-		return Leaf[string]{Value: val, Timestamp: timestamp}
-	case float64:
-		return Leaf[float64]{Value: val, Timestamp: timestamp}
-	case bool:
-		return Leaf[bool]{Value: val, Timestamp: timestamp}
-	case Leaf[string]:
-		if timestamp >= 0 { // TODO: Remove duplicate code
-			val.Timestamp = timestamp
-		}
-		return val
-	case Leaf[float64]:
-		if timestamp >= 0 {
-			val.Timestamp = timestamp
-		}
-		return val
-	case Leaf[bool]:
-		if timestamp >= 0 {
-			val.Timestamp = timestamp
-		}
-		return val
-	default:
-		return v.(Value)
+	if val, ok := v.(Value); ok {
+		value, _, isLeaf := LeafOf(val)
+		if !isLeaf || timestamp == DefaultTimestamp {
+			return val // Object, Array, or a leaf keeping its timestamp
+		}
+		v = value
 	}
+	leaf, _ := NewLeaf(v, timestamp)
+	return leaf
 }
 
 func (p *Parser) skipWhitespace() {
-	for p.pos < len(p.input) &&
+	for p.more() &&
 		(p.input[p.pos] == ' ' ||
 			p.input[p.pos] == '\t' ||
 			p.input[p.pos] == '\n' ||
@@ -486,7 +493,7 @@ func (p *Parser) skipWhitespace() {
 }
 
 func (p *Parser) peek() byte {
-	if p.pos < len(p.input) {
+	if p.more() {
 		return p.input[p.pos]
 	}
 	return 0
@@ -501,7 +508,7 @@ func (p *Parser) next() byte {
 func (p *Parser) expect(ch byte) error {
 	p.skipWhitespace()
 	if p.peek() != ch {
-		return fmt.Errorf("expected '%c', got '%c' at pos %d", ch, p.peek(), p.pos)
+		return p.syntaxError(fmt.Sprintf("'%c'", ch))
 	}
 	p.pos++
 	return nil
@@ -542,7 +549,7 @@ func (p *Parser) parseVal() (Value, error) {
 			return nil, err
 		}
 		p.skipWhitespace()
-		if p.pos < len(p.input) && p.peek() == '<' {
+		if p.more() && p.peek() == '<' {
 			ts, err := p.parseTimestamp()
 			if err != nil {
 				return nil, err
@@ -555,7 +562,7 @@ func (p *Parser) parseVal() (Value, error) {
 		if p.startsWith("true") {
 			p.pos += 4
 			p.skipWhitespace()
-			if p.pos < len(p.input) && p.peek() == '<' {
+			if p.more() && p.peek() == '<' {
 				ts, err := p.parseTimestamp()
 				if err != nil {
 					return nil, err
@@ -566,7 +573,7 @@ func (p *Parser) parseVal() (Value, error) {
 		} else if p.startsWith("false") {
 			p.pos += 5
 			p.skipWhitespace()
-			if p.pos < len(p.input) && p.peek() == '<' {
+			if p.more() && p.peek() == '<' {
 				ts, err := p.parseTimestamp()
 				if err != nil {
 					return nil, err
@@ -576,16 +583,24 @@ func (p *Parser) parseVal() (Value, error) {
 			return wrapIfPrimitive(false, DefaultTimestamp), nil
 		} else if p.startsWith("null") {
 			p.pos += 4
-			return nil, nil
+			p.skipWhitespace()
+			if p.more() && p.peek() == '<' {
+				ts, err := p.parseTimestamp()
+				if err != nil {
+					return nil, err
+				}
+				return wrapIfPrimitive(Null{}, ts), nil
+			}
+			return wrapIfPrimitive(Null{}, DefaultTimestamp), nil
 		}
 	}
 
-	return nil, fmt.Errorf("unexpected character '%c' at pos %d", p.peek(), p.pos)
+	return nil, p.syntaxError("a value")
 }
 
 func (p *Parser) startsWith(s string) bool {
 	bytes := []byte(s)
-	if p.pos+len(bytes) > len(p.input) {
+	if !p.ensure(len(bytes)) {
 		return false
 	}
 	for i, r := range bytes {
@@ -605,89 +620,176 @@ func (p *Parser) parsePrimitive() (any, error) {
 	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 		return p.parseNumber()
 	default:
-		return nil, fmt.Errorf("invalid primitive starting with '%c' at pos %d", p.peek(), p.pos)
+		return nil, p.syntaxError("a string or a number")
 	}
 }
 
 // parseString parses a JSON string.
+// Besides the JSON escapes, it accepts \' for compatibility.
 func (p *Parser) parseString() (string, error) {
 	if err := p.expect('"'); err != nil {
 		return "", err
 	}
 	var result []byte
-	for p.pos < len(p.input) {
-		ch := p.next()
-		if ch == '\\' { // escape
-			if p.pos >= len(p.input) {
-				return "", fmt.Errorf("unterminated escape sequence")
-			}
-			esc := p.next()
-			switch esc {
-			case '"', '\\', '/', '\'':
-				result = append(result, esc)
-			case 'b':
-				result = append(result, '\b')
-			case 'f':
-				result = append(result, '\f')
-			case 'n':
-				result = append(result, '\n')
-			case 'r':
-				result = append(result, '\r')
-			case 't':
-				result = append(result, '\t')
-			default:
-				return "", fmt.Errorf("invalid escape character: %c", esc)
-			}
-		} else if ch == '"' {
+	for p.more() {
+		ch := p.peek()
+		switch {
+		case ch == '"':
+			p.pos++
 			return string(result), nil
-		} else {
+		case ch < 0x20:
+			return "", p.syntaxError("an escaped control character")
+		case ch != '\\':
+			p.pos++
 			result = append(result, ch)
+			continue
 		}
+
+		p.pos++ // escape
+		esc := p.peek()
+		switch esc {
+		case '"', '\\', '/', '\'':
+			result = append(result, esc)
+		case 'b':
+			result = append(result, '\b')
+		case 'f':
+			result = append(result, '\f')
+		case 'n':
+			result = append(result, '\n')
+		case 'r':
+			result = append(result, '\r')
+		case 't':
+			result = append(result, '\t')
+		case 'u':
+			r, err := p.parseUnicode()
+			if err != nil {
+				return "", err
+			}
+			result = utf8.AppendRune(result, r)
+			continue
+		default:
+			return "", p.syntaxError("an escape character")
+		}
+		p.pos++
+	}
+	return "", p.syntaxError("'\"'")
+}
+
+// parseUnicode parses a \uXXXX escape (from the 'u'), combining a UTF-16
+// surrogate pair into one rune. A lone surrogate becomes U+FFFD,
+// as in encoding/json.
+func (p *Parser) parseUnicode() (rune, error) {
+	r, err := p.parseHex4()
+	if err != nil {
+		return 0, err
 	}
-	return "", fmt.Errorf("unterminated string")
+	if !utf16.IsSurrogate(r) {
+		return r, nil
+	}
+	if start := p.pos; p.startsWith(`\u`) {
+		p.pos++
+		r2, err := p.parseHex4()
+		if err != nil {
+			return 0, err
+		}
+		if dec := utf16.DecodeRune(r, r2); dec != unicode.ReplacementChar {
+			return dec, nil
+		}
+		p.pos = start // Not a pair: the next escape is parsed on its own
+	}
+	return unicode.ReplacementChar, nil
 }
 
-// parseNumber parses a number.
-func (p *Parser) parseNumber() (float64, error) {
+// parseHex4 parses the 4 hexadecimal digits of a \uXXXX escape (from the 'u').
+func (p *Parser) parseHex4() (rune, error) {
+	p.pos++
+	var r rune
+	for range 4 {
+		var digit byte
+		switch c := p.peek(); {
+		case '0' <= c && c <= '9':
+			digit = c - '0'
+		case 'a' <= c && c <= 'f':
+			digit = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			digit = c - 'A' + 10
+		default:
+			return 0, p.syntaxError("a hexadecimal digit")
+		}
+		r = r<<4 | rune(digit)
+		p.pos++
+	}
+	return r, nil
+}
+
+// parseNumber parses a JSON number: -?(0|[1-9][0-9]*)(.[0-9]+)?([eE][+-]?[0-9]+)?
+// An integer is an int64 (or a uint64, above the range of int64), and other numbers are float64.
+func (p *Parser) parseNumber() (any, error) {
 	start := p.pos
 	if p.peek() == '-' {
 		p.pos++
 	}
-	for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+	if p.peek() == '0' { // No leading zeros
 		p.pos++
+	} else if !p.skipDigits() {
+		return 0, p.syntaxError("digits")
+	}
+	if p.peek() == '.' {
+		p.pos++
+		if !p.skipDigits() {
+			return 0, p.syntaxError("digits after '.'")
+		}
 	}
-	if p.pos < len(p.input) && p.peek() == '.' {
+	if c := p.peek(); c == 'e' || c == 'E' {
 		p.pos++
-		for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+		if c := p.peek(); c == '+' || c == '-' {
 			p.pos++
 		}
+		if !p.skipDigits() {
+			return 0, p.syntaxError("digits of an exponent")
+		}
 	}
-	numStr := string(p.input[start:p.pos])
-	return strconv.ParseFloat(numStr, 64)
+	n, err := NumberOf(json.Number(p.input[start:p.pos]))
+	if err != nil {
+		p.pos = start
+		return 0, p.syntaxError("a number in the range of float64")
+	}
+	return n, nil
+}
+
+// skipDigits skips decimal digits, and reports whether there was any.
+func (p *Parser) skipDigits() bool {
+	start := p.pos
+	for p.more() && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+		p.pos++
+	}
+	return p.pos > start
 }
 
 // parseTimestamp parses a timestamp in the form <number>.
-// If timestamp is ommitted, it returns negative value(-1).
+// If timestamp is ommitted, it returns DefaultTimestamp.
+// Timestamps may be negative (e.g. <-1>, the same as <>).
 func (p *Parser) parseTimestamp() (int64, error) {
 	if err := p.expect('<'); err != nil { // expect '<'
 		return 0, err
 	}
 	if p.peek() == '>' { // timestamp is ommitted
 		p.pos++
-		return -1, nil
+		return DefaultTimestamp, nil
 	}
 
 	start := p.pos
-	for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+	if p.peek() == '-' {
 		p.pos++
 	}
-	if start == p.pos {
-		return 0, fmt.Errorf("expected digits for timestamp at pos %d", p.pos)
+	if !p.skipDigits() {
+		return 0, p.syntaxError("digits of a timestamp or '>'")
 	}
 	numStr := string(p.input[start:p.pos])
 	ts, err := strconv.ParseInt(numStr, 10, 64)
 	if err != nil {
-		return 0, err
+		p.pos = start
+		return 0, p.syntaxError("a timestamp in the range of int64")
 	}
 	if err := p.expect('>'); err != nil {
 		return 0, err
@@ -696,6 +798,7 @@ func (p *Parser) parseTimestamp() (int64, error) {
 }
 
 // parseObject parses an object: { <members>? }.
+// When collecting errors, a member in error is skipped.
 func (p *Parser) parseObject() (Value, error) {
 	if err := p.expect('{'); err != nil {
 		return nil, err
@@ -707,46 +810,59 @@ func (p *Parser) parseObject() (Value, error) {
 		return obj, nil
 	}
 	for {
-		p.skipWhitespace()
-		// key must be a string
-		key, err := p.parseString()
-		if err != nil {
-			return nil, err
-		}
-		p.skipWhitespace()
-		// optional timestamp after key
-		var ts int64 = 0
-		if p.peek() == '<' {
-			ts, err = p.parseTimestamp()
-			if err != nil {
-				return nil, err
-			}
-			p.skipWhitespace()
-		}
-		if err := p.expect(':'); err != nil {
+		if err := p.parseMember(obj); err != nil && !p.recover(err) {
 			return nil, err
 		}
-		p.skipWhitespace()
-		val, err := p.parseVal()
-		if err != nil {
+		if err := p.expectNext('}'); err != nil {
 			return nil, err
 		}
-		obj[key] = wrapIfPrimitive(val, ts)
-		p.skipWhitespace()
-		if p.peek() == ',' {
-			p.pos++
-			continue
-		} else if p.peek() == '}' {
-			p.pos++
+		if p.next() != ',' {
 			break
-		} else {
-			return nil, fmt.Errorf("expected ',' or '}', got '%c' at pos %d", p.peek(), p.pos)
 		}
 	}
 	return obj, nil
 }
 
+// parseMember parses a member of an object: <string> <timestamp>? ":" <value>,
+// and sets it in obj.
+func (p *Parser) parseMember(obj Object) error {
+	p.skipWhitespace()
+	// key must be a string
+	if p.peek() != '"' {
+		return p.syntaxError("a string key")
+	}
+	key, err := p.parseString()
+	if err != nil {
+		return err
+	}
+
+	p.path = append(p.path, key)
+	defer func() { p.path = p.path[:len(p.path)-1] }()
+
+	p.skipWhitespace()
+	// optional timestamp after key
+	var ts int64 = 0
+	if p.peek() == '<' {
+		ts, err = p.parseTimestamp()
+		if err != nil {
+			return err
+		}
+		p.skipWhitespace()
+	}
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	p.skipWhitespace()
+	val, err := p.parseVal()
+	if err != nil {
+		return err
+	}
+	obj[key] = wrapIfPrimitive(val, ts)
+	return nil
+}
+
 // parseArray parses an array: [ <elements>? ].
+// When collecting errors, an element in error is skipped.
 func (p *Parser) parseArray() (Value, error) {
 	if err := p.expect('['); err != nil {
 		return nil, err
@@ -757,32 +873,53 @@ func (p *Parser) parseArray() (Value, error) {
 		p.pos++
 		return arr, nil
 	}
-	for {
-		p.skipWhitespace()
-		var ts int64 = 0
-		if p.peek() == '<' {
-			var err error
-			ts, err = p.parseTimestamp()
-			if err != nil {
-				return nil, err
-			}
-			p.skipWhitespace()
+	for index := 0; ; index++ {
+		p.path = append(p.path, strconv.Itoa(index))
+		val, err := p.parseElement()
+		p.path = p.path[:len(p.path)-1]
+		if err == nil {
+			arr = append(arr, val)
+		} else if !p.recover(err) {
+			return nil, err
 		}
-		val, err := p.parseVal()
-		if err != nil {
+		if err := p.expectNext(']'); err != nil {
 			return nil, err
 		}
-		arr = append(arr, wrapIfPrimitive(val, ts))
-		p.skipWhitespace()
-		if p.peek() == ',' {
-			p.pos++
-			continue
-		} else if p.peek() == ']' {
-			p.pos++
+		if p.next() != ',' {
 			break
-		} else {
-			return nil, fmt.Errorf("expected ',' or ']', got '%c' at pos %d", p.peek(), p.pos)
 		}
 	}
 	return arr, nil
 }
+
+// expectNext makes sure the next member or element is either ',' or closer
+// (or, when collecting errors, skips the input to a ',' or a closing delimiter).
+func (p *Parser) expectNext(closer byte) error {
+	p.skipWhitespace()
+	if c := p.peek(); c == ',' || c == closer {
+		return nil
+	}
+	if err := p.syntaxError(fmt.Sprintf("',' or '%c'", closer)); !p.recover(err) {
+		return err
+	}
+	return nil
+}
+
+// parseElement parses an element of an array: <timestamp>? <value>.
+func (p *Parser) parseElement() (Value, error) {
+	p.skipWhitespace()
+	var ts int64 = 0
+	if p.peek() == '<' {
+		var err error
+		ts, err = p.parseTimestamp()
+		if err != nil {
+			return nil, err
+		}
+		p.skipWhitespace()
+	}
+	val, err := p.parseVal()
+	if err != nil {
+		return nil, err
+	}
+	return wrapIfPrimitive(val, ts), nil
+}
@@ -0,0 +1,209 @@
+//
+// stream.go
+//
+// Streaming TSON over io.Reader and io.Writer,
+// following the pattern of encoding/json.
+//
+// A Decoder reads a sequence of TSON documents (e.g. snapshots
+// separated by newlines) from a stream, and can also read a large
+// document token by token, decoding its elements one at a time.
+// An Encoder writes TSON documents to a stream, one per line.
+//
+
+package tson
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Token holds a token of TSON, which is one of
+//
+//	Delim, for the four delimiters [ ] { }
+//	Timestamp, for a timestamp (<> gives DefaultTimestamp)
+//	string, for strings
+//	int64, uint64 or float64, for numbers (as in Unmarshal)
+//	bool, for booleans
+//	nil, for null
+type Token any
+
+// Delim is a TSON delimiter: [ ] { or }.
+type Delim rune
+
+// String returns the delimiter as a string.
+func (d Delim) String() string {
+	return string(d)
+}
+
+// Timestamp is a TSON timestamp token, e.g. <1700000000>.
+type Timestamp int64
+
+// Decoder reads TSON values from an input stream.
+type Decoder struct {
+	p     Parser
+	depth int // The number of arrays and objects opened by Token
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+// The Decoder buffers its input, and may read beyond the values it decodes.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{p: Parser{r: r}}
+}
+
+// Decode reads the next TSON value from the input,
+// and stores it in the value pointed to by t.
+// At the end of the input, it returns io.EOF.
+func (dec *Decoder) Decode(t *Tson) error {
+	if err := dec.skipSeparator(); err != nil {
+		return err
+	}
+
+	v, err := dec.p.parseTson()
+	if err != nil {
+		return dec.readError(err)
+	}
+	*t = v
+	dec.discard()
+	return nil
+}
+
+// More reports whether there is another element in the current
+// array or object (opened by Token), or another value in the input.
+func (dec *Decoder) More() bool {
+	dec.p.skipWhitespace()
+	c := dec.p.peek()
+	return dec.p.more() && c != ']' && c != '}'
+}
+
+// Token returns the next TSON token in the input, skipping the separators
+// (commas and colons). At the end of the input, it returns io.EOF.
+// Token does not check that the tokens are in an order TSON allows.
+//
+// The timestamp of a leaf is a separate Timestamp token, i.e. before the
+// colon of an object member, or before the primitive of an array element.
+func (dec *Decoder) Token() (Token, error) {
+	if err := dec.skipSeparator(); err != nil {
+		return nil, err
+	}
+
+	p := &dec.p
+	var (
+		token Token
+		err   error
+	)
+	switch c := p.peek(); c {
+	case '{', '[':
+		p.pos++
+		dec.depth++
+		token = Delim(c)
+	case '}', ']':
+		p.pos++
+		dec.depth--
+		token = Delim(c)
+	case '<':
+		var ts int64
+		ts, err = p.parseTimestamp()
+		token = Timestamp(ts)
+	case '"':
+		token, err = p.parseString()
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		token, err = p.parseNumber()
+	default:
+		switch {
+		case p.startsWith("true"):
+			p.pos += 4
+			token = true
+		case p.startsWith("false"):
+			p.pos += 5
+			token = false
+		case p.startsWith("null"):
+			p.pos += 4
+			token = nil
+		default:
+			err = p.syntaxError("a token")
+		}
+	}
+	if err != nil {
+		return nil, dec.readError(err)
+	}
+	dec.discard()
+	return token, nil
+}
+
+// skipSeparator skips the whitespace and the separators before the next value or token.
+func (dec *Decoder) skipSeparator() error {
+	p := &dec.p
+	p.skipWhitespace()
+	for p.peek() == ',' || p.peek() == ':' {
+		p.pos++
+		p.skipWhitespace()
+	}
+	if !p.more() {
+		if p.rerr == nil || p.rerr == io.EOF {
+			return io.EOF
+		}
+		return p.rerr
+	}
+	return nil
+}
+
+// readError returns the error to report for the parsing error err,
+// which may have been caused by the input ending.
+func (dec *Decoder) readError(err error) error {
+	switch p := &dec.p; {
+	case p.rerr == nil || p.more():
+		return err
+	case errors.Is(p.rerr, io.EOF):
+		return io.ErrUnexpectedEOF
+	default:
+		return p.rerr
+	}
+}
+
+// discard drops the input parsed so far, keeping track of its lines.
+func (dec *Decoder) discard() {
+	p := &dec.p
+	parsed := p.input[:p.pos]
+	if i := bytes.LastIndexByte(parsed, '\n'); i >= 0 {
+		p.line += bytes.Count(parsed, []byte("\n"))
+		p.lineOffset = p.offset + int64(i) + 1
+	}
+	p.offset += int64(p.pos)
+	p.input = p.input[:copy(p.input, p.input[p.pos:])]
+	p.pos = 0
+}
+
+// Encoder writes TSON values to an output stream.
+type Encoder struct {
+	w              io.Writer
+	prefix, indent string
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// SetIndent makes the Encoder indent the values it writes,
+// as MarshalIndent(v, prefix, indent) would.
+func (enc *Encoder) SetIndent(prefix, indent string) {
+	enc.prefix, enc.indent = prefix, indent
+}
+
+// Encode writes the TSON of t to the stream, followed by a newline.
+func (enc *Encoder) Encode(t Tson) error {
+	w := bufio.NewWriter(enc.w)
+	if enc.prefix != "" || enc.indent != "" {
+		b, err := MarshalIndent(t, enc.prefix, enc.indent)
+		if err != nil {
+			return err
+		}
+		w.Write(b)
+	} else if err := marshalTson(w, t, top); err != nil {
+		return err
+	}
+	w.WriteString("\n")
+	return w.Flush()
+}
@@ -0,0 +1,186 @@
+//
+// syntax.go
+//
+// Syntax errors of the TSON parser.
+//
+// A SyntaxError locates the error by line and column, shows the
+// offending line, and tells what the parser expected, and in which
+// value (by its path). `UnmarshalCollect` goes on parsing after
+// an error, to report several errors at once.
+//
+
+package tson
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// SyntaxError describes a syntax error in TSON.
+type SyntaxError struct {
+	Offset   int64  // The byte offset of the error in the input
+	Line     int    // The line of the error (from 1)
+	Column   int    // The column of the error (from 1, in bytes)
+	Excerpt  string // The line of the error (without the newline)
+	Expected string // What the parser expected, e.g. "',' or '}'"
+	Found    string // What the parser found, e.g. "'x'" or "end of input"
+	Path     string // The path of the value being parsed, e.g. "/location/latitude"
+}
+
+// Error returns the message of the error, followed by the
+// offending line, with a caret under the column of the error.
+func (e *SyntaxError) Error() string {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "syntax error at line %d, column %d: expected %s, found %s", e.Line, e.Column, e.Expected, e.Found)
+	if e.Path != "" {
+		fmt.Fprintf(&msg, " (in %s)", e.Path)
+	}
+	if e.Excerpt != "" {
+		// Keep the tabs of the excerpt, so the caret lines up
+		caret := []byte(e.Excerpt[:min(e.Column-1, len(e.Excerpt))])
+		for i, c := range caret {
+			if c != '\t' {
+				caret[i] = ' '
+			}
+		}
+		fmt.Fprintf(&msg, "\n\t%s\n\t%s^", e.Excerpt, caret)
+	}
+	return msg.String()
+}
+
+// SyntaxErrors lists the syntax errors found by `UnmarshalCollect`, in input order.
+type SyntaxErrors []*SyntaxError
+
+// Error returns the messages of the errors, one per line.
+func (e SyntaxErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the errors, for errors.Is and errors.As.
+func (e SyntaxErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// UnmarshalCollect parses the TSON bytes like Unmarshal, but goes on after
+// a syntax error, skipping the object member or array element in error,
+// until it found max errors. If any, it returns them as SyntaxErrors,
+// and the value pointed to by t holds the rest of the document.
+func UnmarshalCollect(data []byte, t *Tson, max int) error {
+	p := &Parser{input: data, maxErrors: max}
+	v, err := p.parseTson()
+	if err != nil {
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			return err
+		}
+		p.collect(se)
+	} else if err := p.expectEnd(); err != nil {
+		p.collect(err.(*SyntaxError))
+	}
+	*t = v
+	if len(p.errs) > 0 {
+		return p.errs
+	}
+	return nil
+}
+
+// syntaxError returns a SyntaxError at the current position.
+func (p *Parser) syntaxError(expected string) *SyntaxError {
+	found := "end of input"
+	if p.more() {
+		r, _ := utf8.DecodeRune(p.input[p.pos:])
+		found = fmt.Sprintf("%q", r)
+	}
+
+	var (
+		before = p.input[:p.pos]
+		start  = bytes.LastIndexByte(before, '\n') + 1 // The start of the line in input
+		end    = bytes.IndexByte(p.input[p.pos:], '\n')
+		column = int64(p.pos - start)
+	)
+	if end < 0 {
+		end = len(p.input)
+	} else {
+		end += p.pos
+	}
+	if start == 0 { // The line may have started in the input discarded by the Decoder
+		column = p.offset + int64(p.pos) - p.lineOffset
+	}
+
+	return &SyntaxError{
+		Offset:   p.offset + int64(p.pos),
+		Line:     p.line + bytes.Count(before, []byte("\n")) + 1,
+		Column:   int(column) + 1,
+		Excerpt:  strings.TrimRight(string(p.input[start:end]), "\r"),
+		Expected: expected,
+		Found:    found,
+		Path:     p.currentPath(),
+	}
+}
+
+// currentPath returns the path of the value being parsed.
+func (p *Parser) currentPath() string {
+	var path strings.Builder
+	for _, part := range p.path {
+		path.WriteString("/" + rfc6901Encoder.Replace(part))
+	}
+	return path.String()
+}
+
+// rfc6901Encoder escapes a key as a part of a path
+var rfc6901Encoder = strings.NewReplacer("~", "~0", "/", "~1")
+
+// collect records the syntax error err (once).
+func (p *Parser) collect(err *SyntaxError) {
+	if len(p.errs) == 0 || p.errs[len(p.errs)-1] != err {
+		p.errs = append(p.errs, err)
+	}
+}
+
+// recover records the error err, if the parser collects errors, and skips
+// the input to the next ',' or closing delimiter of the enclosing object
+// or array. It reports whether parsing can go on.
+func (p *Parser) recover(err error) bool {
+	se, ok := err.(*SyntaxError)
+	if !ok || p.maxErrors <= 1 {
+		return false
+	}
+	p.collect(se)
+	if len(p.errs) >= p.maxErrors {
+		return false
+	}
+
+	depth := 0
+	for ; p.more(); p.pos++ {
+		switch p.peek() {
+		case '"': // Skip the string
+			for p.pos++; p.more() && p.peek() != '"'; p.pos++ {
+				if p.peek() == '\\' {
+					p.pos++
+				}
+			}
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth == 0 {
+				return true
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				return true
+			}
+		}
+	}
+	return false // The input ended
+}
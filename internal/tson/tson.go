@@ -13,6 +13,7 @@
 package tson
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -38,16 +39,48 @@ type Array []Value
 func (Array) isValue() {} // Marks Array as a Value
 
 // LeafValue is a type that can be used as a value of TSON leaf.
-type LeafValue interface{ ~string | ~float64 | ~bool }
+// Integers are kept exact as int64 (or uint64, above the range of int64).
+type LeafValue interface {
+	~string | ~float64 | ~int64 | ~uint64 | ~bool | Null
+}
 
 // Leaf[T] stores the value and timestamp of a TSON leaf.
 type Leaf[T LeafValue] struct {
-	Value     T     `json:"value"`     // number, string, boolean
+	Value     T     `json:"value"`     // number, string, boolean, null
 	Timestamp int64 `json:"timestamp"` // Unix timestamp
 }
 
 func (Leaf[T]) isValue() {} // Marks Leaf as a Value
 
+// MarshalJSON marshals the leaf as { "value": ..., "timestamp": ... }.
+// A float64 value keeps a '.' (e.g. 32.0), so it is not read back as an integer.
+func (l Leaf[T]) MarshalJSON() ([]byte, error) {
+	value, err := json.Marshal(l.Value)
+	if err != nil {
+		return nil, err
+	}
+	if _, isFloat := any(l.Value).(float64); isFloat && !bytes.ContainsAny(value, ".eE") {
+		value = append(value, ".0"...)
+	}
+	return fmt.Appendf(nil, `{"value":%s,"timestamp":%d}`, value, l.Timestamp), nil
+}
+
+// Null is the value of a null leaf, i.e. a timestamped null.
+type Null struct{}
+
+// MarshalJSON marshals Null as JSON null.
+func (Null) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
+
+// UnmarshalJSON accepts JSON null only.
+func (*Null) UnmarshalJSON(b []byte) error {
+	if string(b) != "null" {
+		return fmt.Errorf("Null: cannot unmarshal %s", b)
+	}
+	return nil
+}
+
 // DefaultTimestamp is the default timestamp value.
 const DefaultTimestamp int64 = -1
 
@@ -55,10 +88,84 @@ const DefaultTimestamp int64 = -1
 ///////// OPERATIONS
 //////////////////////////////////
 
+// NewLeaf creates a leaf of the primitive value with the timestamp.
+// The value is a string, a number (float64, int, int64, uint64 or json.Number),
+// a boolean, or nil (or Null) for a null leaf.
+func NewLeaf(value any, timestamp int64) (Value, error) {
+	switch v := value.(type) {
+	case string:
+		return Leaf[string]{Value: v, Timestamp: timestamp}, nil
+	case float64:
+		return Leaf[float64]{Value: v, Timestamp: timestamp}, nil
+	case int:
+		return Leaf[int64]{Value: int64(v), Timestamp: timestamp}, nil
+	case int64:
+		return Leaf[int64]{Value: v, Timestamp: timestamp}, nil
+	case uint64:
+		return Leaf[uint64]{Value: v, Timestamp: timestamp}, nil
+	case json.Number:
+		n, err := NumberOf(v)
+		if err != nil {
+			return nil, err
+		}
+		return NewLeaf(n, timestamp)
+	case bool:
+		return Leaf[bool]{Value: v, Timestamp: timestamp}, nil
+	case nil, Null:
+		return Leaf[Null]{Timestamp: timestamp}, nil
+	default:
+		return nil, fmt.Errorf("NewLeaf: unsupported value type %T: %v", value, value)
+	}
+}
+
+// LeafOf returns the primitive value and the timestamp of v,
+// and whether v is a leaf. The value of a null leaf is nil.
+func LeafOf(v Value) (value any, timestamp int64, ok bool) {
+	switch leaf := v.(type) {
+	case Leaf[string]:
+		return leaf.Value, leaf.Timestamp, true
+	case Leaf[float64]:
+		return leaf.Value, leaf.Timestamp, true
+	case Leaf[int64]:
+		return leaf.Value, leaf.Timestamp, true
+	case Leaf[uint64]:
+		return leaf.Value, leaf.Timestamp, true
+	case Leaf[bool]:
+		return leaf.Value, leaf.Timestamp, true
+	case Leaf[Null]:
+		return nil, leaf.Timestamp, true
+	default:
+		return nil, DefaultTimestamp, false
+	}
+}
+
+// NumberOf converts a JSON number to the value of a TSON leaf:
+// an int64 (or a uint64, above the range of int64) for an integer,
+// or a float64 for a number with a fraction or an exponent (or out of range).
+func NumberOf(n json.Number) (any, error) {
+	s := string(n)
+	if !strings.ContainsAny(s, ".eE") {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return u, nil
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("NumberOf: invalid number %s", s)
+	}
+	return f, nil
+}
+
 // GetValue returns the value of the given key from the TSON object.
 func GetValue(t Tson, path string) (v Value, err error) {
 	var getValue func(t Tson, parts []string) (Value, error)
 	getValue = func(t Tson, parts []string) (Value, error) {
+		if len(parts) == 0 { // The root path refers to the whole document
+			return t, nil
+		}
 		if len(parts) == 1 { // If Object, return the value of the key
 			if obj, ok := t.(Object); ok {
 				if value, ok := obj[parts[0]]; ok {
@@ -116,10 +223,10 @@ func Equal(t1, t2 Tson) (ret bool, err error) {
 	)
 
 	// Check if the given TSONs are valid
-	if err = json.Unmarshal(b1, &o1); err != nil {
+	if err = unmarshalJson(b1, &o1); err != nil {
 		return false, err
 	}
-	if err = json.Unmarshal(b2, &o2); err != nil {
+	if err = unmarshalJson(b2, &o2); err != nil {
 		return false, err
 	}
 	return reflect.DeepEqual(o1, o2), nil
@@ -134,16 +241,49 @@ func EqualWithoutTimestamp(t1, t2 Tson) (ret bool, err error) {
 	)
 
 	// Check if the given TSONs (and converted JSONs) are valid
-	if err = json.Unmarshal(j1, &o1); err != nil {
+	if err = unmarshalJson(j1, &o1); err != nil {
 		return false, err
 	}
-	if err = json.Unmarshal(j2, &o2); err != nil {
+	if err = unmarshalJson(j2, &o2); err != nil {
 		return false, err
 	}
 
 	return reflect.DeepEqual(o1, o2), nil
 }
 
+// Clone returns a deep copy of the given TSON.
+func Clone(t Tson) (Tson, error) {
+	switch v := t.(type) {
+	case nil:
+		return nil, nil
+	case Object:
+		obj := make(Object, len(v))
+		for key, value := range v {
+			c, err := Clone(value)
+			if err != nil {
+				return nil, err
+			}
+			obj[key] = c
+		}
+		return obj, nil
+	case Array:
+		arr := make(Array, len(v))
+		for i, value := range v {
+			c, err := Clone(value)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = c
+		}
+		return arr, nil
+	default:
+		if _, _, ok := LeafOf(t); ok {
+			return t, nil // Leaves are values, not references
+		}
+		return nil, fmt.Errorf("Clone: invalid type %T for TSON", t)
+	}
+}
+
 // GetLatestTimestamp returns the latest timestamp of the given TSON.
 func GetLatestTimestamp(t Tson) int64 {
 	updateMax := func(max *int64, ts int64) {
@@ -152,13 +292,10 @@ func GetLatestTimestamp(t Tson) int64 {
 		}
 	}
 
+	if _, ts, ok := LeafOf(t); ok {
+		return ts
+	}
 	switch v := t.(type) {
-	case Leaf[string]:
-		return v.Timestamp
-	case Leaf[float64]:
-		return v.Timestamp
-	case Leaf[bool]:
-		return v.Timestamp
 	case Object, Array:
 		max := int64(0)
 		if obj, ok := v.(Object); ok { // v is Object
@@ -176,6 +313,29 @@ func GetLatestTimestamp(t Tson) int64 {
 	}
 }
 
+// WithTimestamp returns a copy of the given TSON, with the timestamps of all its leaves set to ts.
+func WithTimestamp(t Tson, ts int64) Tson {
+	if value, _, ok := LeafOf(t); ok {
+		leaf, _ := NewLeaf(value, ts)
+		return leaf
+	}
+	switch v := t.(type) {
+	case Object:
+		obj := make(Object, len(v))
+		for key, value := range v {
+			obj[key] = WithTimestamp(value, ts)
+		}
+		return obj
+	case Array:
+		arr := make(Array, len(v))
+		for i, value := range v {
+			arr[i] = WithTimestamp(value, ts)
+		}
+		return arr
+	}
+	return t
+}
+
 //////////////////////////////////
 ///////// CONVERSIONS
 //////////////////////////////////
@@ -183,18 +343,18 @@ func GetLatestTimestamp(t Tson) int64 {
 // Every conversion functions has 'To' or 'From' prefix
 
 // ToArray converts the given TSON array to a Go slice.
+// Nested arrays are converted to nested slices.
 func ToArray(a Array) (arr []any, err error) {
 	arr = make([]any, len(a))
 	for i, value := range a {
-		switch v := value.(type) {
-		case Leaf[string]:
-			arr[i] = v.Value
-		case Leaf[float64]:
-			arr[i] = v.Value
-		case Leaf[bool]:
-			arr[i] = v.Value
-		default:
-			return nil, fmt.Errorf("All element in a should be leaf")
+		if v, _, ok := LeafOf(value); ok {
+			arr[i] = v
+		} else if nested, ok := value.(Array); ok {
+			if arr[i], err = ToArray(nested); err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, fmt.Errorf("All element in a should be leaf or array")
 		}
 	}
 	return arr, nil
@@ -219,16 +379,11 @@ func FromCompatibleTsonBytes(data []byte, t *Tson) error {
 		switch t := v.(type) {
 		case map[string]any:
 			if isLeaf, leafVal, ts := checkLeaf(t); isLeaf {
-				switch leafVal.(type) {
-				case string:
-					return Leaf[string]{Value: leafVal.(string), Timestamp: ts}
-				case float64:
-					return Leaf[float64]{Value: leafVal.(float64), Timestamp: ts}
-				case bool:
-					return Leaf[bool]{Value: leafVal.(bool), Timestamp: ts}
-				default:
+				leaf, err := NewLeaf(leafVal, ts)
+				if err != nil {
 					return nil // NOT supported
 				}
+				return leaf
 			}
 			obj := Object{}
 			for key, val := range t {
@@ -247,14 +402,29 @@ func FromCompatibleTsonBytes(data []byte, t *Tson) error {
 	}
 
 	var intermediate any
-	if err := json.Unmarshal(data, &intermediate); err != nil {
+	if err := unmarshalJson(data, &intermediate); err != nil {
 		return err
 	}
 	*t = convert(intermediate)
 	return nil
 }
 
+// unmarshalJson unmarshals the JSON data like json.Unmarshal,
+// but keeps the numbers as json.Number, so integers stay exact.
+func unmarshalJson(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("invalid character after top-level value")
+	}
+	return nil
+}
+
 // ToCompatibleTson converts the given TSON to a JSON object.
+// Numbers are kept as json.Number, so integers stay exact.
 // NOTE: The timestamp field is kept
 func ToCompatibleTson(t Tson, o *any) error {
 	// Convert TSON to Compatible TSON (JSON with timestamp)
@@ -263,7 +433,7 @@ func ToCompatibleTson(t Tson, o *any) error {
 		return err
 	}
 	// Convert JSON byte slice to JSON object
-	if err = json.Unmarshal(barr, o); err != nil {
+	if err = unmarshalJson(barr, o); err != nil {
 		return err
 	}
 
@@ -331,27 +501,17 @@ func FromJson(o any, t *Tson) (err error) {
 		switch value := o.(type) {
 		case map[string]any:
 			for k, v := range value {
-				switch leaf := v.(type) {
-				case string:
-					value[k] = Leaf[string]{Value: leaf, Timestamp: DefaultTimestamp}
-				case float64:
-					value[k] = Leaf[float64]{Value: leaf, Timestamp: DefaultTimestamp}
-				case bool:
-					value[k] = Leaf[bool]{Value: leaf, Timestamp: DefaultTimestamp}
-				default:
+				if leaf, err := NewLeaf(v, DefaultTimestamp); err == nil {
+					value[k] = leaf
+				} else {
 					addTimestamp(v)
 				}
 			}
 		case []any:
 			for i, v := range value {
-				switch leaf := v.(type) {
-				case string:
-					value[i] = Leaf[string]{Value: leaf, Timestamp: DefaultTimestamp}
-				case float64:
-					value[i] = Leaf[float64]{Value: leaf, Timestamp: DefaultTimestamp}
-				case bool:
-					value[i] = Leaf[bool]{Value: leaf, Timestamp: DefaultTimestamp}
-				default:
+				if leaf, err := NewLeaf(v, DefaultTimestamp); err == nil {
+					value[i] = leaf
+				} else {
 					addTimestamp(v)
 				}
 			}
@@ -382,7 +542,7 @@ func ToJsonBytes(t Tson) (j []byte, err error) {
 // NOTE: The timestamp field is added with the default(< 0) value.
 func FromJsonBytes(j []byte, t *Tson) error {
 	var o any
-	if err := json.Unmarshal(j, &o); err != nil {
+	if err := unmarshalJson(j, &o); err != nil {
 		return err
 	}
 
@@ -447,16 +607,11 @@ func parseLeaf(raw map[string]any) (Value, error) {
 	)
 
 	// Create Leaf with the type of Value
-	switch v := value.(type) {
-	case string:
-		return Leaf[string]{Value: v, Timestamp: ts}, nil
-	case float64:
-		return Leaf[float64]{Value: v, Timestamp: ts}, nil
-	case bool:
-		return Leaf[bool]{Value: v, Timestamp: ts}, nil
-	default:
-		return nil, fmt.Errorf("invalid leaf type: %v (type: %T)", v, v)
+	leaf, err := NewLeaf(value, ts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid leaf type: %v (type: %T)", value, value)
 	}
+	return leaf, nil
 }
 
 // checkLeaf determines whether m (map[string]any) is a leaf node.
@@ -466,6 +621,10 @@ func checkLeaf(m map[string]any) (bool, any, int64) {
 		tsVal, okTs := m["timestamp"]
 		if okVal && okTs {
 			switch t := tsVal.(type) {
+			case json.Number:
+				if ts, err := t.Int64(); err == nil {
+					return true, val, ts
+				}
 			case float64:
 				return true, val, int64(t)
 			case int:
@@ -490,14 +649,11 @@ func ToCompatibleTsonBytesSorted(t Tson) ([]byte, error) {
 
 // toSortedInterface recursively converts TSON -> map/slice with sorted keys.
 func toSortedInterface(t Tson) (any, error) {
-	switch val := t.(type) {
-	case Leaf[string]:
-		return map[string]any{"value": val.Value, "timestamp": val.Timestamp}, nil
-	case Leaf[float64]:
-		return map[string]any{"value": val.Value, "timestamp": val.Timestamp}, nil
-	case Leaf[bool]:
-		return map[string]any{"value": val.Value, "timestamp": val.Timestamp}, nil
+	if value, ts, ok := LeafOf(t); ok {
+		return map[string]any{"value": value, "timestamp": ts}, nil
+	}
 
+	switch val := t.(type) {
 	case Object:
 		// 1. 수집된 key들을 사전순으로 정렬
 		keys := make([]string, 0, len(val))
@@ -9,10 +9,15 @@
 package tson
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
+	"math"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -45,7 +50,7 @@ var testCases = []struct {
 		}`,
 		want: Object{
 			"name":       Leaf[string]{Value: "John Doe", Timestamp: 1678886400},
-			"age":        Leaf[float64]{Value: 30, Timestamp: 1678886400},
+			"age":        Leaf[int64]{Value: 30, Timestamp: 1678886400},
 			"is-married": Leaf[bool]{Value: true, Timestamp: 1678886400},
 			"address": Object{
 				"street": Leaf[string]{Value: "123 Main St", Timestamp: 1678886400},
@@ -192,3 +197,426 @@ func TestMarshalIndent(t *testing.T) {
 	b, _ := MarshalIndent(parsedTson, "", "  ")
 	t.Log(string(b))
 }
+
+func TestLeafTypes(t *testing.T) {
+	var parsed Tson
+	assert.Nil(t, Unmarshal([]byte(`[1, 1.0, 1e2, -9223372036854775808, 9223372036854775808, 18446744073709551616, <5> null]`), &parsed))
+	assert.Equal(t, Array{
+		Leaf[int64]{Value: 1},
+		Leaf[float64]{Value: 1},
+		Leaf[float64]{Value: 100},
+		Leaf[int64]{Value: math.MinInt64},
+		Leaf[uint64]{Value: math.MaxInt64 + 1},
+		Leaf[float64]{Value: math.MaxUint64 + 1}, // Out of the range of uint64
+		Leaf[Null]{Timestamp: 5},
+	}, parsed)
+
+	doc := Object{
+		"odometer": Leaf[uint64]{Value: math.MaxUint64, Timestamp: 1700000000},
+		"gear":     Leaf[int64]{Value: -1, Timestamp: 1700000000},
+		"speed":    Leaf[float64]{Value: 80, Timestamp: 1700000000},
+		"trailer":  Leaf[Null]{Timestamp: 1700000000},
+		"matrix": Array{
+			Array{Leaf[int64]{Value: 1, Timestamp: 1700000000}, Leaf[Null]{Timestamp: DefaultTimestamp}},
+			Array{},
+		},
+	}
+
+	// Every encoding round-trips the leaves, exactly
+	b, err := Marshal(doc)
+	assert.Nil(t, err)
+	assert.Nil(t, Unmarshal(b, &parsed))
+	assert.Equal(t, doc, parsed)
+
+	b, err = MarshalIndent(doc, "", "  ")
+	assert.Nil(t, err)
+	assert.Nil(t, Unmarshal(b, &parsed))
+	assert.Equal(t, doc, parsed)
+
+	b, err = MarshalBinary(doc)
+	assert.Nil(t, err)
+	assert.Nil(t, UnmarshalBinary(b, &parsed))
+	assert.Equal(t, doc, parsed)
+
+	b, err = ToCompatibleTsonBytes(doc)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"value":18446744073709551615`)
+	assert.Contains(t, string(b), `"value":80.0`)
+	assert.Nil(t, FromCompatibleTsonBytes(b, &parsed))
+	assert.Equal(t, doc, parsed)
+
+	// Integers are compared exactly
+	other, _ := Clone(doc)
+	other.(Object)["odometer"] = Leaf[uint64]{Value: math.MaxUint64 - 1, Timestamp: 1700000000}
+	eq, err := EqualWithoutTimestamp(doc, other)
+	assert.Nil(t, err)
+	assert.False(t, eq)
+}
+
+func TestMarshalBinary(t *testing.T) {
+	for _, file := range []string{tson1, tson2} {
+		var (
+			parsedTson    Tson
+			stringTson, _ = os.ReadFile(file)
+			err           = Unmarshal(stringTson, &parsedTson)
+		)
+		assert.Nil(t, err)
+
+		b, err := MarshalBinary(parsedTson)
+		assert.Nil(t, err)
+		assert.Less(t, len(b), len(stringTson)/2)
+
+		var decoded Tson
+		assert.Nil(t, UnmarshalBinary(b, &decoded))
+		assert.Equal(t, parsedTson, decoded)
+
+		// Deterministic, despite the random order of map iteration
+		again, _ := MarshalBinary(decoded)
+		assert.Equal(t, b, again)
+
+		// Truncated data
+		for i := range b {
+			assert.NotNil(t, UnmarshalBinary(b[:i], &decoded))
+		}
+	}
+
+	// Negative and default timestamps
+	leaf := Array{Leaf[bool]{Value: true, Timestamp: DefaultTimestamp}, Leaf[string]{Value: "", Timestamp: -1700000000}}
+	b, err := MarshalBinary(leaf)
+	assert.Nil(t, err)
+	var decoded Tson
+	assert.Nil(t, UnmarshalBinary(b, &decoded))
+	assert.Equal(t, Tson(leaf), decoded)
+
+	assert.NotNil(t, UnmarshalBinary(append(b, 0), &decoded))
+}
+
+func TestDecoder(t *testing.T) {
+	// A sequence of documents, read in small chunks
+	stream := `{ "speed" <1700000000>: 72.5 }
+	[ <1700000000> 32.1, <> 31.8 ]
+	"ABC1234" <1700000000>
+	{ "location": { "latitude" <1800000000>: 37.7749 } }`
+	dec := NewDecoder(iotest.OneByteReader(strings.NewReader(stream)))
+
+	var docs []Tson
+	for {
+		var doc Tson
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		docs = append(docs, doc)
+	}
+	assert.Equal(t, []Tson{
+		Object{"speed": Leaf[float64]{Value: 72.5, Timestamp: 1700000000}},
+		Array{Leaf[float64]{Value: 32.1, Timestamp: 1700000000}, Leaf[float64]{Value: 31.8, Timestamp: -1}},
+		Leaf[string]{Value: "ABC1234", Timestamp: 1700000000},
+		Object{"location": Object{"latitude": Leaf[float64]{Value: 37.7749, Timestamp: 1800000000}}},
+	}, docs)
+
+	// Truncated input
+	var doc Tson
+	dec = NewDecoder(strings.NewReader(`{ "speed" <1700000000>: 72.5, "engineOn"`))
+	assert.ErrorIs(t, dec.Decode(&doc), io.ErrUnexpectedEOF)
+}
+
+func TestDecoderToken(t *testing.T) {
+	// Decode the elements of a large array one by one
+	dec := NewDecoder(strings.NewReader(`{
+		"vehicleId" <1700000000>: "ABC1234",
+		"tirePressure": [ <1700000000> 32.1, { "rear" <1700000000>: true }, null ]
+	}`))
+
+	var tokens []Token
+	for i := 0; i < 5; i++ {
+		token, err := dec.Token()
+		assert.Nil(t, err)
+		tokens = append(tokens, token)
+	}
+	assert.Equal(t, []Token{Delim('{'), "vehicleId", Timestamp(1700000000), "ABC1234", "tirePressure"}, tokens)
+
+	token, err := dec.Token()
+	assert.Nil(t, err)
+	assert.Equal(t, Delim('['), token)
+	var elems []Tson
+	for dec.More() {
+		var elem Tson
+		assert.Nil(t, dec.Decode(&elem))
+		elems = append(elems, elem)
+	}
+	assert.Equal(t, []Tson{
+		Leaf[float64]{Value: 32.1, Timestamp: 1700000000},
+		Object{"rear": Leaf[bool]{Value: true, Timestamp: 1700000000}},
+		Leaf[Null]{Timestamp: DefaultTimestamp},
+	}, elems)
+
+	for _, expected := range []Token{Delim(']'), Delim('}')} {
+		token, err = dec.Token()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, token)
+	}
+	_, err = dec.Token()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestEncoder(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		enc = NewEncoder(&buf)
+		doc = Object{
+			"speed":        Leaf[float64]{Value: 72.5, Timestamp: 1700000000},
+			"tirePressure": Array{Leaf[float64]{Value: 32.1, Timestamp: -1}},
+		}
+	)
+	assert.Nil(t, enc.Encode(doc))
+	enc.SetIndent("", "  ")
+	assert.Nil(t, enc.Encode(Leaf[bool]{Value: true, Timestamp: 1700000000}))
+	assert.NotNil(t, enc.Encode(Object{"engineOn": complexLeaf{}}))
+
+	// Written documents are read back
+	dec := NewDecoder(&buf)
+	var decoded Tson
+	assert.Nil(t, dec.Decode(&decoded))
+	assert.Equal(t, Tson(doc), decoded)
+	assert.Nil(t, dec.Decode(&decoded))
+	assert.Equal(t, Tson(Leaf[bool]{Value: true, Timestamp: 1700000000}), decoded)
+}
+
+func TestEncoderIndentRoundTrip(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		enc = NewEncoder(&buf)
+		doc = Object{
+			"speed":   Leaf[float64]{Value: 72.0, Timestamp: 1700000000},
+			"current": Leaf[float64]{Value: 1e-5, Timestamp: 1700000000},
+			"voltage": Array{Leaf[float64]{Value: 3.75, Timestamp: -1}, Leaf[float64]{Value: -2.5e21, Timestamp: -1}},
+		}
+	)
+	enc.SetIndent("", "  ")
+	assert.Nil(t, enc.Encode(doc))
+
+	dec := NewDecoder(&buf)
+	var decoded Tson
+	assert.Nil(t, dec.Decode(&decoded))
+	assert.Equal(t, Tson(doc), decoded)
+
+	// Numbers without a timestamp as well
+	b, err := MarshalIndent(map[string]any{"current": 1e-5, "speed": 72.0}, "", "  ")
+	assert.Nil(t, err)
+	assert.Nil(t, Unmarshal(b, &decoded))
+	current, err := GetValue(decoded, "/current")
+	assert.Nil(t, err)
+	if leaf, ok := current.(Leaf[float64]); assert.True(t, ok, "%#v", current) {
+		assert.Equal(t, 1e-5, leaf.Value)
+	}
+	speed, err := GetValue(decoded, "/speed")
+	assert.Nil(t, err)
+	if leaf, ok := speed.(Leaf[float64]); assert.True(t, ok, "%#v", speed) {
+		assert.Equal(t, 72.0, leaf.Value)
+	}
+}
+
+// complexLeaf is a Value TSON cannot represent
+type complexLeaf struct{}
+
+func (complexLeaf) isValue() {}
+
+func TestSyntaxError(t *testing.T) {
+	const doc = `{
+	"vehicleId" <1700000000>: "ABC1234",
+	"location": {
+		"latitude" <17x>: 37.7749
+	}
+}`
+	var parsed Tson
+	err := Unmarshal([]byte(doc), &parsed)
+	var syntaxErr *SyntaxError
+	assert.ErrorAs(t, err, &syntaxErr)
+	assert.Equal(t, &SyntaxError{
+		Offset:   int64(strings.Index(doc, "x>")),
+		Line:     4,
+		Column:   17,
+		Excerpt:  "\t\t\"latitude\" <17x>: 37.7749",
+		Expected: "'>'",
+		Found:    "'x'",
+		Path:     "/location/latitude",
+	}, syntaxErr)
+	assert.Equal(t, "syntax error at line 4, column 17: expected '>', found 'x' (in /location/latitude)\n"+
+		"\t\t\t\"latitude\" <17x>: 37.7749\n"+
+		"\t\t\t              ^", err.Error())
+
+	// Errors in array elements, and at the end of the input
+	for _, tc := range []struct {
+		tson, expected, found, path string
+	}{
+		{`{ "tirePressure": [ <1> 32.1, <2> -, <3> 31.9 ] }`, "digits", "','", "/tirePressure/1"},
+		{`{ "speed" <99999999999999999999>: 72.5 }`, "a timestamp in the range of int64", "'9'", "/speed"},
+		{`{ "vehicleId": "ABC\x" }`, "an escape character", "'x'", "/vehicleId"},
+		{`{ "engineOn": true `, "',' or '}'", "end of input", ""},
+		{`{ speed: 72.5 }`, "a string key", "'s'", ""},
+	} {
+		err := Unmarshal([]byte(tc.tson), &parsed)
+		assert.ErrorAs(t, err, &syntaxErr, tc.tson)
+		assert.Equal(t, tc.expected, syntaxErr.Expected, tc.tson)
+		assert.Equal(t, tc.found, syntaxErr.Found, tc.tson)
+		assert.Equal(t, tc.path, syntaxErr.Path, tc.tson)
+	}
+
+	// The lines of the documents decoded before are counted
+	dec := NewDecoder(strings.NewReader("{ \"speed\" <1>: 72.5 }\n{\n\t\"engineOn\" <2>: yes\n}"))
+	assert.Nil(t, dec.Decode(&parsed))
+	assert.ErrorAs(t, dec.Decode(&parsed), &syntaxErr)
+	assert.Equal(t, 3, syntaxErr.Line)
+	assert.Equal(t, 18, syntaxErr.Column)
+	assert.Equal(t, "\t\"engineOn\" <2>: yes", syntaxErr.Excerpt)
+}
+
+func TestUnmarshalCollect(t *testing.T) {
+	const doc = `{
+	"vehicleId" <1700000000>: "ABC1234",
+	"speed" <17x>: 72.5,
+	"tirePressure": [ <1700000000> 32.1, <1700000000> ?, <1700000000> 32.0 ],
+	"engineOn" <1700000000>: true
+	"location": { "latitude" <1700000000>: 37.7749 }
+}`
+	var parsed Tson
+	err := UnmarshalCollect([]byte(doc), &parsed, 10)
+	var errs SyntaxErrors
+	assert.ErrorAs(t, err, &errs)
+	assert.Len(t, errs, 3)
+	assert.Equal(t, []int{3, 4, 6}, []int{errs[0].Line, errs[1].Line, errs[2].Line})
+	assert.Equal(t, "/tirePressure/1", errs[1].Path)
+	assert.Equal(t, "',' or '}'", errs[2].Expected) // Found at the next member
+
+	// The rest of the document is parsed
+	assert.Equal(t, Object{
+		"vehicleId": Leaf[string]{Value: "ABC1234", Timestamp: 1700000000},
+		"tirePressure": Array{
+			Leaf[float64]{Value: 32.1, Timestamp: 1700000000},
+			Leaf[float64]{Value: 32.0, Timestamp: 1700000000},
+		},
+		"engineOn": Leaf[bool]{Value: true, Timestamp: 1700000000},
+	}, parsed)
+
+	// Up to max errors
+	err = UnmarshalCollect([]byte(doc), &parsed, 2)
+	assert.ErrorAs(t, err, &errs)
+	assert.Len(t, errs, 2)
+	var syntaxErr *SyntaxError
+	assert.ErrorAs(t, err, &syntaxErr)
+
+	assert.Nil(t, UnmarshalCollect([]byte(`{ "speed" <1>: 72.5 }`), &parsed, 10))
+}
+
+// TestConformance checks the parser against conformance cases in the style of
+// JSONTestSuite: every valid JSON is valid TSON (with the same values),
+// and invalid JSON is rejected.
+func TestConformance(t *testing.T) {
+	accepted := []string{
+		`[]`, `{}`, `[[]   ]`, `[[[[["deep"]]]]]`, `{"":0}`, `{"a":"b","a":"c"}`,
+		`[1,null,null,null,2]`, `[true, false, null]`, `{"a":[{"b":null}]}`,
+		" \r\n\t[1\n,\t2\r] \n", `"top-level"`, `123`, `true`, `null`,
+		// Numbers
+		`[0]`, `[-0]`, `[-123]`, `[1.0]`, `[123.456789]`, `[-2147483647]`,
+		`[1E22]`, `[1e-5]`, `[1E+2]`, `[-1e-5]`, `[0e1]`, `[-0.0e0]`, `[123.456e78]`,
+		`[1.7976931348623157e308]`, `[5e-324]`, `[1e-400]`,
+		// Strings
+		`["\"\\\/\b\f\n\r\t"]`, `["\u0000"]`, `["\u0060\u012a\u12AB"]`, `["\u002c"]`,
+		`["new\u00A0line"]`, `["€𝄞"]`, `["\uD801\udc37"]`, `["\ud83d\ude39\ud83d\udc8d"]`,
+		`{"\u0000key":"x"}`,
+		// Lone surrogates become U+FFFD
+		`["\uD800"]`, `["\uDd1ea"]`, `["\uD800\uD800\n"]`, `["\ud800abc"]`, `["\uD800A"]`,
+	}
+	for _, doc := range accepted {
+		var parsed Tson
+		if !assert.Nil(t, Unmarshal([]byte(doc), &parsed), doc) {
+			continue
+		}
+		var expected any
+		assert.Nil(t, json.Unmarshal([]byte(doc), &expected), doc)
+		assert.Equal(t, expected, plainValue(parsed), doc)
+	}
+
+	rejected := []string{
+		``, ` `, `[`, `]`, `["a"`, `{"a":`, `[1]]`, `[1] x`, `{} {}`, `abc`,
+		`[1 true]`, `[1,]`, `[,1]`, `{"a":1,}`, `{"a" "b"}`, `{1:1}`, `{"a":1 "b":2}`, `['single']`,
+		`[tru]`, `[nul]`, `[True]`, `[truex]`,
+		// Numbers
+		`[01]`, `[-01]`, `[1.]`, `[.1]`, `[-.123]`, `[+1]`, `[- 1]`, `[-]`, `[1e]`, `[1e+]`,
+		`[1eE2]`, `[2.e3]`, `[1e1.5]`, `[0x42]`, `[Infinity]`, `[-Infinity]`, `[NaN]`,
+		// Strings
+		`["\x00"]`, `["\a"]`, `["\uqqqq"]`, `["\u00A"]`, `["\uD800\u"]`, `["\uD800\uZZZZ"]`,
+		"[\"a\tb\"]", "[\"a\nb\"]", "[\"\x1f\"]",
+	}
+	for _, doc := range rejected {
+		var parsed Tson
+		assert.Error(t, Unmarshal([]byte(doc), &parsed), doc)
+		assert.False(t, json.Valid([]byte(doc)), doc) // The case itself is invalid JSON
+	}
+
+	// Implementation-defined: numbers out of the range of float64 are rejected, as by encoding/json
+	var parsed Tson
+	var syntaxErr *SyntaxError
+	assert.ErrorAs(t, Unmarshal([]byte(`[1e400]`), &parsed), &syntaxErr)
+	assert.Equal(t, "a number in the range of float64", syntaxErr.Expected)
+
+	// Negative timestamps, and exponents in TSON
+	assert.Nil(t, Unmarshal([]byte(`{ "speed" <-5>: 7.25e1, "tirePressure": [ <-1> 3.21E+1, <> 1e-5 ] }`), &parsed))
+	assert.Equal(t, Object{
+		"speed": Leaf[float64]{Value: 72.5, Timestamp: -5},
+		"tirePressure": Array{
+			Leaf[float64]{Value: 32.1, Timestamp: DefaultTimestamp},
+			Leaf[float64]{Value: 1e-5, Timestamp: DefaultTimestamp},
+		},
+	}, parsed)
+	assert.Nil(t, Unmarshal([]byte(`"ABC1234" <-1700000000>`), &parsed))
+	assert.Equal(t, Leaf[string]{Value: "ABC1234", Timestamp: -1700000000}, parsed)
+
+	// What Marshal and MarshalIndent write is parsed back
+	doc := Object{
+		"speed":     Leaf[float64]{Value: 1e-5, Timestamp: -5},
+		"vehicleId": Leaf[string]{Value: "\x00\a\t\"é \U0001D11E", Timestamp: 1700000000},
+		"tirePressure": Array{
+			Leaf[float64]{Value: 1e22, Timestamp: -2},
+			Leaf[string]{Value: "\x1b[0m", Timestamp: DefaultTimestamp},
+		},
+		"\x7f\\key": Leaf[bool]{Value: true, Timestamp: 0},
+	}
+	b, err := Marshal(doc)
+	assert.Nil(t, err)
+	assert.Nil(t, Unmarshal(b, &parsed), string(b))
+	assert.Equal(t, doc, parsed)
+	b, err = MarshalIndent(doc, "", "  ")
+	assert.Nil(t, err)
+	assert.Nil(t, Unmarshal(b, &parsed), string(b))
+	assert.Equal(t, doc, parsed)
+}
+
+// plainValue returns the value of t as encoding/json would decode it (into any).
+func plainValue(t Tson) any {
+	switch v := t.(type) {
+	case Object:
+		obj := make(map[string]any, len(v))
+		for key, value := range v {
+			obj[key] = plainValue(value)
+		}
+		return obj
+	case Array:
+		arr := make([]any, len(v))
+		for i, value := range v {
+			arr[i] = plainValue(value)
+		}
+		return arr
+	default:
+		value, _, _ := LeafOf(v)
+		switch n := value.(type) { // encoding/json decodes every number as float64
+		case int64:
+			return float64(n)
+		case uint64:
+			return float64(n)
+		}
+		return value
+	}
+}
@@ -0,0 +1,179 @@
+//
+// arraydiff.go
+//
+// The difference between two arrays, for GeneratePatch.
+//
+// By default, arrays are diffed into the fewest "add", "remove" and
+// "replace" operations: an edit distance over the elements left after the
+// common prefix and suffix, computed in linear space (see editScript),
+// so inserting an element at the front of an array is a single "add",
+// not a "replace" of every element. A positional diff compares the
+// elements at the same index instead, and adds or removes the elements
+// past the shorter array.
+//
+// Either way, an object or an array replaced by one of the same kind
+// is diffed recursively, as the members of objects are.
+//
+
+package tsonpatch
+
+import (
+	"slices"
+
+	"github.com/CAU-CPSS/logument/internal/tson"
+)
+
+// ArrayDiff is the way GeneratePatchWith compares arrays.
+type ArrayDiff int
+
+const (
+	MinimalArrayDiff    ArrayDiff = iota // The fewest operations (the default)
+	PositionalArrayDiff                  // Element by element, at the same index
+)
+
+// DiffOptions configures GeneratePatchWith.
+type DiffOptions struct {
+	WithTimestamp bool      // Replace the leaves with a new timestamp too, as GeneratePatchWithTimestamp
+	ArrayDiff     ArrayDiff // How arrays are compared
+}
+
+// GeneratePatchWith generates a JSON patch from two TSON documents, as configured by opts.
+func GeneratePatchWith(origin, modified tson.Tson, opts DiffOptions) (Patch, error) {
+	return handleValuesWith(origin, modified, "", Patch{}, opts)
+}
+
+// diffArray appends the operations turning the array origin into modified, at path, to patch.
+func diffArray(origin, modified tson.Array, path string, patch Patch, opts DiffOptions) (Patch, error) {
+	if opts.ArrayDiff == PositionalArrayDiff {
+		return diffArrayPositional(origin, modified, path, patch, opts)
+	}
+	equal := func(a, b tson.Value) bool {
+		return matchesValue(a, b) && (!opts.WithTimestamp || matchTimestamp(a, b))
+	}
+
+	// Skip the common prefix and suffix
+	start, end := 0, 0
+	for start < len(origin) && start < len(modified) && equal(origin[start], modified[start]) {
+		start++
+	}
+	for end < len(origin)-start && end < len(modified)-start &&
+		equal(origin[len(origin)-1-end], modified[len(modified)-1-end]) {
+		end++
+	}
+	a, b := origin[start:len(origin)-end], modified[start:len(modified)-end]
+
+	// Follow the edit script; a[i:] is then at the index start+j of the array being patched
+	var err error
+	i, j := 0, 0
+	for _, e := range editScript(a, b, equal) {
+		p := makePath(path, start+j)
+		switch e {
+		case editKeep:
+			i, j = i+1, j+1
+		case editReplace:
+			if patch, err = handleValuesWith(a[i], b[j], p, patch, opts); err != nil {
+				return nil, err
+			}
+			i, j = i+1, j+1
+		case editRemove:
+			patch = append(patch, NewOperation(OpRemove, p, nil, removedTimestamp(a[i])))
+			i++
+		case editAdd:
+			value, ts := opValue(b[j])
+			patch = append(patch, NewOperation(OpAdd, p, value, ts))
+			j++
+		}
+	}
+	return patch, nil
+}
+
+// edit is a step of an edit script, which turns an array into another element by element
+type edit byte
+
+const (
+	editKeep    edit = iota // Keep the element
+	editReplace             // Replace the element by the next one of the other array
+	editRemove              // Remove the element
+	editAdd                 // Add the next element of the other array
+)
+
+// editScript returns the fewest edits turning a into b.
+// It takes linear space (Hirschberg's algorithm): the middle element of a
+// splits b where the edit distances of both halves add up to the least,
+// and each half is solved recursively.
+func editScript(a, b tson.Array, equal func(a, b tson.Value) bool) []edit {
+	switch {
+	case len(a) == 0:
+		return slices.Repeat([]edit{editAdd}, len(b))
+	case len(b) == 0:
+		return slices.Repeat([]edit{editRemove}, len(a))
+	case len(a) == 1:
+		k := slices.IndexFunc(b, func(v tson.Value) bool { return equal(a[0], v) })
+		if k < 0 {
+			return append([]edit{editReplace}, slices.Repeat([]edit{editAdd}, len(b)-1)...)
+		}
+		script := append(slices.Repeat([]edit{editAdd}, k), editKeep)
+		return append(script, slices.Repeat([]edit{editAdd}, len(b)-k-1)...)
+	}
+
+	mid := len(a) / 2
+	front, back := editCosts(a[:mid], b, equal, false), editCosts(a[mid:], b, equal, true)
+	split := 0
+	for j := range front {
+		// The last split on a tie, so replaces come before removes
+		if front[j]+back[j] <= front[split]+back[split] {
+			split = j
+		}
+	}
+	return append(editScript(a[:mid], b[:split], equal), editScript(a[mid:], b[split:], equal)...)
+}
+
+// editCosts returns the edit distances from a to every prefix of b (to b[:j] at j),
+// or if suffix is set, to every suffix of b (to b[j:] at j), keeping a single row.
+func editCosts(a, b tson.Array, equal func(a, b tson.Value) bool, suffix bool) []int {
+	at := func(s tson.Array, i int) tson.Value {
+		if suffix {
+			return s[len(s)-1-i]
+		}
+		return s[i]
+	}
+
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := range a {
+		diag := row[0]
+		row[0] = i + 1
+		for j := 1; j <= len(b); j++ {
+			cost := diag
+			if !equal(at(a, i), at(b, j-1)) {
+				cost = 1 + min(diag, row[j], row[j-1])
+			}
+			diag, row[j] = row[j], cost
+		}
+	}
+	if suffix {
+		slices.Reverse(row)
+	}
+	return row
+}
+
+// diffArrayPositional appends the operations turning the array origin into modified,
+// at path, to patch, comparing the elements at the same index.
+func diffArrayPositional(origin, modified tson.Array, path string, patch Patch, opts DiffOptions) (Patch, error) {
+	var err error
+	for i := range min(len(origin), len(modified)) {
+		if patch, err = handleValuesWith(origin[i], modified[i], makePath(path, i), patch, opts); err != nil {
+			return nil, err
+		}
+	}
+	for i := len(origin) - 1; i >= len(modified); i-- { // From the end, so the indexes hold
+		patch = append(patch, NewOperation(OpRemove, makePath(path, i), nil, removedTimestamp(origin[i])))
+	}
+	for i := len(origin); i < len(modified); i++ {
+		value, ts := opValue(modified[i])
+		patch = append(patch, NewOperation(OpAdd, makePath(path, i), value, ts))
+	}
+	return patch, nil
+}
@@ -0,0 +1,366 @@
+//
+// binary.go
+//
+// A compact binary encoding of TSON patches,
+// for bandwidth-constrained uplinks.
+//
+// A patch starts with the format version (a byte), and the number of
+// paths in the dictionary it was encoded with (a uvarint; see dictionary.go).
+// It then lists the paths new to the dictionary once, as a uvarint count
+// followed by the paths (uvarint length and bytes) in order of first
+// appearance; they take the next IDs. Then come a uvarint count of
+// operations, each as
+//
+//	head:       a byte, with the operation in its high 4 bits
+//	            and the kind of the value in its low 4 bits
+//	path:       uvarint ID in the dictionary
+//	from:       uvarint ID in the dictionary, for "move" and "copy" only
+//	timestamp:  varint delta from the previous operation (from 0 for the first)
+//	value:      string: uvarint length and bytes; number: 8 bytes,
+//	            little-endian IEEE 754; integer: varint (uvarint for uint64);
+//	            other values (e.g. objects): uvarint length and JSON bytes;
+//	            null and booleans: none, held by the head
+//
+// Operations of a patch are usually close in time, so most
+// timestamp deltas take a byte or two instead of the full varint.
+//
+
+package tsonpatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// BinaryVersion is the version of the binary encoding.
+const BinaryVersion byte = 3
+
+// opCodes lists the operations in the order of their binary codes
+var opCodes = []OpType{OpAdd, OpRemove, OpReplace, OpMove, OpCopy, OpTest}
+
+// Kinds of values in the binary encoding
+const (
+	binNull byte = iota
+	binString
+	binNumber
+	binFalse
+	binTrue
+	binInt
+	binJson
+	binInt64
+	binUint64
+)
+
+// MarshalBinary converts the Patch to the binary encoding,
+// with a path dictionary of its own.
+func (p Patch) MarshalBinary() ([]byte, error) {
+	return p.MarshalBinaryWith(NewDictionary())
+}
+
+// MarshalBinaryWith converts the Patch to the binary encoding, referring to
+// the paths by their IDs in d. The paths new to d are added to it.
+func (p Patch) MarshalBinaryWith(d *Dictionary) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var (
+		base  = len(d.paths)
+		paths []string
+		ids   = make(map[string]uint64)
+	)
+	for _, op := range p {
+		for _, path := range opPaths(op) {
+			if _, known := d.ids[path]; known {
+				continue
+			}
+			if _, exists := ids[path]; !exists {
+				ids[path] = uint64(base + len(paths))
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	buf := []byte{BinaryVersion}
+	buf = binary.AppendUvarint(buf, uint64(base))
+	buf = binary.AppendUvarint(buf, uint64(len(paths)))
+	for _, path := range paths {
+		buf = appendBinaryString(buf, path)
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(p)))
+	last := int64(0)
+	for _, op := range p {
+		code := opCode(op.Op)
+		if code < 0 {
+			return nil, fmt.Errorf("MarshalBinary(): Unknown operation %s", op.Op)
+		}
+		kind, payload, err := binaryValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, byte(code)<<4|kind)
+		for _, path := range opPaths(op) {
+			id, known := d.ids[path]
+			if !known {
+				id = ids[path]
+			}
+			buf = binary.AppendUvarint(buf, id)
+		}
+		buf = binary.AppendVarint(buf, op.Timestamp-last)
+		buf = append(buf, payload...)
+		last = op.Timestamp
+	}
+
+	// The dictionary learns the new paths only once the patch is encoded
+	for _, path := range paths {
+		d.add(path)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary converts the binary encoding of a patch
+// (with a path dictionary of its own) to a Patch.
+func UnmarshalBinary(b []byte) (Patch, error) {
+	return UnmarshalBinaryWith(b, NewDictionary())
+}
+
+// UnmarshalBinaryWith converts the binary encoding of a patch to a Patch,
+// looking up the IDs of the paths in d. The paths new to d are added to it.
+// The patch must have been encoded with the same dictionary.
+func UnmarshalBinaryWith(b []byte, d *Dictionary) (Patch, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("UnmarshalBinary(): Empty data")
+	}
+	if b[0] != BinaryVersion {
+		return nil, fmt.Errorf("UnmarshalBinary(): Unsupported version %d", b[0])
+	}
+	r := bytes.NewReader(b[1:])
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	base, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errUnexpectedEnd
+	}
+	if base != uint64(len(d.paths)) {
+		return nil, fmt.Errorf("UnmarshalBinary(): Encoded with a dictionary of %d paths, not %d", base, len(d.paths))
+	}
+
+	n, err := readBinaryLength(r)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, n)
+	for i := range paths {
+		if paths[i], err = readBinaryString(r); err != nil {
+			return nil, err
+		}
+	}
+	lookup := func(id uint64) (string, bool) {
+		if id < base {
+			return d.paths[id], true
+		}
+		if id-base < uint64(len(paths)) {
+			return paths[id-base], true
+		}
+		return "", false
+	}
+
+	if n, err = readBinaryLength(r); err != nil {
+		return nil, err
+	}
+	patch := make(Patch, n)
+	last := int64(0)
+	for i := range patch {
+		head, err := r.ReadByte()
+		if err != nil {
+			return nil, errUnexpectedEnd
+		}
+		code := int(head >> 4)
+		if code >= len(opCodes) {
+			return nil, fmt.Errorf("UnmarshalBinary(): Unknown operation code %d", code)
+		}
+		op := opCodes[code]
+		path, err := readBinaryPath(r, lookup)
+		if err != nil {
+			return nil, err
+		}
+		var from string
+		if op == OpMove || op == OpCopy {
+			if from, err = readBinaryPath(r, lookup); err != nil {
+				return nil, err
+			}
+		}
+		delta, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, errUnexpectedEnd
+		}
+		value, err := readBinaryValue(r, head&0x0f)
+		if err != nil {
+			return nil, err
+		}
+		last += delta
+		patch[i] = NewOperation(op, path, value, last)
+		patch[i].From = from
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("UnmarshalBinary(): %d trailing bytes", r.Len())
+	}
+
+	// The dictionary learns the new paths only once the patch is decoded
+	for _, path := range paths {
+		d.add(path)
+	}
+	return patch, nil
+}
+
+// errUnexpectedEnd is returned when the binary encoding is truncated
+var errUnexpectedEnd = fmt.Errorf("UnmarshalBinary(): Unexpected end of data")
+
+// opPaths returns the paths of op, in the order they are encoded
+func opPaths(op Operation) []string {
+	if op.Op == OpMove || op.Op == OpCopy {
+		return []string{op.Path, op.From}
+	}
+	return []string{op.Path}
+}
+
+// readBinaryPath reads the ID of a path from r, and looks it up
+func readBinaryPath(r *bytes.Reader, lookup func(uint64) (string, bool)) (string, error) {
+	id, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", errUnexpectedEnd
+	}
+	path, ok := lookup(id)
+	if !ok {
+		return "", fmt.Errorf("UnmarshalBinary(): Path %d not in the dictionary", id)
+	}
+	return path, nil
+}
+
+// opCode returns the binary code of op, or -1 if op is unknown
+func opCode(op OpType) int {
+	for i, o := range opCodes {
+		if o == op {
+			return i
+		}
+	}
+	return -1
+}
+
+// binaryValue returns the kind and the encoded payload of the value of an operation
+func binaryValue(value any) (kind byte, payload []byte, err error) {
+	switch v := value.(type) {
+	case nil:
+		return binNull, nil, nil
+	case string:
+		return binString, appendBinaryString(nil, v), nil
+	case float64:
+		return binNumber, binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)), nil
+	case bool:
+		if v {
+			return binTrue, nil, nil
+		}
+		return binFalse, nil, nil
+	case int:
+		return binInt, binary.AppendVarint(nil, int64(v)), nil
+	case int64:
+		return binInt64, binary.AppendVarint(nil, v), nil
+	case uint64:
+		return binUint64, binary.AppendUvarint(nil, v), nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return 0, nil, fmt.Errorf("MarshalBinary(): Unsupported value type %T: %w", value, err)
+		}
+		return binJson, appendBinaryString(nil, string(b)), nil
+	}
+}
+
+// readBinaryValue reads a value of the given kind from r
+func readBinaryValue(r *bytes.Reader, kind byte) (any, error) {
+	switch kind {
+	case binNull:
+		return nil, nil
+	case binString:
+		return readBinaryString(r)
+	case binNumber:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, errUnexpectedEnd
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), nil
+	case binFalse, binTrue:
+		return kind == binTrue, nil
+	case binInt:
+		v, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, errUnexpectedEnd
+		}
+		return int(v), nil
+	case binInt64:
+		v, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, errUnexpectedEnd
+		}
+		return v, nil
+	case binUint64:
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errUnexpectedEnd
+		}
+		return v, nil
+	case binJson:
+		s, err := readBinaryString(r)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("UnmarshalBinary(): Invalid value: %w", err)
+		}
+		return exactNumbers(v), nil
+	default:
+		return nil, fmt.Errorf("UnmarshalBinary(): Unknown value kind %d", kind)
+	}
+}
+
+// appendBinaryString appends s, prefixed by its length, to buf
+func appendBinaryString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// readBinaryString reads a string written by appendBinaryString from r
+func readBinaryString(r *bytes.Reader) (string, error) {
+	n, err := readBinaryLength(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", errUnexpectedEnd
+	}
+	return string(b), nil
+}
+
+// readBinaryLength reads a count or a length from r,
+// making sure that much data can follow (every item takes a byte at least)
+func readBinaryLength(r *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, errUnexpectedEnd
+	}
+	if n > uint64(r.Len()) {
+		return 0, fmt.Errorf("UnmarshalBinary(): Length %d exceeds the data", n)
+	}
+	return int(n), nil
+}
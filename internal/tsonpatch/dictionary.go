@@ -0,0 +1,89 @@
+//
+// dictionary.go
+//
+// A path dictionary, which interns the paths of patches
+// (e.g. VSS signals) as small integer IDs.
+//
+// Both sides of a link negotiate the same dictionary, e.g. from
+// a VSS schema (see vssgen), or start from an empty one, and encode
+// patches with `Patch.MarshalBinaryWith` / `UnmarshalBinaryWith`.
+// A patch then carries IDs instead of paths, except for the paths new
+// to the dictionary, which it lists once, so both sides learn them.
+//
+
+package tsonpatch
+
+import "sync"
+
+// Dictionary maps paths to IDs, in the order they were added (from 0).
+type Dictionary struct {
+	mu    sync.RWMutex
+	paths []string          // The paths, by their IDs
+	ids   map[string]uint64 // The IDs, by their paths
+}
+
+// NewDictionary creates a new Dictionary with the given paths.
+// Duplicated paths are added once.
+func NewDictionary(paths ...string) *Dictionary {
+	d := &Dictionary{ids: make(map[string]uint64, len(paths))}
+	for _, path := range paths {
+		d.add(path)
+	}
+	return d
+}
+
+// Len returns the number of paths in the dictionary.
+func (d *Dictionary) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return len(d.paths)
+}
+
+// ID returns the ID of the path, and whether it is in the dictionary.
+func (d *Dictionary) ID(path string) (uint64, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	id, exists := d.ids[path]
+	return id, exists
+}
+
+// Path returns the path of the ID, and whether it is in the dictionary.
+func (d *Dictionary) Path(id uint64) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if id >= uint64(len(d.paths)) {
+		return "", false
+	}
+	return d.paths[id], true
+}
+
+// Add adds the path to the dictionary (if new), and returns its ID.
+func (d *Dictionary) Add(path string) uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.add(path)
+}
+
+// Paths returns the paths of the dictionary, by their IDs,
+// e.g. to hand the dictionary over to the other side.
+func (d *Dictionary) Paths() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return append([]string(nil), d.paths...)
+}
+
+// add adds the path to the dictionary (if new), and returns its ID
+func (d *Dictionary) add(path string) uint64 {
+	if id, exists := d.ids[path]; exists {
+		return id
+	}
+	id := uint64(len(d.paths))
+	d.paths = append(d.paths, path)
+	d.ids[path] = id
+	return id
+}
@@ -0,0 +1,139 @@
+//
+// invert.go
+//
+// The inverse of a patch, to undo it (e.g. a faulty configuration push).
+//
+// The operations of RFC 6902 do not carry the values they overwrite or
+// remove, so a patch cannot be undone by itself. Invert applies the patch
+// to the document it was made for, records the value (and timestamps) at
+// every path an operation overwrites or removes, and returns the patch
+// that restores the document exactly, timestamps included.
+//
+// The operations of the inverse are "add", "remove" and "replace" only.
+// An operation that restores a value takes the timestamp of that value
+// (the latest one, for an object or an array, whose leaves keep theirs);
+// an operation that removes an added value takes the timestamp of the
+// operation it undoes.
+//
+
+package tsonpatch
+
+import "github.com/CAU-CPSS/logument/internal/tson"
+
+// Invert returns the patch that undoes patch, i.e. that turns
+// ApplyPatch(doc, patch) back into doc, timestamps included.
+// doc is the document the patch applies to; it is not modified.
+func Invert(doc tson.Tson, patch Patch) (Patch, error) {
+	var undos []Patch
+	for _, op := range patch {
+		undo, err := invertOperation(doc, op)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = ApplyOperation(doc, op); err != nil {
+			return nil, err
+		}
+		undos = append(undos, undo)
+	}
+
+	// Undo the operations from the last one
+	inverse := Patch{}
+	for i := len(undos) - 1; i >= 0; i-- {
+		inverse = append(inverse, undos[i]...)
+	}
+	return inverse, nil
+}
+
+// invertOperation returns the operations that undo op, once applied to doc
+func invertOperation(doc tson.Tson, op Operation) (Patch, error) {
+	switch op.Op {
+	case OpAdd, OpReplace, OpRemove:
+		return invertWrite(doc, op), nil
+	case OpCopy:
+		return invertWrite(doc, Operation{Op: OpAdd, Path: op.Path, Timestamp: op.Timestamp}), nil
+	case OpMove:
+		if op.From == op.Path {
+			return nil, nil
+		}
+		// A move is a "remove" from op.From, then an "add" to op.Path
+		remove := Operation{Op: OpRemove, Path: op.From, Timestamp: op.Timestamp}
+		undoRemove := invertWrite(doc, remove)
+		removed, err := applyTraverse(doc, splitPath(op.From), remove)
+		if err != nil {
+			return nil, err
+		}
+		undoAdd := invertWrite(removed, Operation{Op: OpAdd, Path: op.Path, Timestamp: op.Timestamp})
+		return append(undoAdd, undoRemove...), nil
+	default: // "test" changes nothing
+		return nil, nil
+	}
+}
+
+// invertWrite returns the operations that undo the "add", "replace" or "remove"
+// operation op, once applied to doc. If op cannot be applied to doc, they are
+// irrelevant, as ApplyOperation then fails.
+func invertWrite(doc tson.Tson, op Operation) Patch {
+	parts := splitPath(op.Path)
+
+	// "add" and "replace" create the missing objects and arrays on the path (see applyTraverse)
+	for k := 1; k < len(parts); k++ {
+		if _, err := getTraverse(doc, parts[:k]); err != nil {
+			return Patch{NewOperation(OpRemove, joinPath(parts[:k]), nil, op.Timestamp)}
+		}
+	}
+	parent, err := getTraverse(doc, parts[:len(parts)-1])
+	if err != nil {
+		return nil
+	}
+
+	restore := func(kind OpType, path string, prior tson.Value) Patch {
+		value, ts := opValue(prior)
+		return Patch{NewOperation(kind, path, value, ts)}
+	}
+	last := parts[len(parts)-1]
+	switch j := parent.(type) {
+	case tson.Object:
+		path := joinPath(parts)
+		prior, exists := j[last]
+		switch {
+		case exists && op.Op == OpRemove:
+			return restore(OpAdd, path, prior)
+		case exists:
+			return restore(OpReplace, path, prior)
+		case op.Op != OpRemove:
+			return Patch{NewOperation(OpRemove, path, nil, op.Timestamp)}
+		}
+	case tson.Array:
+		idx := len(j) // "-" refers to the end of the array
+		if last != "-" {
+			if idx, err = getIndex(last); err != nil {
+				return nil
+			}
+		}
+		parentPath := joinPath(parts[:len(parts)-1])
+		switch {
+		case op.Op == OpAdd:
+			return Patch{NewOperation(OpRemove, makePath(parentPath, idx), nil, op.Timestamp)}
+		case idx < len(j) && op.Op == OpRemove:
+			return restore(OpAdd, makePath(parentPath, idx), j[idx])
+		case idx < len(j):
+			return restore(OpReplace, makePath(parentPath, idx), j[idx])
+		case op.Op == OpReplace: // Extends the array up to idx
+			var undo Patch
+			for i := idx; i >= len(j); i-- {
+				undo = append(undo, NewOperation(OpRemove, makePath(parentPath, i), nil, op.Timestamp))
+			}
+			return undo
+		}
+	}
+	return nil
+}
+
+// joinPath returns the path of the parts, as makePath builds it
+func joinPath(parts []string) string {
+	path := ""
+	for _, part := range parts {
+		path = makePath(path, part)
+	}
+	return path
+}
@@ -0,0 +1,181 @@
+//
+// operations.go
+//
+// The "move", "copy" and "test" operations of RFC 6902,
+// which refer to a value already in the document.
+//
+// Timestamps: "move" and "copy" relocate a value measured earlier,
+// so its leaves keep their timestamps, unless the operation has a
+// timestamp of its own (neither 0 nor DefaultTimestamp, as in the
+// RFC 6902 patches of other tools), which the leaves then take,
+// as if they were written at that time.
+//
+// "test" compares the value at the path with the value of the
+// operation, ignoring timestamps (numbers are compared by value,
+// e.g. 1 and 1.0 are equal). If the operation has a timestamp of its
+// own, the timestamp of the value (the latest one, for an object or
+// an array) must be equal too. If a test fails, ApplyPatch fails
+// with ErrTestFailed (and, as always, the document is left as it was).
+//
+
+package tsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/CAU-CPSS/logument/internal/tson"
+)
+
+// ErrTestFailed is returned when a "test" operation fails.
+var ErrTestFailed = errors.New("test failed")
+
+// NewMoveOperation creates a new "move" Operation, from the path from to path.
+func NewMoveOperation(from, path string, timestamp int64) Operation {
+	return Operation{Op: OpMove, From: from, Path: path, Timestamp: timestamp}
+}
+
+// NewCopyOperation creates a new "copy" Operation, from the path from to path.
+func NewCopyOperation(from, path string, timestamp int64) Operation {
+	return Operation{Op: OpCopy, From: from, Path: path, Timestamp: timestamp}
+}
+
+// hasTimestamp reports whether the operation has a timestamp of its own
+func hasTimestamp(op Operation) bool {
+	return op.Timestamp != 0 && op.Timestamp != tson.DefaultTimestamp
+}
+
+// applyMoveCopy applies a "move" or "copy" operation to doc
+func applyMoveCopy(doc tson.Tson, op Operation) (tson.Tson, error) {
+	from, path := splitPath(op.From), splitPath(op.Path)
+	value, err := getTraverse(doc, from)
+	if err != nil {
+		return nil, fmt.Errorf("applyMoveCopy(): Cannot %s from %s: %w", op.Op, op.From, err)
+	}
+	if hasTimestamp(op) {
+		value = tson.WithTimestamp(value, op.Timestamp)
+	}
+
+	if op.Op == OpMove {
+		if op.From == op.Path {
+			return doc, nil
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("applyMoveCopy(): Cannot move %s into its own child %s", op.From, op.Path)
+		}
+		if doc, err = applyTraverse(doc, from, Operation{Op: OpRemove, Path: op.From}); err != nil {
+			return nil, err
+		}
+	}
+	return applyTraverse(doc, path, Operation{Op: OpAdd, Path: op.Path, Value: value, Timestamp: op.Timestamp})
+}
+
+// applyTest applies a "test" operation to doc
+func applyTest(doc tson.Tson, op Operation) error {
+	actual, err := getTraverse(doc, splitPath(op.Path))
+	if err != nil {
+		return fmt.Errorf("applyTest(): %w: %s: %w", ErrTestFailed, op.Path, err)
+	}
+	expected, err := ToValue(op.Value, op.Timestamp)
+	if err != nil {
+		return fmt.Errorf("applyTest(): %w", err)
+	}
+	if !equalValues(actual, expected) {
+		return fmt.Errorf("applyTest(): %w: %s is %v, not %v", ErrTestFailed, op.Path, actual, op.Value)
+	}
+	if hasTimestamp(op) {
+		ts := tson.GetLatestTimestamp(actual)
+		if ts != op.Timestamp {
+			return fmt.Errorf("applyTest(): %w: %s has timestamp %d, not %d", ErrTestFailed, op.Path, ts, op.Timestamp)
+		}
+	}
+	return nil
+}
+
+// getTraverse returns the value at the path parts below doc
+func getTraverse(doc tson.Tson, parts []string) (tson.Value, error) {
+	for _, part := range parts {
+		switch j := doc.(type) {
+		case tson.Object:
+			child, ok := j[part]
+			if !ok {
+				return nil, fmt.Errorf("getTraverse(): Key %s not found", part)
+			}
+			doc = child
+		case tson.Array:
+			idx, err := getIndex(part)
+			if err != nil {
+				return nil, err
+			}
+			if idx >= len(j) {
+				return nil, fmt.Errorf("getTraverse(): Index %d out of range for array of length %d", idx, len(j))
+			}
+			doc = j[idx]
+		default:
+			return nil, fmt.Errorf("getTraverse(): Cannot traverse %s into %T", part, doc)
+		}
+	}
+	return doc, nil
+}
+
+// equalValues reports whether a and b are equal, ignoring timestamps.
+// Numbers are compared by value, whatever their types.
+func equalValues(a, b tson.Value) bool {
+	if va, _, ok := tson.LeafOf(a); ok {
+		vb, _, ok := tson.LeafOf(b)
+		if !ok {
+			return false
+		}
+		if na, ok := toNumber(va); ok {
+			nb, ok := toNumber(vb)
+			return ok && na.Cmp(nb) == 0
+		}
+		return va == vb
+	}
+	switch ja := a.(type) {
+	case tson.Object:
+		jb, ok := b.(tson.Object)
+		if !ok || len(ja) != len(jb) {
+			return false
+		}
+		for key, child := range ja {
+			if other, ok := jb[key]; !ok || !equalValues(child, other) {
+				return false
+			}
+		}
+		return true
+	case tson.Array:
+		jb, ok := b.(tson.Array)
+		if !ok || len(ja) != len(jb) {
+			return false
+		}
+		for i := range ja {
+			if !equalValues(ja[i], jb[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == nil && b == nil
+	}
+}
+
+// toNumber returns the number v exactly, and whether v is a number
+func toNumber(v any) (*big.Float, bool) {
+	switch n := v.(type) {
+	case int64:
+		return new(big.Float).SetInt64(n), true
+	case uint64:
+		return new(big.Float).SetUint64(n), true
+	case float64:
+		if math.IsNaN(n) {
+			return nil, false
+		}
+		return new(big.Float).SetFloat64(n), true
+	default:
+		return nil, false
+	}
+}
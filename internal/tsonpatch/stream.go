@@ -0,0 +1,41 @@
+//
+// stream.go
+//
+// Streaming TSON patches over io.Reader,
+// e.g. a multi-hour log of patch documents.
+//
+
+package tsonpatch
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Decoder reads a sequence of patch documents from an input stream.
+type Decoder struct {
+	dec *json.Decoder
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+// The Decoder buffers its input, and may read beyond the patches it decodes.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{dec: json.NewDecoder(r)}
+}
+
+// Decode reads the next patch document from the input,
+// and stores it in the Patch pointed to by p.
+// At the end of the input, it returns io.EOF.
+func (d *Decoder) Decode(p *Patch) error {
+	var patch Patch
+	if err := d.dec.Decode(&patch); err != nil {
+		return err
+	}
+	*p = patch
+	return nil
+}
+
+// More reports whether there is another patch document in the input.
+func (d *Decoder) More() bool {
+	return d.dec.More()
+}
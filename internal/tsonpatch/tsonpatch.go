@@ -80,6 +80,7 @@ func Unmarshal(b []byte) (Patch, error) {
 // Operation represents a single TSON patch operation.
 type Operation struct {
 	Op        OpType `json:"op"`
+	From      string `json:"from,omitempty"` // The source path of "move" and "copy"
 	Path      string `json:"path"`
 	Value     any    `json:"value,omitempty"`
 	Timestamp int64  `json:"timestamp"`
@@ -90,10 +91,16 @@ func (p *Operation) String() string {
 	var ( // Using vanila JSON here
 		op        = p.Op
 		path      = p.Path
-		value, _  = json.Marshal(p.Value)
+		value, _  = marshalValue(p.Value)
 		timestamp = p.Timestamp
 		buf       bytes.Buffer
 	)
+	if p.From != "" {
+		fmt.Fprintf(&buf,
+			`{ "op": "%s", "from": "%s", "path": "%s", "timestamp": %d }`,
+			op, p.From, path, timestamp)
+		return buf.String()
+	}
 	fmt.Fprintf(&buf,
 		`{ "op": "%s", "path": "%s", "value": %s, "timestamp": %d }`,
 		op, path, value, timestamp)
@@ -103,9 +110,13 @@ func (p *Operation) String() string {
 // Marshal converts the Operation to a JSON byte Array.
 func (p *Operation) Marshal() (b []byte, err error) {
 	var buf bytes.Buffer
-	fmt.Fprintf(&buf, `{ "op": "%s", "path": "%s"`, p.Op, p.Path)
+	fmt.Fprintf(&buf, `{ "op": "%s"`, p.Op)
+	if p.From != "" {
+		fmt.Fprintf(&buf, `, "from": "%s"`, p.From)
+	}
+	fmt.Fprintf(&buf, `, "path": "%s"`, p.Path)
 	if p.Value != nil || p.Op == OpReplace || p.Op == OpAdd || p.Op == OpTest {
-		if v, err := json.Marshal(p.Value); err != nil {
+		if v, err := marshalValue(p.Value); err != nil {
 			return nil, err
 		} else {
 			fmt.Fprintf(&buf, `, "value": %s`, v)
@@ -115,9 +126,149 @@ func (p *Operation) Marshal() (b []byte, err error) {
 	return buf.Bytes(), nil
 }
 
+// MarshalJSON converts the Operation to JSON.
+// A float64 value keeps a '.' (e.g. 80.0), so it is not read back as an integer.
+func (p Operation) MarshalJSON() ([]byte, error) {
+	type operation Operation // Without the methods of Operation
+	o := operation(p)
+	if p.Value != nil {
+		v, err := marshalValue(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		o.Value = json.RawMessage(v)
+	}
+	return json.Marshal(o)
+}
+
+// marshalValue marshals the value of an operation as JSON,
+// keeping a '.' in a float64 (see MarshalJSON).
+func marshalValue(value any) ([]byte, error) {
+	b, err := json.Marshal(value)
+	if _, isFloat := value.(float64); isFloat && err == nil && !bytes.ContainsAny(b, ".eE") {
+		b = append(b, ".0"...)
+	}
+	return b, err
+}
+
+// UnmarshalJSON converts a JSON operation to the Operation.
+// Integers in the value are kept exact (as int64 or uint64), as in TSON.
+func (p *Operation) UnmarshalJSON(b []byte) error {
+	type operation Operation // Without the methods of Operation
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode((*operation)(p)); err != nil {
+		return err
+	}
+	p.Value = exactNumbers(p.Value)
+	return nil
+}
+
+// exactNumbers replaces the json.Numbers in the JSON value v
+// by their values in TSON (int64, uint64 or float64).
+func exactNumbers(v any) any {
+	switch val := v.(type) {
+	case json.Number:
+		if n, err := tson.NumberOf(val); err == nil {
+			return n
+		}
+	case map[string]any:
+		for key, member := range val {
+			val[key] = exactNumbers(member)
+		}
+	case []any:
+		for i, elem := range val {
+			val[i] = exactNumbers(elem)
+		}
+	}
+	return v
+}
+
 // NewOperation creates a new Operation instance.
 func NewOperation(op OpType, path string, value any, timestamp int64) Operation {
-	return Operation{op, path, value, timestamp}
+	return Operation{Op: op, Path: path, Value: value, Timestamp: timestamp}
+}
+
+// ToLeaf converts the value of an operation to a TSON leaf with the given timestamp.
+// Integers are kept exact (as int64 or uint64), and nil is a null leaf.
+func ToLeaf(value any, timestamp int64) (tson.Value, error) {
+	leaf, err := tson.NewLeaf(value, timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("ToLeaf(): Unsupported value type %T: %v", value, value)
+	}
+	return leaf, nil
+}
+
+// ToValue converts the value of an operation to a TSON value with the given timestamp.
+// Besides the primitives of ToLeaf, the value may be a TSON value (which is copied),
+// or a JSON object or array. The leaves of a JSON value written as
+// { "value": ..., "timestamp": ... } (e.g. a TSON value marshalled as JSON)
+// keep their timestamps, and its other primitives take the given timestamp.
+func ToValue(value any, timestamp int64) (tson.Value, error) {
+	switch v := value.(type) {
+	case tson.Object, tson.Array:
+		return tson.Clone(v.(tson.Value))
+	case tson.Value:
+		if _, _, ok := tson.LeafOf(v); ok {
+			return v, nil
+		}
+	case map[string]any:
+		if val, ts, ok := compatibleLeaf(v); ok {
+			return ToLeaf(val, ts)
+		}
+		obj := make(tson.Object, len(v))
+		for key, member := range v {
+			var err error
+			if obj[key], err = ToValue(member, timestamp); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	case []any:
+		arr := make(tson.Array, len(v))
+		for i, elem := range v {
+			var err error
+			if arr[i], err = ToValue(elem, timestamp); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return ToLeaf(value, timestamp)
+}
+
+// compatibleLeaf returns the value and the timestamp of a leaf
+// written as { "value": ..., "timestamp": ... }, and whether m is one.
+func compatibleLeaf(m map[string]any) (value any, timestamp int64, ok bool) {
+	if len(m) != 2 {
+		return nil, 0, false
+	}
+	value, hasValue := m["value"]
+	switch ts := m["timestamp"].(type) {
+	case int64:
+		return value, ts, hasValue
+	case float64:
+		return value, int64(ts), hasValue
+	case json.Number:
+		t, err := ts.Int64()
+		return value, t, hasValue && err == nil
+	default:
+		return nil, 0, false
+	}
+}
+
+// opValue returns the value and the timestamp of v for an operation:
+// the primitive and the timestamp of a leaf, or an object or array
+// itself (with its latest timestamp).
+func opValue(v tson.Value) (value any, timestamp int64) {
+	if value, timestamp, ok := tson.LeafOf(v); ok {
+		return value, timestamp
+	}
+	if v == nil {
+		return nil, tson.DefaultTimestamp
+	}
+	c, _ := tson.Clone(v) // The patch does not share the document
+	return c, tson.GetLatestTimestamp(v)
 }
 
 // GeneratePatch generates a JSON patch from two TSON documents
@@ -138,207 +289,171 @@ func GeneratePatchWithTimestamp(origin, modified tson.Tson) (Patch, error) {
 	return handleValuesWithTimestamp(origin, modified, "", Patch{})
 }
 
-// ApplyPatch applies a JSON patch to a TSON document
+// ApplyPatch applies a JSON patch to a TSON document, and returns the updated document.
+//
+// doc is not modified: the objects and arrays on the paths of the patch
+// are copied, and the updated document shares the rest with doc
+// (copy on write). The patch is applied all or nothing: if an operation
+// fails (e.g. a "test"), the error is returned, and doc is left as it was.
 func ApplyPatch(doc tson.Tson, patch Patch) (t tson.Tson, err error) {
 	for _, op := range patch {
-		if t, err = ApplyOperation(doc, op); err != nil {
+		if doc, err = ApplyOperation(doc, op); err != nil {
 			return nil, err
 		}
 	}
-	return t, nil
+	return doc, nil
 }
 
+// ApplyOperation applies an operation to a TSON document, and returns the
+// updated document. As with ApplyPatch, doc is not modified.
 func ApplyOperation(doc tson.Tson, op Operation) (t tson.Tson, err error) {
-	path := rfc6901Decoder.Replace(op.Path)
-
-	// Split the path into parts, ignoring the first empty string
-	path = strings.ReplaceAll(path, ".", "/")
-	parts := strings.Split(path, "/")
+	switch op.Op {
+	case OpMove, OpCopy:
+		return applyMoveCopy(doc, op)
+	case OpTest:
+		if err := applyTest(doc, op); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	}
 
 	// Traverse the TSON document
-	if t, err = applyTraverse(doc, parts, op); err != nil {
+	if t, err = applyTraverse(doc, splitPath(op.Path), op); err != nil {
 		return nil, err
 	}
 	return t, nil
 }
 
-// applyTraverse 함수 수정
+// splitPath splits a path into its parts, ignoring the first empty string.
+func splitPath(path string) []string {
+	path = rfc6901Decoder.Replace(path)
+	path = strings.ReplaceAll(path, ".", "/")
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+// applyTraverse applies op at the path parts below doc, and returns the updated doc.
+// The objects and arrays on the path are copied, not modified.
+//
+// Object members are set by "add" and "replace", and deleted by "remove".
+// Array elements follow RFC 6902: "add" inserts before the index
+// (or appends for "-"), shifting the following elements,
+// "replace" overwrites the element, and "remove" deletes it,
+// shifting the following elements back.
 func applyTraverse(doc tson.Tson, parts []string, op Operation) (t tson.Tson, err error) {
 	if len(parts) == 0 { // If the path is empty, return
 		return doc, nil
 	}
 
-	switch doc.(type) { // If doc is a leaf node, return
-	case tson.Leaf[string], tson.Leaf[float64], tson.Leaf[bool]:
-		return doc, nil
-	}
-
 	switch part := parts[0]; j := doc.(type) {
 	case tson.Object:
 		if len(parts) == 1 { // Only a single part of path left
+			c := cloneObject(j)
 			switch op.Op { // switch by operation type
 			case OpAdd, OpReplace:
-				// 타입 변환 로직 개선
-				switch value := op.Value.(type) {
-				case string:
-					j[part] = tson.Leaf[string]{Value: value, Timestamp: op.Timestamp}
-				case float64:
-					j[part] = tson.Leaf[float64]{Value: value, Timestamp: op.Timestamp}
-				case int:
-					// int를 float64로 변환
-					j[part] = tson.Leaf[float64]{Value: float64(value), Timestamp: op.Timestamp}
-				case bool:
-					j[part] = tson.Leaf[bool]{Value: value, Timestamp: op.Timestamp}
-				case nil:
-					// nil 값 처리 (필요한 경우)
-					// 예: j[part] = tson.Leaf[string]{Value: "", Timestamp: op.Timestamp}
-					return nil, fmt.Errorf("applyTraverse(): Nil value not supported")
-				default:
-					// 다른 타입 처리 (예: map, slice 등)
-					return nil, fmt.Errorf("applyTraverse(): Unsupported value type %T: %v", op.Value, op.Value)
+				if c[part], err = ToValue(op.Value, op.Timestamp); err != nil {
+					return nil, fmt.Errorf("applyTraverse(): %w", err)
 				}
 			case OpRemove:
-				delete(j, part)
-			case OpMove, OpCopy, OpTest:
-				panic(fmt.Sprintf("applyTraverse(): Operation %s not implemented", op.Op))
+				delete(c, part)
 			default:
 				return nil, fmt.Errorf("applyTraverse(): Unknown operation %s", op.Op)
 			}
-			return j, nil
+			return c, nil
 		}
 
-		switch value := j[part].(type) { // Recursively traverse the TSON document
-		case tson.Leaf[string], tson.Leaf[float64], tson.Leaf[bool]:
-			panic("applyTraverse(): Leaf[T] should not be here")
-		case tson.Object:
-			if j[part], err = applyTraverse(value, parts[1:], op); err != nil {
-				return nil, err
-			}
-		case tson.Array:
-			idx, err := getIndex(parts[1])
-			if err != nil {
-				return nil, err
-			}
-
-			switch op.Op {
-			case OpAdd, OpReplace:
-				if idx >= len(value) {
-					// 배열 크기가 충분하지 않은 경우 확장
-					newArray := make(tson.Array, idx+1)
-					copy(newArray, value)
-					value = newArray
-					j[part] = value
-				}
-
-				// 타입 변환 로직 개선
-				switch opValue := op.Value.(type) {
-				case string:
-					value[idx] = tson.Leaf[string]{Value: opValue, Timestamp: op.Timestamp}
-				case float64:
-					value[idx] = tson.Leaf[float64]{Value: opValue, Timestamp: op.Timestamp}
-				case int:
-					value[idx] = tson.Leaf[float64]{Value: float64(opValue), Timestamp: op.Timestamp}
-				case bool:
-					value[idx] = tson.Leaf[bool]{Value: opValue, Timestamp: op.Timestamp}
-				case nil:
-					return nil, fmt.Errorf("applyTraverse(): Nil value not supported for array element")
-				default:
-					if elem, ok := value[idx].(tson.Object); ok || elem == nil {
-						// 객체나 nil인 경우 재귀적으로 처리
-						if j[part], err = applyTraverse(value[idx], parts[1:], op); err != nil {
-							return nil, err
-						}
-					} else {
-						return nil, fmt.Errorf("applyTraverse(): Unsupported array element type %T", op.Value)
-					}
-				}
-			case OpRemove:
-				j[part] = append(value[:idx], value[idx+1:]...)
-			default:
-				return nil, fmt.Errorf("applyTraverse(): Unknown operation %s", op.Op)
-			}
-		case nil:
+		child, ok := j[part]
+		if !ok || child == nil {
 			// 경로가 없는 경우 새로 생성
-			if op.Op == OpAdd || op.Op == OpReplace {
-				// 다음 부분이 숫자면 배열, 아니면 객체 생성
-				_, err := getIndex(parts[1])
-				if err == nil {
-					// 배열 생성
-					newArray := make(tson.Array, 0)
-					j[part] = newArray
-					if j[part], err = applyTraverse(newArray, parts[1:], op); err != nil {
-						return nil, err
-					}
-				} else {
-					// 객체 생성
-					newObj := make(tson.Object)
-					j[part] = newObj
-					if j[part], err = applyTraverse(newObj, parts[1:], op); err != nil {
-						return nil, err
-					}
-				}
-			} else {
+			if op.Op != OpAdd && op.Op != OpReplace {
 				return nil, fmt.Errorf("applyTraverse(): Cannot %s on nil value at %s", op.Op, part)
 			}
-		default:
-			return nil, fmt.Errorf("traverse(): Unknown type %T for value", value)
+			// 다음 부분이 숫자면 배열, 아니면 객체 생성
+			if _, err := getIndex(parts[1]); err == nil || parts[1] == "-" {
+				child = tson.Array{}
+			} else {
+				child = tson.Object{}
+			}
 		}
-
-		return j, nil
-	case tson.Array:
-		// 배열 처리 로직...
-		idx, err := getIndex(part)
-		if err != nil {
+		if child, err = applyTraverse(child, parts[1:], op); err != nil {
 			return nil, err
 		}
-
-		// 배열 인덱스 범위 체크 및 확장
-		if idx >= len(j) {
-			if op.Op == OpAdd || op.Op == OpReplace {
-				// 배열 확장
-				newArray := make(tson.Array, idx+1)
-				copy(newArray, j)
-				j = newArray
-			} else {
-				return nil, fmt.Errorf("applyTraverse(): Index %d out of range for array of length %d", idx, len(j))
+		c := cloneObject(j)
+		c[part] = child
+		return c, nil
+	case tson.Array:
+		idx := len(j) // "-" refers to the end of the array
+		if part != "-" {
+			if idx, err = getIndex(part); err != nil {
+				return nil, err
 			}
 		}
 
 		if len(parts) == 1 {
 			// 마지막 부분이면 직접 값 설정
 			switch op.Op {
-			case OpAdd, OpReplace:
-				// 타입 변환 로직
-				switch value := op.Value.(type) {
-				case string:
-					j[idx] = tson.Leaf[string]{Value: value, Timestamp: op.Timestamp}
-				case float64:
-					j[idx] = tson.Leaf[float64]{Value: value, Timestamp: op.Timestamp}
-				case int:
-					j[idx] = tson.Leaf[float64]{Value: float64(value), Timestamp: op.Timestamp}
-				case bool:
-					j[idx] = tson.Leaf[bool]{Value: value, Timestamp: op.Timestamp}
-				default:
-					return nil, fmt.Errorf("applyTraverse(): Unsupported value type %T for array element", op.Value)
+			case OpAdd:
+				if idx > len(j) {
+					return nil, fmt.Errorf("applyTraverse(): Index %d out of range for array of length %d", idx, len(j))
+				}
+				leaf, err := ToValue(op.Value, op.Timestamp)
+				if err != nil {
+					return nil, fmt.Errorf("applyTraverse(): %w", err)
 				}
+				c := make(tson.Array, 0, len(j)+1)
+				c = append(c, j[:idx]...)
+				c = append(c, leaf)
+				return append(c, j[idx:]...), nil
+			case OpReplace:
+				leaf, err := ToValue(op.Value, op.Timestamp)
+				if err != nil {
+					return nil, fmt.Errorf("applyTraverse(): %w", err)
+				}
+				// 배열 크기가 충분하지 않은 경우 확장
+				c := make(tson.Array, max(len(j), idx+1))
+				copy(c, j)
+				c[idx] = leaf
+				return c, nil
 			case OpRemove:
-				j = append(j[:idx], j[idx+1:]...)
+				if idx >= len(j) {
+					return nil, fmt.Errorf("applyTraverse(): Index %d out of range for array of length %d", idx, len(j))
+				}
+				c := make(tson.Array, 0, len(j)-1)
+				c = append(c, j[:idx]...)
+				return append(c, j[idx+1:]...), nil
 			default:
 				return nil, fmt.Errorf("applyTraverse(): Unknown operation %s", op.Op)
 			}
-		} else {
-			// 중간 부분이면 재귀 호출
-			if j[idx], err = applyTraverse(j[idx], parts[1:], op); err != nil {
-				return nil, err
-			}
 		}
 
-		return j, nil
+		// 중간 부분이면 재귀 호출
+		if idx >= len(j) {
+			return nil, fmt.Errorf("applyTraverse(): Index %d out of range for array of length %d", idx, len(j))
+		}
+		child, err := applyTraverse(j[idx], parts[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		c := make(tson.Array, len(j))
+		copy(c, j)
+		c[idx] = child
+		return c, nil
 	default:
+		if _, _, ok := tson.LeafOf(doc); ok {
+			return nil, fmt.Errorf("applyTraverse(): Cannot traverse %s into leaf %v", part, doc)
+		}
 		return nil, fmt.Errorf("applyTraverse(): Unknown type %T for doc", doc)
 	}
 }
 
+// cloneObject returns a shallow copy of the object o, sharing its values
+func cloneObject(o tson.Object) tson.Object {
+	c := make(tson.Object, len(o)+1)
+	for key, value := range o {
+		c[key] = value
+	}
+	return c
+}
+
 // func applyTraverse(doc tson.Tson, parts []string, op Operation) (t tson.Tson, err error) {
 // 	if len(parts) == 0 { // If the path is empty, return
 // 		return doc, nil
@@ -425,7 +540,7 @@ func applyTraverse(doc tson.Tson, parts []string, op Operation) (t tson.Tson, er
 // }
 
 func getIndex(part string) (idx int, err error) {
-	if idx, err = strconv.Atoi(part); err != nil {
+	if idx, err = strconv.Atoi(part); err != nil || idx < 0 {
 		return -1, fmt.Errorf("getIndex(): Invalid index %s", part)
 	}
 	return idx, nil
@@ -449,46 +564,25 @@ func makePath(path string, newPart any) string {
 }
 
 // diff returns the (recursive) difference between a and b.
-func diff(origin, modified tson.Object, path string, patch Patch, withTimestamp bool) (Patch, error) {
+func diff(origin, modified tson.Object, path string, patch Patch, opts DiffOptions) (Patch, error) {
 	for key, modValue := range modified {
 		p := makePath(path, key)
 		origValue, ok := origin[key]
 		// "add": Only exists in 'modified'
 		if !ok {
-			switch modLeaf := modValue.(type) {
-			case tson.Leaf[string]:
-				patch = append(patch, NewOperation(OpAdd, p, modLeaf.Value, modLeaf.Timestamp))
-			case tson.Leaf[float64]:
-				patch = append(patch, NewOperation(OpAdd, p, modLeaf.Value, modLeaf.Timestamp))
-			case tson.Leaf[bool]:
-				patch = append(patch, NewOperation(OpAdd, p, modLeaf.Value, modLeaf.Timestamp))
-			default:
-				return nil, fmt.Errorf("diff(): Unknown type %T for modValue", modValue)
-			}
+			value, ts := opValue(modValue)
+			patch = append(patch, NewOperation(OpAdd, p, value, ts))
 			continue
 		}
 		// "replace": Type has changed
 		if reflect.TypeOf(origValue) != reflect.TypeOf(modValue) {
-			switch modLeaf := modValue.(type) {
-			case tson.Leaf[string]:
-				patch = append(patch, NewOperation(OpReplace, p, modLeaf.Value, modLeaf.Timestamp))
-			case tson.Leaf[float64]:
-				patch = append(patch, NewOperation(OpReplace, p, modLeaf.Value, modLeaf.Timestamp))
-			case tson.Leaf[bool]:
-				patch = append(patch, NewOperation(OpReplace, p, modLeaf.Value, modLeaf.Timestamp))
-			default:
-				return nil, fmt.Errorf("diff(): Unknown type %T for modValue", modValue)
-			}
+			value, ts := opValue(modValue)
+			patch = append(patch, NewOperation(OpReplace, p, value, ts))
 			continue
 		}
 		// Types are the same, compare values
 		var err error
-		if withTimestamp {
-			patch, err = handleValuesWithTimestamp(origValue, modValue, p, patch)
-		} else {
-			patch, err = handleValues(origValue, modValue, p, patch)
-		}
-		if err != nil {
+		if patch, err = handleValuesWith(origValue, modValue, p, patch, opts); err != nil {
 			return nil, err
 		}
 	}
@@ -496,185 +590,71 @@ func diff(origin, modified tson.Object, path string, patch Patch, withTimestamp
 	for key := range origin {
 		_, found := modified[key]
 		if !found {
-			p := makePath(path, key)
-			origValue := origin[key]
-			switch origLeaf := origValue.(type) {
-			case tson.Leaf[string]:
-				patch = append(patch, NewOperation(OpRemove, p, nil, origLeaf.Timestamp))
-			case tson.Leaf[float64]:
-				patch = append(patch, NewOperation(OpRemove, p, nil, origLeaf.Timestamp))
-			case tson.Leaf[bool]:
-				patch = append(patch, NewOperation(OpRemove, p, nil, origLeaf.Timestamp))
-			case tson.Object:
-				// TODO: is there a way to calculate the timestamp?
-				patch = append(patch, NewOperation(OpRemove, p, nil, 0))
-			case tson.Array:
-				patch = append(patch, NewOperation(OpRemove, p, nil, 0))
-			default:
-				panic(fmt.Sprintf("diff(): Unknown type %T for origValue", origValue))
-			}
+			patch = append(patch, NewOperation(OpRemove, makePath(path, key), nil, removedTimestamp(origin[key])))
 		}
 	}
 	return patch, nil
 }
 
-func handleValues(origValue, modValue tson.Value, path string, patch Patch) (Patch, error) {
-	var err error
-	switch origin := origValue.(type) {
-	case tson.Leaf[string]:
-		if !matchesValue(origValue, modValue) {
-			modified := modValue.(tson.Leaf[string])
-			patch = append(patch, NewOperation(OpReplace, path, modified.Value, modified.Timestamp))
-		}
-	case tson.Leaf[float64]:
-		if !matchesValue(origValue, modValue) {
-			modified := modValue.(tson.Leaf[float64])
-			patch = append(patch, NewOperation(OpReplace, path, modified.Value, modified.Timestamp))
-		}
-	case tson.Leaf[bool]:
-		if !matchesValue(origValue, modValue) {
-			modified := modValue.(tson.Leaf[bool])
-			patch = append(patch, NewOperation(OpReplace, path, modified.Value, modified.Timestamp))
-		}
-	case tson.Object:
-		modified := modValue.(tson.Object)
-		if patch, err = diff(origin, modified, path, patch, false); err != nil {
-			return nil, err
-		}
-	case tson.Array:
-		modified, ok := modValue.(tson.Array)
-		if !ok { // tson.Array replaced by non-Array
-			var mod any = modValue
-			switch modLeaf := mod.(type) {
-			case tson.Leaf[string]:
-				patch = append(patch, NewOperation(OpReplace, path, modLeaf.Value, modLeaf.Timestamp))
-			case tson.Leaf[float64]:
-				patch = append(patch, NewOperation(OpReplace, path, modLeaf.Value, modLeaf.Timestamp))
-			case tson.Leaf[bool]:
-				patch = append(patch, NewOperation(OpReplace, path, modLeaf.Value, modLeaf.Timestamp))
-			default:
-				return nil, fmt.Errorf("handleValues(): Unknown type %T for modValue", modValue)
-			}
-		} else if len(origin) != len(modified) { // Different array lengths
-			patch = append(patch, compareArray(origin, modified, path)...)
-		} else { // Same length, compare elements
-			for i := range modified {
-				patch, err = handleValues(origin[i], modified[i], makePath(path, i), patch)
-				if err != nil {
-					return nil, err
-				}
-			}
-		}
-	case nil:
-		switch modValue.(type) {
-		case nil:
-			// Both nil, fine.
-		default:
-			// Replace nil with value
-			var mod any = modValue
-			switch modLeaf := mod.(type) {
-			case tson.Leaf[string]:
-				patch = append(patch, NewOperation(OpAdd, path, modLeaf.Value, modLeaf.Timestamp))
-			case tson.Leaf[float64]:
-				patch = append(patch, NewOperation(OpAdd, path, modLeaf.Value, modLeaf.Timestamp))
-			case tson.Leaf[bool]:
-				patch = append(patch, NewOperation(OpAdd, path, modLeaf.Value, modLeaf.Timestamp))
-			default:
-				return nil, fmt.Errorf("handleValues(): Unknown type %T for modValue", modValue)
-			}
-		}
-	default:
-		return nil, fmt.Errorf("handleValues(): Unknown type %T for origValue", origValue)
+// removedTimestamp returns the timestamp of the "remove" operation of v:
+// the timestamp of a leaf, or 0 for an object or an array.
+func removedTimestamp(v tson.Value) int64 {
+	if _, ts, ok := tson.LeafOf(v); ok {
+		return ts
 	}
-	return patch, nil
+	// TODO: is there a way to calculate the timestamp?
+	return 0
+}
+
+func handleValues(origValue, modValue tson.Value, path string, patch Patch) (Patch, error) {
+	return handleValuesWith(origValue, modValue, path, patch, DiffOptions{})
 }
 
 // handleValuesWithTimestamp는 타임스탬프 변경도 감지하여 패치를 생성합니다.
 func handleValuesWithTimestamp(origValue, modValue tson.Value, path string, patch Patch) (Patch, error) {
-	var err error
-
-	// 타임스탬프 변경 감지
-	timestampChanged := !matchTimestamp(origValue, modValue)
+	return handleValuesWith(origValue, modValue, path, patch, DiffOptions{WithTimestamp: true})
+}
 
-	switch origin := origValue.(type) {
-	case tson.Leaf[string]:
-		valuesEqual := matchesValue(origValue, modValue)
-		modified := modValue.(tson.Leaf[string])
+// handleValuesWith compares origValue and modValue at path, and appends the operations
+// to patch. If opts.WithTimestamp is set, leaves with a new timestamp are replaced too.
+func handleValuesWith(origValue, modValue tson.Value, path string, patch Patch, opts DiffOptions) (Patch, error) {
+	var err error
+	replace := func() {
+		value, ts := opValue(modValue)
+		patch = append(patch, NewOperation(OpReplace, path, value, ts))
+	}
 
+	if _, _, ok := tson.LeafOf(origValue); ok {
 		// 값이 다르거나 타임스탬프가 다르면 패치 생성
-		if !valuesEqual || timestampChanged {
-			patch = append(patch, NewOperation(OpReplace, path, modified.Value, modified.Timestamp))
-		}
-
-	case tson.Leaf[float64]:
-		valuesEqual := matchesValue(origValue, modValue)
-		modified := modValue.(tson.Leaf[float64])
-
-		if !valuesEqual || timestampChanged {
-			patch = append(patch, NewOperation(OpReplace, path, modified.Value, modified.Timestamp))
-		}
-
-	case tson.Leaf[bool]:
-		valuesEqual := matchesValue(origValue, modValue)
-		modified := modValue.(tson.Leaf[bool])
-
-		if !valuesEqual || timestampChanged {
-			patch = append(patch, NewOperation(OpReplace, path, modified.Value, modified.Timestamp))
+		if !matchesValue(origValue, modValue) || opts.WithTimestamp && !matchTimestamp(origValue, modValue) {
+			replace()
 		}
+		return patch, nil
+	}
 
+	switch origin := origValue.(type) {
 	case tson.Object:
-		modified := modValue.(tson.Object)
-		if patch, err = diff(origin, modified, path, patch, true); err != nil {
+		modified, ok := modValue.(tson.Object)
+		if !ok { // tson.Object replaced by non-Object
+			replace()
+		} else if patch, err = diff(origin, modified, path, patch, opts); err != nil {
 			return nil, err
 		}
-
 	case tson.Array:
 		modified, ok := modValue.(tson.Array)
 		if !ok { // tson.Array replaced by non-Array
-			var mod any = modValue
-			switch modLeaf := mod.(type) {
-			case tson.Leaf[string]:
-				patch = append(patch, NewOperation(OpReplace, path, modLeaf.Value, modLeaf.Timestamp))
-			case tson.Leaf[float64]:
-				patch = append(patch, NewOperation(OpReplace, path, modLeaf.Value, modLeaf.Timestamp))
-			case tson.Leaf[bool]:
-				patch = append(patch, NewOperation(OpReplace, path, modLeaf.Value, modLeaf.Timestamp))
-			default:
-				return nil, fmt.Errorf("handleValuesWithTimestamp(): Unknown type %T for modValue", modValue)
-			}
-		} else if len(origin) != len(modified) { // Different array lengths
-			patch = append(patch, compareArrayWithTimestamp(origin, modified, path)...)
-		} else { // Same length, compare elements
-			for i := range modified {
-				patch, err = handleValuesWithTimestamp(origin[i], modified[i], makePath(path, i), patch)
-				if err != nil {
-					return nil, err
-				}
-			}
+			replace()
+		} else if patch, err = diffArray(origin, modified, path, patch, opts); err != nil { // See arraydiff.go
+			return nil, err
 		}
-
 	case nil:
-		switch modValue.(type) {
-		case nil:
-			// Both nil, fine.
-		default:
-			// Replace nil with value
-			var mod any = modValue
-			switch modLeaf := mod.(type) {
-			case tson.Leaf[string]:
-				patch = append(patch, NewOperation(OpAdd, path, modLeaf.Value, modLeaf.Timestamp))
-			case tson.Leaf[float64]:
-				patch = append(patch, NewOperation(OpAdd, path, modLeaf.Value, modLeaf.Timestamp))
-			case tson.Leaf[bool]:
-				patch = append(patch, NewOperation(OpAdd, path, modLeaf.Value, modLeaf.Timestamp))
-			default:
-				return nil, fmt.Errorf("handleValuesWithTimestamp(): Unknown type %T for modValue", modValue)
-			}
+		if modValue != nil { // Replace nil with value
+			value, ts := opValue(modValue)
+			patch = append(patch, NewOperation(OpAdd, path, value, ts))
 		}
 	default:
-		return nil, fmt.Errorf("handleValuesWithTimestamp(): Unknown type %T for origValue", origValue)
+		return nil, fmt.Errorf("handleValues(): Unknown type %T for origValue", origValue)
 	}
-
 	return patch, nil
 }
 
@@ -684,19 +664,11 @@ func matchesValue(origin, modified tson.Value) bool {
 		return false
 	}
 
+	if org, _, ok := tson.LeafOf(origin); ok {
+		mod, _, _ := tson.LeafOf(modified)
+		return org == mod
+	}
 	switch org := origin.(type) {
-	case tson.Leaf[string]:
-		if modified.(tson.Leaf[string]).Value == org.Value {
-			return true
-		}
-	case tson.Leaf[float64]:
-		if modified.(tson.Leaf[float64]).Value == org.Value {
-			return true
-		}
-	case tson.Leaf[bool]:
-		if modified.(tson.Leaf[bool]).Value == org.Value {
-			return true
-		}
 	case tson.Object:
 		modObj := modified.(tson.Object)
 		for key := range org {
@@ -720,11 +692,6 @@ func matchesValue(origin, modified tson.Value) bool {
 				return false
 			}
 		}
-		for key := range modArray {
-			if !matchesValue(org[key], modArray[key]) {
-				return false
-			}
-		}
 		return true
 	}
 	return false
@@ -736,16 +703,11 @@ func matchTimestamp(origin, modified tson.Value) bool {
 		return false
 	}
 
+	if _, org, ok := tson.LeafOf(origin); ok {
+		_, mod, _ := tson.LeafOf(modified)
+		return org == mod
+	}
 	switch org := origin.(type) {
-	case tson.Leaf[string]:
-		mod := modified.(tson.Leaf[string])
-		return org.Timestamp == mod.Timestamp
-	case tson.Leaf[float64]:
-		mod := modified.(tson.Leaf[float64])
-		return org.Timestamp == mod.Timestamp
-	case tson.Leaf[bool]:
-		mod := modified.(tson.Leaf[bool])
-		return org.Timestamp == mod.Timestamp
 	case tson.Object:
 		modObj := modified.(tson.Object)
 		for key := range org {
@@ -769,116 +731,7 @@ func matchTimestamp(origin, modified tson.Value) bool {
 				return false
 			}
 		}
-		for key := range modArray {
-			if !matchTimestamp(org[key], modArray[key]) {
-				return false
-			}
-		}
 		return true
 	}
 	return false
 }
-
-// compareArray generates remove and add operations
-func compareArray(origArr, modArr tson.Array, p string) (patch Patch) {
-	// Find elements that need to be removed
-	processArray(origArr, modArr, func(i int, _ any) {
-		// TODO: is there a way to calculate the timestamp?
-		patch = append(patch, NewOperation(OpRemove, makePath(p, i), nil, 0))
-	})
-
-	reversed := make(Patch, len(patch))
-	for i := 0; i < len(patch); i++ {
-		reversed[len(patch)-1-i] = patch[i]
-	}
-	patch = reversed
-
-	// Find elements that need to be added.
-	processArray(modArr, origArr, func(i int, value any) {
-		switch leaf := value.(type) {
-		case tson.Leaf[string]:
-			patch = append(patch, NewOperation(OpAdd, makePath(p, i), leaf.Value, leaf.Timestamp))
-		case tson.Leaf[float64]:
-			patch = append(patch, NewOperation(OpAdd, makePath(p, i), leaf.Value, leaf.Timestamp))
-		case tson.Leaf[bool]:
-			patch = append(patch, NewOperation(OpAdd, makePath(p, i), leaf.Value, leaf.Timestamp))
-		default:
-			panic(fmt.Sprintf("compareArray(): Unknown type %T for value", value))
-		}
-	})
-	return patch
-}
-
-// compareArrayWithTimestamp는 두 배열을 비교하여 패치를 생성합니다. 타임스탬프 변경도 감지합니다.
-func compareArrayWithTimestamp(origArr, modArr tson.Array, p string) (patch Patch) {
-	// Find elements that need to be removed
-	processArray(origArr, modArr, func(i int, _ any) {
-		// TODO: is there a way to calculate the timestamp?
-		patch = append(patch, NewOperation(OpRemove, makePath(p, i), nil, 0))
-	})
-
-	reversed := make(Patch, len(patch))
-	for i := 0; i < len(patch); i++ {
-		reversed[len(patch)-1-i] = patch[i]
-	}
-	patch = reversed
-
-	// Find elements that need to be added, or have changed values or timestamps
-	for i, value := range modArr {
-		// 원래 배열과 수정된 배열의 같은 인덱스에서 값 또는 타임스탬프 변경 확인
-		if i < len(origArr) {
-			valuesEqual := matchesValue(origArr[i], value)
-			timestampEqual := matchTimestamp(origArr[i], value)
-
-			if !valuesEqual || !timestampEqual {
-				switch leaf := value.(type) {
-				case tson.Leaf[string]:
-					patch = append(patch, NewOperation(OpReplace, makePath(p, i), leaf.Value, leaf.Timestamp))
-				case tson.Leaf[float64]:
-					patch = append(patch, NewOperation(OpReplace, makePath(p, i), leaf.Value, leaf.Timestamp))
-				case tson.Leaf[bool]:
-					patch = append(patch, NewOperation(OpReplace, makePath(p, i), leaf.Value, leaf.Timestamp))
-				}
-			}
-		} else {
-			// 새로 추가된 요소
-			switch leaf := value.(type) {
-			case tson.Leaf[string]:
-				patch = append(patch, NewOperation(OpAdd, makePath(p, i), leaf.Value, leaf.Timestamp))
-			case tson.Leaf[float64]:
-				patch = append(patch, NewOperation(OpAdd, makePath(p, i), leaf.Value, leaf.Timestamp))
-			case tson.Leaf[bool]:
-				patch = append(patch, NewOperation(OpAdd, makePath(p, i), leaf.Value, leaf.Timestamp))
-			default:
-				panic(fmt.Sprintf("compareArrayWithTimestamp(): Unknown type %T for value", value))
-			}
-		}
-	}
-
-	return patch
-}
-
-// processArray processes two arrays calling applyOp whenever a value is absent.
-func processArray(origArr, modArr tson.Array, applyOp func(i int, value any)) {
-	// Note: map[T]struct{} is used to simulate a set.
-	foundIndexes := make(map[int]struct{}, len(origArr))
-	reverseFoundIndexes := make(map[int]struct{}, len(origArr))
-
-	for idx1, value1 := range origArr {
-		for idx2, value2 := range modArr {
-			if _, ok := reverseFoundIndexes[idx2]; ok {
-				// This one is already found.
-				continue
-			}
-			if reflect.DeepEqual(value1, value2) {
-				// Mark this as found
-				foundIndexes[idx1] = struct{}{}
-				reverseFoundIndexes[idx2] = struct{}{}
-				break
-			}
-		}
-		if _, ok := foundIndexes[idx1]; !ok {
-			applyOp(idx1, value1)
-		}
-	}
-}
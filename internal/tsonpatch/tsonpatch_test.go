@@ -9,7 +9,12 @@
 package tsonpatch
 
 import (
+	"encoding/json"
+	"io"
+	"math"
+	"math/rand"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/CAU-CPSS/logument/internal/tson"
@@ -95,3 +100,517 @@ func TestApplyPatchWithJson(t *testing.T) {
 	ret, _ := tson.EqualWithoutTimestamp(doc, newDoc)
 	assert.Equal(t, true, ret)
 }
+
+func TestApplyPatchCopyOnWrite(t *testing.T) {
+	var doc tson.Tson
+	assert.Nil(t, tson.Unmarshal([]byte(`{
+		"speed" <1>: 80.0,
+		"location": { "latitude" <1>: 37.5, "longitude" <1>: 127.0 },
+		"tirePressure": [ <1> 32.0, <1> 31.8 ]
+	}`), &doc))
+	origin, _ := tson.Clone(doc)
+
+	// doc is not modified, and shares the values the patch does not change
+	updated, err := ApplyPatch(doc, Patch{
+		NewOperation(OpReplace, "/location/latitude", 38.0, 2),
+		NewOperation(OpAdd, "/tirePressure/0", 30.0, 2),
+		NewOperation(OpRemove, "/speed", nil, 2),
+	})
+	assert.Nil(t, err)
+	eq, err := tson.Equal(doc, origin)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+	assert.Len(t, updated.(tson.Object)["tirePressure"], 3)
+	assert.NotContains(t, updated, "speed")
+
+	// A patch is applied all or nothing
+	_, err = ApplyPatch(doc, Patch{
+		NewOperation(OpReplace, "/speed", 90.0, 3),
+		NewOperation(OpRemove, "/tirePressure/5", nil, 3),
+	})
+	assert.NotNil(t, err)
+	eq, err = tson.Equal(doc, origin)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+}
+
+func TestToLeaf(t *testing.T) {
+	leaf, err := ToLeaf(42, 1700000000)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[int64]{Value: 42, Timestamp: 1700000000}, leaf)
+
+	leaf, err = ToLeaf(uint64(math.MaxUint64), 1700000000)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[uint64]{Value: math.MaxUint64, Timestamp: 1700000000}, leaf)
+
+	leaf, err = ToLeaf(nil, 1700000000)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[tson.Null]{Timestamp: 1700000000}, leaf)
+
+	leaf, err = ToLeaf("ABC1234", 1700000000)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Leaf[string]{Value: "ABC1234", Timestamp: 1700000000}, leaf)
+
+	_, err = ToLeaf(complex(1, 2), 1700000000)
+	assert.NotNil(t, err)
+}
+
+func TestApplyArrayOperations(t *testing.T) {
+	var doc tson.Tson
+	assert.Nil(t, tson.Unmarshal([]byte(`{ "a": [ <1> 1.0, <1> 2.0 ] }`), &doc))
+
+	p, err := Unmarshal([]byte(`[
+		{ "op": "add", "path": "/a/0", "value": 0.0, "timestamp": 2 },
+		{ "op": "add", "path": "/a/-", "value": 3.0, "timestamp": 2 },
+		{ "op": "remove", "path": "/a/1", "timestamp": 3 },
+		{ "op": "replace", "path": "/a/1", "value": 4.0, "timestamp": 3 }
+	]`))
+	assert.Nil(t, err)
+	doc, err = ApplyPatch(doc, p)
+	assert.Nil(t, err)
+	assert.Equal(t, tson.Array{
+		tson.Leaf[float64]{Value: 0.0, Timestamp: 2},
+		tson.Leaf[float64]{Value: 4.0, Timestamp: 3},
+		tson.Leaf[float64]{Value: 3.0, Timestamp: 2},
+	}, doc.(tson.Object)["a"])
+
+	// Out of range, or below a leaf
+	_, err = ApplyOperation(doc, NewOperation(OpAdd, "/a/4", 5.0, 4))
+	assert.NotNil(t, err)
+	_, err = ApplyOperation(doc, NewOperation(OpRemove, "/a/3", nil, 4))
+	assert.NotNil(t, err)
+	_, err = ApplyOperation(doc, NewOperation(OpReplace, "/a/0/b", 5.0, 4))
+	assert.NotNil(t, err)
+}
+
+func TestLeafTypes(t *testing.T) {
+	var origin, modified tson.Tson
+	assert.Nil(t, tson.Unmarshal([]byte(`{
+		"odometer" <1>: 18446744073709551615,
+		"gear" <1>: -1,
+		"trailer" <1>: null,
+		"matrix": [ [ <1> 1, <1> 2 ], [ <1> 3 ] ]
+	}`), &origin))
+	assert.Nil(t, tson.Unmarshal([]byte(`{
+		"odometer" <2>: 18446744073709551614,
+		"gear" <1>: -1,
+		"trailer" <2>: "hitched",
+		"cargo" <2>: null,
+		"matrix": [ [ <1> 1, <2> 2.5 ], [ <1> 3, <2> 4 ], [ <2> 5 ] ]
+	}`), &modified))
+
+	patch, err := GeneratePatchWithTimestamp(origin, modified)
+	assert.Nil(t, err)
+	assert.Contains(t, patch, NewOperation(OpReplace, "/odometer", uint64(math.MaxUint64-1), 2))
+	assert.Contains(t, patch, NewOperation(OpAdd, "/cargo", nil, 2))
+
+	// The patch, as is and through JSON and the binary encoding, turns origin into modified
+	b, err := json.Marshal(patch)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), "18446744073709551614")
+	fromJson, err := Unmarshal(b)
+	assert.Nil(t, err)
+	b, err = patch.MarshalBinary()
+	assert.Nil(t, err)
+	fromBinary, err := UnmarshalBinary(b)
+	assert.Nil(t, err)
+
+	for _, p := range []Patch{patch, fromJson, fromBinary} {
+		doc, _ := tson.Clone(origin)
+		doc, err := ApplyPatch(doc, p)
+		assert.Nil(t, err)
+		eq, err := tson.Equal(doc, modified)
+		assert.Nil(t, err)
+		assert.True(t, eq, p.String())
+	}
+}
+
+func TestArrayDiff(t *testing.T) {
+	parse := func(s string) tson.Tson {
+		var doc tson.Tson
+		assert.Nil(t, tson.Unmarshal([]byte(s), &doc))
+		return doc
+	}
+	origin := parse(`{ "tirePressure": [ <1> 32.1, <1> 31.8, <1> 32.0, <1> 31.9 ] }`)
+
+	for _, c := range []struct {
+		modified   string
+		opts       DiffOptions
+		minimal    Patch
+		positional int // The number of operations of the positional diff
+	}{
+		{ // Insertion at the front
+			`{ "tirePressure": [ <2> 30.0, <1> 32.1, <1> 31.8, <1> 32.0, <1> 31.9 ] }`, DiffOptions{},
+			Patch{NewOperation(OpAdd, "/tirePressure/0", 30.0, 2)}, 5,
+		},
+		{ // Removal in the middle
+			`{ "tirePressure": [ <1> 32.1, <1> 32.0, <1> 31.9 ] }`, DiffOptions{},
+			Patch{NewOperation(OpRemove, "/tirePressure/1", nil, 1)}, 3,
+		},
+		{ // Replacement, and a new timestamp
+			`{ "tirePressure": [ <1> 32.1, <2> 31.8, <2> 33.0, <1> 31.9 ] }`, DiffOptions{WithTimestamp: true},
+			Patch{NewOperation(OpReplace, "/tirePressure/1", 31.8, 2), NewOperation(OpReplace, "/tirePressure/2", 33.0, 2)}, 2,
+		},
+		{ // Fewer replaces than removes and adds
+			`{ "tirePressure": [ <1> 31.9, <2> 1.0, <2> 2.0 ] }`, DiffOptions{},
+			Patch{
+				NewOperation(OpReplace, "/tirePressure/0", 31.9, 1),
+				NewOperation(OpReplace, "/tirePressure/1", 1.0, 2),
+				NewOperation(OpReplace, "/tirePressure/2", 2.0, 2),
+				NewOperation(OpRemove, "/tirePressure/3", nil, 1),
+			}, 4,
+		},
+	} {
+		modified := parse(c.modified)
+		patch, err := GeneratePatchWith(origin, modified, c.opts)
+		assert.Nil(t, err)
+		assert.Equal(t, c.minimal, patch, c.modified)
+
+		c.opts.ArrayDiff = PositionalArrayDiff
+		positional, err := GeneratePatchWith(origin, modified, c.opts)
+		assert.Nil(t, err)
+		assert.Len(t, positional, c.positional, c.modified)
+
+		for _, p := range []Patch{patch, positional} {
+			doc, err := ApplyPatch(origin, p)
+			assert.Nil(t, err)
+			eq, err := tson.Equal(doc, modified)
+			assert.Nil(t, err)
+			assert.True(t, eq, p.String())
+		}
+	}
+
+	// Long arrays: every tenth element removed
+	long, thinned := tson.Array{}, tson.Array{}
+	for i := range 1000 {
+		long = append(long, tson.Leaf[int64]{Value: int64(i), Timestamp: 1})
+		if i%10 != 5 {
+			thinned = append(thinned, long[i])
+		}
+	}
+	patch, err := GeneratePatch(tson.Object{"a": long}, tson.Object{"a": thinned})
+	assert.Nil(t, err)
+	assert.Len(t, patch, 100)
+	for _, op := range patch {
+		assert.Equal(t, OpRemove, op.Op)
+	}
+
+	// Random arrays (of leaves, objects and arrays) diff into patches turning one into the other
+	rng := rand.New(rand.NewSource(1))
+	element := func() tson.Value {
+		leaf := tson.Leaf[int64]{Value: rng.Int63n(3), Timestamp: rng.Int63n(2)}
+		switch rng.Intn(4) {
+		case 0:
+			return tson.Object{"value": leaf}
+		case 1:
+			return tson.Array{leaf}
+		default:
+			return leaf
+		}
+	}
+	array := func() tson.Array {
+		a := make(tson.Array, rng.Intn(8))
+		for i := range a {
+			a[i] = element()
+		}
+		return a
+	}
+	for range 200 {
+		origin, modified := tson.Object{"a": array()}, tson.Object{"a": array()}
+		for _, arrayDiff := range []ArrayDiff{MinimalArrayDiff, PositionalArrayDiff} {
+			patch, err := GeneratePatchWith(origin, modified, DiffOptions{WithTimestamp: true, ArrayDiff: arrayDiff})
+			assert.Nil(t, err)
+			doc, err := ApplyPatch(origin, patch)
+			assert.Nil(t, err)
+			eq, err := tson.Equal(doc, modified)
+			assert.Nil(t, err)
+			assert.True(t, eq, patch.String())
+		}
+	}
+}
+
+func TestMoveCopyTest(t *testing.T) {
+	var origin tson.Tson
+	assert.Nil(t, tson.Unmarshal([]byte(`{
+		"speed" <1>: 80.0,
+		"gear" <1>: 3,
+		"location": { "latitude" <1>: 37.5, "longitude" <2>: 127.0 },
+		"tirePressure": [ <1> 32.0, <1> 31.8 ]
+	}`), &origin))
+	apply := func(patch Patch) (tson.Tson, error) {
+		doc, _ := tson.Clone(origin)
+		return ApplyPatch(doc, patch)
+	}
+
+	// move and copy keep the timestamps, unless the operation has one
+	doc, err := apply(Patch{
+		NewMoveOperation("/location", "/position", 0),
+		NewCopyOperation("/speed", "/tirePressure/0", 0),
+		NewCopyOperation("/gear", "/lastGear", 5),
+	})
+	assert.Nil(t, err)
+	var expected tson.Tson
+	assert.Nil(t, tson.Unmarshal([]byte(`{
+		"speed" <1>: 80.0,
+		"gear" <1>: 3,
+		"lastGear" <5>: 3,
+		"position": { "latitude" <1>: 37.5, "longitude" <2>: 127.0 },
+		"tirePressure": [ <1> 80.0, <1> 32.0, <1> 31.8 ]
+	}`), &expected))
+	eq, err := tson.Equal(doc, expected)
+	assert.Nil(t, err)
+	assert.True(t, eq, doc)
+
+	_, err = apply(Patch{NewMoveOperation("/location", "/location/home", 0)})
+	assert.NotNil(t, err)
+	_, err = apply(Patch{NewCopyOperation("/altitude", "/height", 0)})
+	assert.NotNil(t, err)
+
+	// test compares numbers by value, and the timestamp only if the operation has one
+	for _, op := range []Operation{
+		NewOperation(OpTest, "/speed", int64(80), 0),
+		NewOperation(OpTest, "/gear", 3.0, 1),
+		NewOperation(OpTest, "/location", map[string]any{"latitude": 37.5, "longitude": 127.0}, 2),
+		NewOperation(OpTest, "/tirePressure", []any{32.0, 31.8}, tson.DefaultTimestamp),
+	} {
+		_, err := apply(Patch{op})
+		assert.Nil(t, err, op.String())
+	}
+	for _, op := range []Operation{
+		NewOperation(OpTest, "/speed", 81.0, 0),
+		NewOperation(OpTest, "/speed", "80", 0),
+		NewOperation(OpTest, "/gear", 3.0, 2),
+		NewOperation(OpTest, "/location", map[string]any{"latitude": 37.5}, 0),
+		NewOperation(OpTest, "/altitude", 0.0, 0),
+	} {
+		_, err := apply(Patch{op})
+		assert.ErrorIs(t, err, ErrTestFailed, op.String())
+	}
+
+	// A failed test leaves the document as it was
+	doc, _ = tson.Clone(origin)
+	_, err = ApplyPatch(doc, Patch{
+		NewOperation(OpReplace, "/speed", 90.0, 3),
+		NewMoveOperation("/gear", "/lastGear", 0),
+		NewOperation(OpTest, "/speed", 80.0, 0),
+	})
+	assert.ErrorIs(t, err, ErrTestFailed)
+	eq, err = tson.Equal(doc, origin)
+	assert.Nil(t, err)
+	assert.True(t, eq)
+
+	// from goes through JSON and the binary encoding
+	patch := Patch{NewMoveOperation("/location", "/position", 0), NewCopyOperation("/speed", "/maxSpeed", 5)}
+	b, err := json.Marshal(patch)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"from":"/location"`)
+	fromJson, err := Unmarshal(b)
+	assert.Nil(t, err)
+	assert.Equal(t, patch, fromJson)
+	b, err = patch.MarshalBinary()
+	assert.Nil(t, err)
+	fromBinary, err := UnmarshalBinary(b)
+	assert.Nil(t, err)
+	assert.Equal(t, patch, fromBinary)
+}
+
+func TestInvert(t *testing.T) {
+	var origin tson.Tson
+	assert.Nil(t, tson.Unmarshal([]byte(`{
+		"speed" <1>: 80.0,
+		"gear" <1>: 3,
+		"trailer" <1>: null,
+		"location": { "latitude" <1>: 37.5, "longitude" <2>: 127.0 },
+		"tirePressure": [ <1> 32.0, <1> 31.8, <2> 32.1 ]
+	}`), &origin))
+	before, _ := tson.Clone(origin)
+
+	patch := Patch{
+		NewOperation(OpReplace, "/speed", "stopped", 5),
+		NewOperation(OpAdd, "/gear", int64(4), 5),
+		NewOperation(OpRemove, "/trailer", nil, 5),
+		NewOperation(OpAdd, "/driver/name", "Kim", 5),
+		NewOperation(OpReplace, "/location", map[string]any{"latitude": 38.0}, 5),
+		NewOperation(OpAdd, "/tirePressure/0", 30.0, 5),
+		NewOperation(OpAdd, "/tirePressure/-", 29.0, 5),
+		NewOperation(OpRemove, "/tirePressure/2", nil, 5),
+		NewOperation(OpReplace, "/tirePressure/6", 28.0, 5),
+		NewMoveOperation("/gear", "/lastGear", 6),
+		NewCopyOperation("/tirePressure/0", "/tirePressure/1", 0),
+		NewOperation(OpTest, "/speed", "stopped", 0),
+	}
+	inverse, err := Invert(origin, patch)
+	assert.Nil(t, err)
+	eq, err := tson.Equal(origin, before)
+	assert.Nil(t, err)
+	assert.True(t, eq, "Invert modified the document")
+	for _, op := range inverse {
+		assert.Contains(t, []OpType{OpAdd, OpRemove, OpReplace}, op.Op)
+	}
+	assert.Contains(t, inverse, NewOperation(OpReplace, "/speed", 80.0, 1))
+	assert.Contains(t, inverse, NewOperation(OpRemove, "/driver", nil, 5))
+
+	// The inverse restores the document, timestamps included
+	applied, err := ApplyPatch(origin, patch)
+	assert.Nil(t, err)
+	restored, err := ApplyPatch(applied, inverse)
+	assert.Nil(t, err)
+	eq, err = tson.Equal(restored, origin)
+	assert.Nil(t, err)
+	assert.True(t, eq, inverse.String())
+
+	// A patch that does not apply has no inverse
+	_, err = Invert(origin, Patch{NewOperation(OpRemove, "/tirePressure/9", nil, 5)})
+	assert.NotNil(t, err)
+	_, err = Invert(origin, Patch{NewOperation(OpTest, "/speed", 90.0, 0)})
+	assert.ErrorIs(t, err, ErrTestFailed)
+
+	// Generated patches between random documents are inverted too
+	rng := rand.New(rand.NewSource(2))
+	value := func() tson.Value {
+		switch rng.Intn(4) {
+		case 0:
+			return tson.Object{"value": tson.Leaf[float64]{Value: float64(rng.Intn(3)), Timestamp: rng.Int63n(3)}}
+		case 1:
+			return tson.Array{tson.Leaf[bool]{Value: rng.Intn(2) == 0, Timestamp: rng.Int63n(3)}}
+		default:
+			return tson.Leaf[int64]{Value: rng.Int63n(3), Timestamp: rng.Int63n(3)}
+		}
+	}
+	document := func() tson.Object {
+		doc := tson.Object{"a": tson.Array{}}
+		for _, key := range []string{"b", "c", "d"} {
+			if rng.Intn(2) == 0 {
+				doc[key] = value()
+			}
+		}
+		for range rng.Intn(6) {
+			doc["a"] = append(doc["a"].(tson.Array), value())
+		}
+		return doc
+	}
+	for range 200 {
+		origin, modified := document(), document()
+		patch, err := GeneratePatchWithTimestamp(origin, modified)
+		assert.Nil(t, err)
+		inverse, err := Invert(origin, patch)
+		assert.Nil(t, err)
+		patched, err := ApplyPatch(origin, patch)
+		assert.Nil(t, err)
+		restored, err := ApplyPatch(patched, inverse)
+		assert.Nil(t, err)
+		eq, err := tson.Equal(restored, origin)
+		assert.Nil(t, err)
+		assert.True(t, eq, inverse.String())
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	p, err := Unmarshal([]byte(patch))
+	assert.Nil(t, err)
+	p = append(p,
+		NewOperation(OpRemove, "/tirePressure/0", nil, 1999999000),
+		NewOperation(OpAdd, "/gear", 3, 2000000500),
+		NewOperation(OpAdd, "/trailer", map[string]any{"attached": true}, 2000000500),
+		NewOperation(OpTest, "/location/longitude", -150.4194, 2000000500),
+	)
+
+	b, err := p.MarshalBinary()
+	assert.Nil(t, err)
+	text, err := json.Marshal(p)
+	assert.Nil(t, err)
+	assert.Less(t, len(b), len(text)/2)
+
+	decoded, err := UnmarshalBinary(b)
+	assert.Nil(t, err)
+	assert.Equal(t, p, decoded)
+
+	// Truncated data
+	for i := range b {
+		_, err = UnmarshalBinary(b[:i])
+		assert.NotNil(t, err)
+	}
+
+	empty, err := Patch{}.MarshalBinary()
+	assert.Nil(t, err)
+	decoded, err = UnmarshalBinary(empty)
+	assert.Nil(t, err)
+	assert.Empty(t, decoded)
+
+	_, err = Patch{NewOperation("merge", "/a", 1.0, 0)}.MarshalBinary()
+	assert.NotNil(t, err)
+
+	// Other versions
+	for _, version := range []byte{0, BinaryVersion - 1, BinaryVersion + 1} {
+		_, err = UnmarshalBinary(append([]byte{version}, empty[1:]...))
+		assert.NotNil(t, err)
+	}
+}
+
+func TestDictionary(t *testing.T) {
+	const soc = "/Vehicle/Powertrain/TractionBattery/StateOfCharge/Current"
+	var (
+		sender   = NewDictionary("/Vehicle/Speed", soc)
+		receiver = NewDictionary(sender.Paths()...)
+		p        = Patch{
+			NewOperation(OpReplace, soc, 80.5, 1700000000),
+			NewOperation(OpAdd, "/Vehicle/Cabin/Door/Row1/IsOpen", true, 1700000100),
+		}
+	)
+
+	// The new path is listed once, then learned by both sides
+	b, err := p.MarshalBinaryWith(sender)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), soc)
+	assert.Contains(t, string(b), "/Vehicle/Cabin/Door/Row1/IsOpen")
+	decoded, err := UnmarshalBinaryWith(b, receiver)
+	assert.Nil(t, err)
+	assert.Equal(t, p, decoded)
+	assert.Equal(t, sender.Paths(), receiver.Paths())
+	id, ok := receiver.ID("/Vehicle/Cabin/Door/Row1/IsOpen")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), id)
+
+	again, err := p.MarshalBinaryWith(sender)
+	assert.Nil(t, err)
+	assert.Less(t, len(again), len(b)-len("/Vehicle/Cabin/Door/Row1/IsOpen"))
+	decoded, err = UnmarshalBinaryWith(again, receiver)
+	assert.Nil(t, err)
+	assert.Equal(t, p, decoded)
+
+	// Out of sync (the receiver missed a patch), or truncated data: the dictionary is kept as is
+	missed, err := Patch{NewOperation(OpAdd, "/Vehicle/Body/Lights/IsHighBeamOn", false, 1700000200)}.MarshalBinaryWith(sender)
+	assert.Nil(t, err)
+	b, err = Patch{NewOperation(OpAdd, "/Vehicle/Body/Horn/IsActive", false, 1700000300)}.MarshalBinaryWith(sender)
+	assert.Nil(t, err)
+	_, err = UnmarshalBinaryWith(b, receiver)
+	assert.NotNil(t, err)
+	_, err = UnmarshalBinaryWith(missed[:len(missed)-1], receiver)
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, receiver.Len())
+
+	path, ok := sender.Path(4)
+	assert.True(t, ok)
+	assert.Equal(t, "/Vehicle/Body/Horn/IsActive", path)
+	_, ok = sender.Path(5)
+	assert.False(t, ok)
+}
+
+func TestDecoder(t *testing.T) {
+	dec := NewDecoder(strings.NewReader(patch + "\n" + `[
+		{ "op": "remove", "path": "/engineOn", "timestamp": 2100000000 }
+	]`))
+
+	var patches []Patch
+	for dec.More() {
+		var p Patch
+		assert.Nil(t, dec.Decode(&p))
+		patches = append(patches, p)
+	}
+	assert.Len(t, patches, 2)
+	assert.Len(t, patches[0], 3)
+	assert.Equal(t, Patch{NewOperation(OpRemove, "/engineOn", nil, 2100000000)}, patches[1])
+
+	var p Patch
+	assert.Equal(t, io.EOF, dec.Decode(&p))
+}
@@ -14,6 +14,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -135,6 +136,39 @@ func (vss VssJson) LeafNodes() []map[string]any {
 	return nil
 }
 
+// Get the paths of the signals of the VSS schema, sorted,
+// in the form of the paths of the patches (e.g. "/Vehicle/Speed").
+// If a dataset (not a schema) is passed, nil is returned
+func (vss VssJson) SignalPaths() []string {
+	if vss.initialized {
+		return nil
+	}
+
+	signals := make(map[string]struct{})
+	for _, leafNode := range vss.LeafNodes() {
+		for key := range leafNode {
+			// The keys are the metadata of the signals (e.g. "Vehicle.Speed.datatype")
+			if idx := strings.LastIndex(key, "."); idx >= 0 {
+				signals["/"+strings.ReplaceAll(key[:idx], ".", "/")] = struct{}{}
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(signals))
+	for path := range signals {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Create a path dictionary of the signals of the VSS schema,
+// to encode the patches of the datasets generated from it.
+// Both sides of a link create the same dictionary from the same schema
+func (vss VssJson) Dictionary() *tsonpatch.Dictionary {
+	return tsonpatch.NewDictionary(vss.SignalPaths()...)
+}
+
 // Generate an initial random dataset based on the JSON schema
 func (vss VssJson) Generate(datasetSize float64, id int) *VssJson {
 	timestamp := time.Now().UnixNano()
@@ -236,6 +270,16 @@ func (vss VssJson) Generate(datasetSize float64, id int) *VssJson {
 				tsonValue:     rand.Intn(101),
 				tsonTimestamp: timestamp,
 			}
+		case dtype == "int64":
+			new[node] = map[string]any{
+				tsonValue:     rand.Int63() - rand.Int63(),
+				tsonTimestamp: timestamp,
+			}
+		case dtype == "uint64":
+			new[node] = map[string]any{
+				tsonValue:     rand.Uint64(), // Kept exact, beyond the precision of float64
+				tsonTimestamp: timestamp,
+			}
 		case dtype == "uint8[]":
 			new[node] = make([]any, 0)
 			for i := 0; i < rand.Intn(5)+1; i++ {
@@ -322,6 +366,21 @@ func (vss VssJson) GenerateNext(changeRate, maintainRate float64, id int, fileNo
 						tsonValue:     rand.Intn(201) - 100,
 						tsonTimestamp: timestamp,
 					}
+				case int64:
+					new[node] = map[string]any{
+						tsonValue:     rand.Int63() - rand.Int63(),
+						tsonTimestamp: timestamp,
+					}
+				case uint64:
+					new[node] = map[string]any{
+						tsonValue:     rand.Uint64(),
+						tsonTimestamp: timestamp,
+					}
+				case nil: // A null only gets a new timestamp
+					new[node] = map[string]any{
+						tsonValue:     nil,
+						tsonTimestamp: timestamp,
+					}
 				case float64:
 					new[node] = map[string]any{
 						tsonValue:     rand.Float64() * 100,
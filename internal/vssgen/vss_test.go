@@ -12,6 +12,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"reflect"
 	"testing"
 
 	"encoding/json"
@@ -265,3 +266,39 @@ func TestCase(t *testing.T) {
 	}
 	fmt.Println(string(data2))
 }
+
+func TestSignalPaths(t *testing.T) {
+	schema := &VssJson{
+		initialized: false,
+		data: map[string]any{
+			"Vehicle": map[string]any{
+				"children": map[string]any{
+					"Speed": map[string]any{"datatype": "float", "unit": "km/h"},
+					"ADAS": map[string]any{
+						"children": map[string]any{
+							"ABS": map[string]any{
+								"children": map[string]any{
+									"IsEnabled": map[string]any{"datatype": "boolean"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	paths := schema.SignalPaths()
+	if expected := []string{"/Vehicle/ADAS/ABS/IsEnabled", "/Vehicle/Speed"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+
+	dict := schema.Dictionary()
+	if id, ok := dict.ID("/Vehicle/Speed"); !ok || id != 1 {
+		t.Errorf("Expected ID 1 for /Vehicle/Speed, got %d (%t)", id, ok)
+	}
+
+	if paths := NewVssJson(file).Generate(1.0, 1).SignalPaths(); paths != nil {
+		t.Errorf("Expected no signal paths for a dataset, got %d", len(paths))
+	}
+}
@@ -88,8 +88,12 @@ func patchHandler(w http.ResponseWriter, r *http.Request) {
 		car, _          = strconv.Atoi(r.URL.Query().Get("car"))
 		maxpatch, _     = strconv.Atoi(r.URL.Query().Get("patch"))
 		originalTson, _ = os.ReadFile(fmt.Sprintf("dataset/car_%d/tson/%d_1.tson", car, car))
-		lgm             = logument.NewLogument(originalTson, nil)
+		lgm, err        = logument.NewLogument(originalTson, nil)
 	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	for i := 2; i <= maxpatch; i++ {
 		// Read patch file
@@ -97,14 +101,24 @@ func patchHandler(w http.ResponseWriter, r *http.Request) {
 		patch, _ := os.ReadFile(fileName)
 
 		// Append it to the PatchPool
-		lgm.Store(patch)
+		if err := lgm.Store(patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
 	// Apply the pathes and make a snapshot
-	lgm.Append()
+	if err := lgm.Append(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	// Take the snapshot
-	snapshot := lgm.Snapshot(1)
+	snapshot, err := lgm.Snapshot(lgm.Version[len(lgm.Version)-1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	result, _ := tson.MarshalIndent(snapshot, "", "  ")
 
 	// Send the result